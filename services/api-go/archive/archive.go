@@ -0,0 +1,220 @@
+// Package archive stores gateway request/response bodies for replay. Bodies
+// are content-addressed by their OTS hash, so an OTS line's input_hash and
+// output_hash point straight at them:
+//
+//	DIR/blobs/3f/3f1c0a9b2d4e5f60.json
+//	DIR/records/20260301.jsonl   one Entry per archived request
+package archive
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Entry links one archived exchange to its bodies.
+type Entry struct {
+	TS          string `json:"ts"`
+	RunID       string `json:"run_id"`
+	ReqID       string `json:"req_id"`
+	Method      string `json:"method"`
+	URI         string `json:"uri"`
+	ContentType string `json:"content_type"`
+	Status      int    `json:"status"`
+	InputHash   string `json:"input_hash"`
+	OutputHash  string `json:"output_hash"`
+
+	// Headers holds the ReplayHeaders the request carried; Auth is the kind
+	// of credential it used ("api_key", "jwt", "signed" or "cert").
+	Headers map[string]string `json:"headers,omitempty"`
+	Auth    string            `json:"auth,omitempty"`
+}
+
+// ReplayHeaders are the request headers that change a response without
+// carrying a secret, so they are archived and sent again on replay.
+var ReplayHeaders = []string{"X-Subject-Id", "Cache-Control"}
+
+var hashPattern = regexp.MustCompile(`^[0-9a-f]{16}$`)
+
+// ErrNotFound is returned for a hash with no stored body.
+var ErrNotFound = errors.New("archive: body not found")
+
+// Store is an archive rooted at Dir. Its methods are not safe for
+// concurrent writers; the gateway funnels writes through one goroutine.
+type Store struct {
+	Dir string
+}
+
+func (s Store) blobPath(hash string) (string, error) {
+	if !hashPattern.MatchString(hash) {
+		return "", fmt.Errorf("archive: invalid hash %q", hash)
+	}
+	return filepath.Join(s.Dir, "blobs", hash[:2], hash+".json"), nil
+}
+
+// putBlob writes body under hash unless it is already stored, in which case
+// it only refreshes the file time so retention keeps bodies still in use.
+func (s Store) putBlob(hash string, body []byte, now time.Time) error {
+	path, err := s.blobPath(hash)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err == nil {
+		return os.Chtimes(path, now, now)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".blob-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(body); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Put stores the bodies of e (output may be nil when it was not captured)
+// and appends e to the day's record file.
+func (s Store) Put(e Entry, input, output []byte, now time.Time) error {
+	if err := s.putBlob(e.InputHash, input, now); err != nil {
+		return err
+	}
+	if e.OutputHash != "" {
+		if err := s.putBlob(e.OutputHash, output, now); err != nil {
+			return err
+		}
+	}
+	dir := filepath.Join(s.Dir, "records")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, now.UTC().Format("20060102")+".jsonl"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Blob returns the body stored under hash.
+func (s Store) Blob(hash string) ([]byte, error) {
+	path, err := s.blobPath(hash)
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return b, err
+}
+
+// Entries calls fn for every record, oldest day first.
+func (s Store) Entries(fn func(Entry) error) error {
+	files, err := filepath.Glob(filepath.Join(s.Dir, "records", "*.jsonl"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+	for _, name := range files {
+		if err := readEntries(name, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readEntries(name string, fn func(Entry) error) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		if strings.TrimSpace(sc.Text()) == "" {
+			continue
+		}
+		var e Entry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			return fmt.Errorf("%s:%d: %w", name, n, err)
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
+
+// Prune deletes files older than retention (when positive) and then the
+// oldest remaining files until the archive fits in maxBytes (when positive).
+// It returns the number of files removed.
+func (s Store) Prune(now time.Time, retention time.Duration, maxBytes int64) (int, error) {
+	type file struct {
+		path string
+		size int64
+		mod  time.Time
+	}
+	var files []file
+	var total int64
+	err := filepath.WalkDir(s.Dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".") {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		files = append(files, file{path, info.Size(), info.ModTime()})
+		total += info.Size()
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].mod.Before(files[j].mod) })
+
+	removed := 0
+	for _, f := range files {
+		expired := retention > 0 && now.Sub(f.mod) > retention
+		overCap := maxBytes > 0 && total > maxBytes
+		if !expired && !overCap {
+			break
+		}
+		if err := os.Remove(f.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return removed, err
+		}
+		total -= f.size
+		removed++
+	}
+	return removed, nil
+}
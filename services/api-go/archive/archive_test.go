@@ -0,0 +1,67 @@
+package archive
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStore_PutBlobEntries(t *testing.T) {
+	s := Store{Dir: t.TempDir()}
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	e := Entry{ReqID: "a", Method: "POST", URI: "/api/v1/score", Status: 200, InputHash: "00112233445566aa", OutputHash: "00112233445566bb"}
+	if err := s.Put(e, []byte(`{"in":1}`), []byte(`{"out":1}`), now); err != nil {
+		t.Fatal(err)
+	}
+	// The same input again only adds a record.
+	e.ReqID, e.OutputHash = "b", ""
+	if err := s.Put(e, []byte(`{"in":1}`), nil, now); err != nil {
+		t.Fatal(err)
+	}
+
+	if b, err := s.Blob("00112233445566aa"); err != nil || string(b) != `{"in":1}` {
+		t.Fatalf("input blob %q, %v", b, err)
+	}
+	if _, err := s.Blob("ffffffffffffffff"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("missing blob: %v", err)
+	}
+	if _, err := s.Blob("../../etc/passwd"); err == nil {
+		t.Fatal("path traversal accepted")
+	}
+	var ids []string
+	if err := s.Entries(func(e Entry) error { ids = append(ids, e.ReqID); return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 2 || ids[0] != "a" || ids[1] != "b" {
+		t.Fatalf("entries %v", ids)
+	}
+	if _, err := os.Stat(filepath.Join(s.Dir, "records", "20260301.jsonl")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStore_Prune(t *testing.T) {
+	s := Store{Dir: t.TempDir()}
+	now := time.Now()
+	write := func(name string, size int, age time.Duration) {
+		path := filepath.Join(s.Dir, "blobs", name)
+		_ = os.MkdirAll(filepath.Dir(path), 0o755)
+		if err := os.WriteFile(path, make([]byte, size), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		_ = os.Chtimes(path, now.Add(-age), now.Add(-age))
+	}
+	write("expired", 10, 48*time.Hour)
+	write("old", 10, 3*time.Hour)
+	write("new", 10, time.Minute)
+
+	n, err := s.Prune(now, 24*time.Hour, 15)
+	if err != nil || n != 2 {
+		t.Fatalf("removed %d, %v", n, err)
+	}
+	if _, err := os.Stat(filepath.Join(s.Dir, "blobs", "new")); err != nil {
+		t.Fatal("newest file pruned")
+	}
+}
@@ -0,0 +1,34 @@
+// Command fake-vertex serves the fakevertex stand-in over HTTP so a local
+// gateway can run with VERTEX_BASE_URL=http://localhost:9090 VERTEX_AUTH=none.
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"time"
+
+	"picca/api-go/internal/fakevertex"
+)
+
+func main() {
+	addr := flag.String("addr", ":9090", "listen address")
+	text := flag.String("text", "", "candidate text to return (default depends on request mode)")
+	status := flag.Int("status", http.StatusOK, "HTTP status to return")
+	finish := flag.String("finish-reason", "STOP", "finishReason to return")
+	delay := flag.Duration("delay", 0, "delay before each response")
+	flag.Parse()
+
+	fake := fakevertex.New()
+	fake.Script("*", "*", fakevertex.Response{Status: *status, Text: *text, FinishReason: *finish, Delay: *delay})
+
+	srv := &http.Server{
+		Addr:              *addr,
+		Handler:           fake,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	log.Printf("fake-vertex listening on %s", *addr)
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Fatalf("listen: %v", err)
+	}
+}
@@ -0,0 +1,87 @@
+// Command picca-ots works with Observation Trace Sheet JSONL files.
+//
+//	picca-ots validate [-max-errors N] FILE...   ("-" reads stdin)
+//
+// validate checks every line from the gateway or the ML service against the
+// ots schema, prints FILE:LINE: problems and exits 1 when any line fails.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"picca/api-go/ots"
+)
+
+func main() {
+	if len(os.Args) < 2 || os.Args[1] != "validate" {
+		fmt.Fprintln(os.Stderr, "usage: picca-ots validate [-max-errors N] FILE...")
+		os.Exit(2)
+	}
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	maxErrors := fs.Int("max-errors", 100, "stop reporting a file after this many bad lines (0 = no limit)")
+	_ = fs.Parse(os.Args[2:])
+	if fs.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "picca-ots validate: no files given")
+		os.Exit(2)
+	}
+
+	failed := false
+	for _, name := range fs.Args() {
+		lines, bad, err := validateFile(name, os.Stdout, *maxErrors)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+			failed = true
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "%s: %d lines, %d invalid\n", name, lines, bad)
+		failed = failed || bad > 0
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func validateFile(name string, out io.Writer, maxErrors int) (lines, bad int, err error) {
+	var r io.Reader = os.Stdin
+	if name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return 0, 0, err
+		}
+		defer f.Close()
+		r = f
+	}
+	return validate(r, name, out, maxErrors)
+}
+
+// validate reports each invalid line of r to out; blank lines are skipped.
+func validate(r io.Reader, name string, out io.Writer, maxErrors int) (lines, bad int, err error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 4<<20)
+	for n := 1; sc.Scan(); n++ {
+		line := sc.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		lines++
+		errs := ots.Validate(line)
+		if len(errs) == 0 {
+			continue
+		}
+		bad++
+		if maxErrors > 0 && bad > maxErrors {
+			continue
+		}
+		for _, e := range errs {
+			fmt.Fprintf(out, "%s:%d: %v\n", name, n, e)
+		}
+		if maxErrors > 0 && bad == maxErrors {
+			fmt.Fprintf(out, "%s: further invalid lines not shown\n", name)
+		}
+	}
+	return lines, bad, sc.Err()
+}
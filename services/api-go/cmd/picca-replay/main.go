@@ -0,0 +1,160 @@
+// Command picca-replay re-sends archived gateway requests (see ARCHIVE_DIR)
+// to a target gateway and compares each response with the recorded status
+// and output_hash:
+//
+//	picca-replay -archive ./archive -target http://localhost:8080 -api-key $API_KEY
+//
+// It prints one MATCH or MISMATCH line per request and exits 1 when any
+// request differs or could not be replayed. Archived X-Subject-Id and
+// Cache-Control headers are sent again; requests made with a JWT, request
+// signature or client certificate cannot be re-authenticated with an API key
+// and are reported as SKIP instead. Explain responses come from a generative
+// model, so their output_hash is expected to drift.
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"picca/api-go/archive"
+	"picca/api-go/ots"
+)
+
+type options struct {
+	target  string
+	apiKey  string
+	reqID   string
+	path    string
+	since   string
+	limit   int
+	timeout time.Duration
+}
+
+func main() {
+	dir := flag.String("archive", "", "archive directory written by the gateway (ARCHIVE_DIR)")
+	var o options
+	flag.StringVar(&o.target, "target", "http://localhost:8080", "gateway base URL")
+	flag.StringVar(&o.apiKey, "api-key", os.Getenv("API_KEY"), "X-API-Key to send (default $API_KEY)")
+	flag.StringVar(&o.reqID, "req-id", "", "replay only this request ID")
+	flag.StringVar(&o.path, "path", "", "replay only requests to this path")
+	flag.StringVar(&o.since, "since", "", "replay only requests at or after this RFC 3339 time")
+	flag.IntVar(&o.limit, "limit", 0, "stop after this many requests (0 = all)")
+	flag.DurationVar(&o.timeout, "timeout", 15*time.Second, "per-request timeout")
+	flag.Parse()
+	if *dir == "" {
+		fmt.Fprintln(os.Stderr, "picca-replay: -archive is required")
+		os.Exit(2)
+	}
+
+	res, err := replay(archive.Store{Dir: *dir}, o, &http.Client{Timeout: o.timeout}, os.Stdout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "picca-replay: %v\n", err)
+		os.Exit(2)
+	}
+	fmt.Fprintf(os.Stderr, "%d replayed, %d mismatched, %d skipped\n", res.total, res.failed, res.skipped)
+	if res.failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// result counts replayed requests, those that differed and those skipped
+// because their credentials cannot be sent again.
+type result struct {
+	total, failed, skipped int
+}
+
+func replay(store archive.Store, o options, client *http.Client, out io.Writer) (res result, err error) {
+	var since time.Time
+	if o.since != "" {
+		if since, err = time.Parse(time.RFC3339, o.since); err != nil {
+			return res, fmt.Errorf("-since: %w", err)
+		}
+	}
+	target := strings.TrimRight(o.target, "/")
+	errStop := errors.New("limit reached")
+
+	err = store.Entries(func(e archive.Entry) error {
+		if o.reqID != "" && e.ReqID != o.reqID {
+			return nil
+		}
+		if o.path != "" && strings.SplitN(e.URI, "?", 2)[0] != o.path {
+			return nil
+		}
+		if !since.IsZero() {
+			if ts, err := time.Parse(time.RFC3339Nano, e.TS); err != nil || ts.Before(since) {
+				return nil
+			}
+		}
+		if o.limit > 0 && res.total+res.skipped >= o.limit {
+			return errStop
+		}
+		if e.Auth != "" && e.Auth != "api_key" {
+			res.skipped++
+			fmt.Fprintf(out, "SKIP     %s %s %s: %s credentials cannot be replayed\n", e.ReqID, e.Method, e.URI, e.Auth)
+			return nil
+		}
+		res.total++
+		if msg := replayOne(store, e, target, o.apiKey, client); msg != "" {
+			res.failed++
+			fmt.Fprintf(out, "MISMATCH %s %s %s: %s\n", e.ReqID, e.Method, e.URI, msg)
+			return nil
+		}
+		fmt.Fprintf(out, "MATCH    %s %s %s\n", e.ReqID, e.Method, e.URI)
+		return nil
+	})
+	if err == errStop {
+		err = nil
+	}
+	return res, err
+}
+
+// replayOne sends one archived request and describes how the response
+// differs from the record, or returns "" when it matches.
+func replayOne(store archive.Store, e archive.Entry, target, apiKey string, client *http.Client) string {
+	body, err := store.Blob(e.InputHash)
+	if err != nil {
+		return err.Error()
+	}
+	if got := ots.BodyHash(body); got != e.InputHash {
+		return fmt.Sprintf("stored input hashes to %s, not %s", got, e.InputHash)
+	}
+	req, err := http.NewRequest(e.Method, target+e.URI, bytes.NewReader(body))
+	if err != nil {
+		return err.Error()
+	}
+	for name, v := range e.Headers {
+		req.Header.Set(name, v)
+	}
+	req.Header.Set("Content-Type", e.ContentType)
+	req.Header.Set("X-Request-Id", "replay."+e.ReqID)
+	if apiKey != "" {
+		req.Header.Set("X-API-Key", apiKey)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err.Error()
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, ots.MaxCaptureBytes+1))
+	if err != nil {
+		return err.Error()
+	}
+
+	var diffs []string
+	if resp.StatusCode != e.Status {
+		diffs = append(diffs, fmt.Sprintf("status %d -> %d", e.Status, resp.StatusCode))
+	}
+	if e.OutputHash != "" {
+		if got := ots.BodyHash(respBody); got != e.OutputHash {
+			diffs = append(diffs, fmt.Sprintf("output_hash %s -> %s", e.OutputHash, got))
+		}
+	}
+	return strings.Join(diffs, ", ")
+}
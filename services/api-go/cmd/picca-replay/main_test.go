@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"picca/api-go/archive"
+	"picca/api-go/ots"
+)
+
+func TestReplay_ComparesStatusAndOutputHash(t *testing.T) {
+	store := archive.Store{Dir: t.TempDir()}
+	now := time.Now()
+	put := func(reqID, in, out string, status int, auth string) {
+		e := archive.Entry{
+			TS: now.UTC().Format(time.RFC3339Nano), ReqID: reqID, Method: http.MethodPost, URI: "/api/v1/score",
+			ContentType: "application/json", Status: status, InputHash: ots.BodyHash([]byte(in)), OutputHash: ots.BodyHash([]byte(out)),
+			Headers: map[string]string{"X-Subject-Id": "athlete-" + reqID}, Auth: auth,
+		}
+		if err := store.Put(e, []byte(in), []byte(out), now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	put("same", `{"n":1}`, `{"score":1}`, 200, "api_key")
+	put("drift", `{"n":2}`, `{"score":99}`, 200, "")
+	put("jwt", `{"n":3}`, `{"score":3}`, 200, "jwt")
+	put("signed", `{"n":4}`, `{"score":0}`, 200, "signed")
+
+	var gotKey, gotID, gotSubject string
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotKey, gotID, gotSubject = r.Header.Get("X-API-Key"), r.Header.Get("X-Request-Id"), r.Header.Get("X-Subject-Id")
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(bytes.Replace(body, []byte(`"n"`), []byte(`"score"`), 1))
+	}))
+	defer target.Close()
+
+	var out bytes.Buffer
+	res, err := replay(store, options{target: target.URL, apiKey: "k"}, target.Client(), &out)
+	if err != nil || res != (result{total: 2, failed: 1, skipped: 2}) {
+		t.Fatalf("result=%+v err=%v\n%s", res, err, out.String())
+	}
+	report := out.String()
+	if !strings.Contains(report, "MATCH    same") || !strings.Contains(report, "MISMATCH drift POST /api/v1/score: output_hash") {
+		t.Fatalf("report:\n%s", report)
+	}
+	if !strings.Contains(report, "SKIP     jwt POST /api/v1/score: jwt credentials") || !strings.Contains(report, "SKIP     signed POST /api/v1/score: signed credentials") {
+		t.Fatalf("unreplayable entries not skipped:\n%s", report)
+	}
+	if gotKey != "k" || gotID != "replay.drift" || gotSubject != "athlete-drift" {
+		t.Fatalf("headers: key=%q id=%q subject=%q", gotKey, gotID, gotSubject)
+	}
+
+	out.Reset()
+	if res, _ := replay(store, options{target: target.URL, reqID: "same"}, target.Client(), &out); res.total != 1 {
+		t.Fatalf("req-id filter replayed %d", res.total)
+	}
+}
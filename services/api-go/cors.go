@@ -0,0 +1,154 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultCORSMethods       = "GET, POST, OPTIONS"
+	defaultCORSHeaders       = "Content-Type, Authorization, X-API-Key, X-Request-Id, X-Subject-Id, Cache-Control, traceparent, tracestate"
+	defaultCORSExposeHeaders = "X-Request-Id, X-Session-Id, RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset, Retry-After"
+)
+
+// corsPolicy is read from CORS_ALLOWED_ORIGINS (exact origins, "*", or
+// wildcard subdomains like https://*.example.com), CORS_ALLOWED_METHODS,
+// CORS_ALLOWED_HEADERS, CORS_EXPOSE_HEADERS, CORS_ALLOW_CREDENTIALS and
+// CORS_MAX_AGE (seconds). No origins configured means CORS is off; "*" is
+// ignored when credentials are allowed.
+type corsPolicy struct {
+	origins     []string
+	methods     []string
+	headers     []string
+	expose      string
+	credentials bool
+	maxAge      int
+}
+
+func splitList(v string) []string {
+	var out []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+func envOr(name, def string) string {
+	if v := strings.TrimSpace(os.Getenv(name)); v != "" {
+		return v
+	}
+	return def
+}
+
+func currentCORSPolicy() corsPolicy {
+	p := corsPolicy{
+		origins: splitList(os.Getenv("CORS_ALLOWED_ORIGINS")),
+		methods: splitList(strings.ToUpper(envOr("CORS_ALLOWED_METHODS", defaultCORSMethods))),
+		headers: splitList(envOr("CORS_ALLOWED_HEADERS", defaultCORSHeaders)),
+		expose:  envOr("CORS_EXPOSE_HEADERS", defaultCORSExposeHeaders),
+		maxAge:  600,
+	}
+	p.credentials, _ = strconv.ParseBool(os.Getenv("CORS_ALLOW_CREDENTIALS"))
+	if v := os.Getenv("CORS_MAX_AGE"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			p.maxAge = n
+		}
+	}
+	return p
+}
+
+// allowsOrigin matches exact entries case-insensitively. A "scheme://*.domain"
+// entry matches any subdomain of domain on that scheme, but not domain itself.
+func (p corsPolicy) allowsOrigin(origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, o := range p.origins {
+		o = strings.ToLower(o)
+		switch {
+		case o == "*" && !p.credentials:
+			return true
+		case o == origin:
+			return true
+		case strings.Contains(o, "://*."):
+			scheme, domain, _ := strings.Cut(o, "://*.")
+			rest, ok := strings.CutPrefix(origin, scheme+"://")
+			if ok && strings.HasSuffix(rest, "."+domain) && !strings.ContainsAny(rest, "/@") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func containsFold(list []string, v string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// corsMiddleware applies the policy to every request carrying an Origin
+// header and answers preflights itself, whether or not the route has an
+// OPTIONS handler. Disallowed origins get no CORS headers and are logged.
+func corsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		if origin == "" {
+			c.Next()
+			return
+		}
+		p := currentCORSPolicy()
+		if len(p.origins) == 0 {
+			c.Next()
+			return
+		}
+		preflight := c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != ""
+		c.Writer.Header().Add("Vary", "Origin")
+		if !p.allowsOrigin(origin) {
+			log.Printf("cors: rejected origin %q for %s %s", origin, c.Request.Method, c.Request.URL.Path)
+			if preflight {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "origin not allowed", "reason_code": "CORS_ORIGIN_DENIED"})
+				return
+			}
+			c.Next()
+			return
+		}
+
+		c.Header("Access-Control-Allow-Origin", origin)
+		if p.credentials {
+			c.Header("Access-Control-Allow-Credentials", "true")
+		}
+		if !preflight {
+			c.Header("Access-Control-Expose-Headers", p.expose)
+			c.Next()
+			return
+		}
+
+		if method := c.GetHeader("Access-Control-Request-Method"); !containsFold(p.methods, method) {
+			log.Printf("cors: rejected method %s from %q for %s", method, origin, c.Request.URL.Path)
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "method not allowed", "reason_code": "CORS_METHOD_DENIED"})
+			return
+		}
+		for _, h := range splitList(c.GetHeader("Access-Control-Request-Headers")) {
+			if !containsFold(p.headers, h) {
+				log.Printf("cors: rejected header %s from %q for %s", h, origin, c.Request.URL.Path)
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "header not allowed", "reason_code": "CORS_HEADER_DENIED"})
+				return
+			}
+		}
+		c.Writer.Header().Add("Vary", "Access-Control-Request-Method")
+		c.Writer.Header().Add("Vary", "Access-Control-Request-Headers")
+		c.Header("Access-Control-Allow-Methods", strings.Join(p.methods, ", "))
+		c.Header("Access-Control-Allow-Headers", strings.Join(p.headers, ", "))
+		c.Header("Access-Control-Max-Age", strconv.Itoa(p.maxAge))
+		c.AbortWithStatus(http.StatusNoContent)
+	}
+}
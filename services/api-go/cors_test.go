@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCORSPolicy_AllowsOrigin(t *testing.T) {
+	p := corsPolicy{origins: []string{"https://app.example.com", "https://*.picca.dev"}}
+	cases := map[string]bool{
+		"https://app.example.com":      true,
+		"HTTPS://APP.EXAMPLE.COM":      true,
+		"https://staging.picca.dev":    true,
+		"https://a.b.picca.dev":        true,
+		"https://picca.dev":            false,
+		"http://staging.picca.dev":     false,
+		"https://evilpicca.dev":        false,
+		"https://app.example.com.evil": false,
+	}
+	for origin, want := range cases {
+		if got := p.allowsOrigin(origin); got != want {
+			t.Errorf("allowsOrigin(%q) = %v, want %v", origin, got, want)
+		}
+	}
+	if (corsPolicy{origins: []string{"*"}, credentials: true}).allowsOrigin("https://x.test") {
+		t.Error("wildcard must not apply with credentials")
+	}
+}
+
+func TestCORSMiddleware_Preflight(t *testing.T) {
+	setupExplainTest(t)
+	t.Setenv("CORS_ALLOWED_ORIGINS", "https://*.picca.dev")
+	t.Setenv("CORS_ALLOW_CREDENTIALS", "true")
+	t.Setenv("CORS_MAX_AGE", "120")
+	r := newRouter()
+
+	preflight := func(path, origin, headers string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodOptions, path, nil)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", "POST")
+		req.Header.Set("Access-Control-Request-Headers", headers)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := preflight("/api/v1/score", "https://web.picca.dev", "content-type, x-api-key")
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("want 204, got %d %s", w.Code, w.Body.String())
+	}
+	h := w.Header()
+	if h.Get("Access-Control-Allow-Origin") != "https://web.picca.dev" || h.Get("Access-Control-Allow-Credentials") != "true" || h.Get("Access-Control-Max-Age") != "120" || h.Get("Access-Control-Allow-Methods") == "" {
+		t.Fatalf("unexpected preflight headers %v", h)
+	}
+
+	if w := preflight("/api/v1/score", "https://evil.test", "content-type"); w.Code != http.StatusForbidden || w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Fatalf("denied origin: %d %v", w.Code, w.Header())
+	}
+	if w := preflight("/api/v1/score", "https://web.picca.dev", "x-internal"); w.Code != http.StatusForbidden {
+		t.Fatalf("denied header: %d", w.Code)
+	}
+	// Traced browser clients send W3C trace context on explain calls.
+	if w := preflight("/api/v1/explain", "https://web.picca.dev", "content-type, x-api-key, traceparent, tracestate"); w.Code != http.StatusNoContent {
+		t.Fatalf("trace context headers: %d %s", w.Code, w.Body.String())
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/explain", nil)
+	req.Header.Set("Origin", "https://web.picca.dev")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Header().Get("Access-Control-Allow-Origin") != "https://web.picca.dev" || w.Header().Get("Access-Control-Expose-Headers") == "" {
+		t.Fatalf("actual request missing CORS headers: %v", w.Header())
+	}
+}
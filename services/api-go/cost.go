@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type vertexUsageMetadata struct {
+	PromptTokenCount     int `json:"promptTokenCount"`
+	CandidatesTokenCount int `json:"candidatesTokenCount"`
+	TotalTokenCount      int `json:"totalTokenCount"`
+}
+
+type tokenUsage struct {
+	PromptTokens    int     `json:"prompt_tokens"`
+	CandidateTokens int     `json:"candidate_tokens"`
+	TotalTokens     int     `json:"total_tokens"`
+	CostYen         float64 `json:"cost_yen"`
+	Priced          bool    `json:"priced"`
+}
+
+func (u *tokenUsage) add(o tokenUsage) {
+	u.PromptTokens += o.PromptTokens
+	u.CandidateTokens += o.CandidateTokens
+	u.TotalTokens += o.TotalTokens
+	u.CostYen += o.CostYen
+	u.Priced = u.Priced || o.Priced
+}
+
+// modelPrice is expressed in yen per one million tokens, matching how Vertex
+// publishes Gemini pricing.
+type modelPrice struct {
+	InputPer1M  float64 `json:"input_per_1m_yen"`
+	OutputPer1M float64 `json:"output_per_1m_yen"`
+}
+
+var (
+	priceMu    sync.Mutex
+	priceKey   string
+	priceCache map[string]modelPrice
+)
+
+// priceTable reads VERTEX_PRICE_TABLE (inline JSON) or VERTEX_PRICE_TABLE_FILE,
+// keyed by model name. The parsed table is reused until the variable or the
+// file's modification time changes.
+func priceTable() map[string]modelPrice {
+	inline := strings.TrimSpace(os.Getenv("VERTEX_PRICE_TABLE"))
+	path := strings.TrimSpace(os.Getenv("VERTEX_PRICE_TABLE_FILE"))
+	key := "inline|" + inline
+	if inline == "" && path != "" {
+		info, err := os.Stat(path)
+		if err != nil {
+			log.Printf("cost: read price table: %v", err)
+			return nil
+		}
+		key = fmt.Sprintf("file|%s|%d|%d", path, info.ModTime().UnixNano(), info.Size())
+	}
+
+	priceMu.Lock()
+	defer priceMu.Unlock()
+	if key == priceKey {
+		return priceCache
+	}
+	priceKey, priceCache = key, parsePriceTable(inline, path)
+	return priceCache
+}
+
+func parsePriceTable(inline, path string) map[string]modelPrice {
+	raw := []byte(inline)
+	if len(raw) == 0 && path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			log.Printf("cost: read price table: %v", err)
+			return nil
+		}
+		raw = b
+	}
+	if len(raw) == 0 {
+		return nil
+	}
+	var table map[string]modelPrice
+	if err := json.Unmarshal(raw, &table); err != nil {
+		log.Printf("cost: parse price table: %v", err)
+		return nil
+	}
+	return table
+}
+
+func priceUsage(model string, md vertexUsageMetadata) tokenUsage {
+	u := tokenUsage{
+		PromptTokens:    md.PromptTokenCount,
+		CandidateTokens: md.CandidatesTokenCount,
+		TotalTokens:     md.TotalTokenCount,
+	}
+	if u.TotalTokens == 0 {
+		u.TotalTokens = u.PromptTokens + u.CandidateTokens
+	}
+	if p, ok := priceTable()[model]; ok {
+		u.CostYen = (float64(u.PromptTokens)*p.InputPer1M + float64(u.CandidateTokens)*p.OutputPer1M) / 1e6
+		u.Priced = true
+	}
+	return u
+}
+
+type costTotals struct {
+	Requests int64 `json:"requests"`
+	tokenUsage
+	CostPer1kYen float64 `json:"cost_per_1k_yen"`
+}
+
+// costLedger accumulates explain spend per key and per model since startup.
+type costLedger struct {
+	mu      sync.Mutex
+	since   time.Time
+	byKey   map[string]*costTotals
+	byModel map[string]*costTotals
+}
+
+var explainCosts = newCostLedger()
+
+func newCostLedger() *costLedger {
+	return &costLedger{
+		since:   time.Now().UTC(),
+		byKey:   map[string]*costTotals{},
+		byModel: map[string]*costTotals{},
+	}
+}
+
+func (l *costLedger) entries(key, model string) []*costTotals {
+	k, ok := l.byKey[key]
+	if !ok {
+		k = &costTotals{}
+		l.byKey[key] = k
+	}
+	m, ok := l.byModel[model]
+	if !ok {
+		m = &costTotals{}
+		l.byModel[model] = m
+	}
+	return []*costTotals{k, m}
+}
+
+func (l *costLedger) addRequest(key, model string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, t := range l.entries(key, model) {
+		t.Requests++
+	}
+}
+
+func (l *costLedger) addUsage(key, model string, u tokenUsage) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, t := range l.entries(key, model) {
+		t.add(u)
+	}
+}
+
+func (l *costLedger) snapshot() gin.H {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var total costTotals
+	copyTotals := func(src map[string]*costTotals) map[string]costTotals {
+		out := make(map[string]costTotals, len(src))
+		for name, t := range src {
+			c := *t
+			if c.Requests > 0 {
+				c.CostPer1kYen = c.CostYen / float64(c.Requests) * 1000
+			}
+			out[name] = c
+		}
+		return out
+	}
+	byModel := copyTotals(l.byModel)
+	for _, t := range byModel {
+		total.Requests += t.Requests
+		total.add(t.tokenUsage)
+	}
+	if total.Requests > 0 {
+		total.CostPer1kYen = total.CostYen / float64(total.Requests) * 1000
+	}
+	return gin.H{
+		"since":    l.since.Format(time.RFC3339),
+		"total":    total,
+		"by_key":   copyTotals(l.byKey),
+		"by_model": byModel,
+	}
+}
+
+func keyName(c *gin.Context) string {
+	if p, ok := currentPrincipal(c); ok {
+		return p.Name
+	}
+	return "default"
+}
+
+// recordExplainUsage charges one Vertex call to the ledger and accumulates it
+// on the request so the response and OTS line can report it.
+func recordExplainUsage(c *gin.Context, model string, md vertexUsageMetadata) {
+	u := priceUsage(model, md)
+	explainCosts.addUsage(keyName(c), model, u)
+	quotaUsage.addTokens(keyName(c), int64(u.TotalTokens))
+
+	total := explainUsage(c)
+	total.add(u)
+	c.Set("explain_usage", total)
+	annotateOTS(c.Request, "usage", total)
+}
+
+// countExplainRequest adds the request to the ledger under the model that
+// served it. Handlers that call Vertex more than once count only the first.
+func countExplainRequest(c *gin.Context, model string) {
+	if c.GetBool("explain_counted") {
+		return
+	}
+	c.Set("explain_counted", true)
+	explainCosts.addRequest(keyName(c), model)
+}
+
+func explainUsage(c *gin.Context) tokenUsage {
+	if v, ok := c.Get("explain_usage"); ok {
+		if u, ok := v.(tokenUsage); ok {
+			return u
+		}
+	}
+	return tokenUsage{}
+}
+
+func adminCostHandler(c *gin.Context) {
+	requestID(c)
+	if !authorize(c, scopeAdmin) {
+		return
+	}
+	c.JSON(http.StatusOK, explainCosts.snapshot())
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestExplainUsageAndCostLedger(t *testing.T) {
+	prev := explainCosts
+	explainCosts = newCostLedger()
+	t.Cleanup(func() { explainCosts = prev })
+
+	stubVertexBodies(t, `{"candidates":[{"content":{"parts":[{"text":"Metrics look strong."}]},"finishReason":"STOP"}],`+
+		`"usageMetadata":{"promptTokenCount":40,"candidatesTokenCount":10,"totalTokenCount":50}}`)
+	t.Setenv("VERTEX_PRICE_TABLE", `{"gemini-2.5-flash-lite":{"input_per_1m_yen":15,"output_per_1m_yen":60}}`)
+	t.Setenv("ADMIN_API_KEY", "admin-secret")
+
+	code, resp := postExplain(t, explainMetrics)
+	if code != http.StatusOK {
+		t.Fatalf("want 200, got %d %v", code, resp)
+	}
+	usage, _ := resp["usage"].(map[string]any)
+	wantCost := (40*15.0 + 10*60.0) / 1e6
+	if usage["total_tokens"] != float64(50) || math.Abs(usage["cost_yen"].(float64)-wantCost) > 1e-12 {
+		t.Fatalf("unexpected usage: %v", usage)
+	}
+
+	r := newRouter()
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/admin/cost", nil)
+	req.Header.Set("X-API-Key", "secret")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("non-admin key: want 403, got %d", w.Code)
+	}
+
+	req.Header.Set("X-API-Key", "admin-secret")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("want 200, got %d; body=%s", w.Code, w.Body.String())
+	}
+	var snap struct {
+		Total   costTotals            `json:"total"`
+		ByKey   map[string]costTotals `json:"by_key"`
+		ByModel map[string]costTotals `json:"by_model"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &snap); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if snap.Total.Requests != 1 || snap.ByKey["default"].TotalTokens != 50 || snap.ByModel["gemini-2.5-flash-lite"].Requests != 1 {
+		t.Fatalf("unexpected snapshot: %+v", snap)
+	}
+	if math.Abs(snap.Total.CostPer1kYen-wantCost*1000) > 1e-9 {
+		t.Fatalf("unexpected cost per 1k: %v", snap.Total.CostPer1kYen)
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// explainPromptVersion must be bumped whenever a prompt template changes so
+// cached answers from the old wording are not served.
+const explainPromptVersion = "v1"
+
+type explainCacheEntry struct {
+	key     string
+	resp    gin.H
+	expires time.Time
+}
+
+// resultCache is a size-bounded LRU of explain responses with per-entry TTL.
+type resultCache struct {
+	mu    sync.Mutex
+	ll    *list.List
+	items map[string]*list.Element
+	now   func() time.Time
+}
+
+var explainCache = newResultCache()
+
+func newResultCache() *resultCache {
+	return &resultCache{ll: list.New(), items: map[string]*list.Element{}, now: time.Now}
+}
+
+func (rc *resultCache) get(key string) (gin.H, bool) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	el, ok := rc.items[key]
+	if !ok {
+		return nil, false
+	}
+	entry := el.Value.(*explainCacheEntry)
+	if rc.now().After(entry.expires) {
+		rc.ll.Remove(el)
+		delete(rc.items, key)
+		return nil, false
+	}
+	rc.ll.MoveToFront(el)
+	return entry.resp, true
+}
+
+func (rc *resultCache) put(key string, resp gin.H, ttl time.Duration, size int) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if el, ok := rc.items[key]; ok {
+		entry := el.Value.(*explainCacheEntry)
+		entry.resp, entry.expires = resp, rc.now().Add(ttl)
+		rc.ll.MoveToFront(el)
+	} else {
+		rc.items[key] = rc.ll.PushFront(&explainCacheEntry{key: key, resp: resp, expires: rc.now().Add(ttl)})
+	}
+	for rc.ll.Len() > size {
+		oldest := rc.ll.Back()
+		rc.ll.Remove(oldest)
+		delete(rc.items, oldest.Value.(*explainCacheEntry).key)
+	}
+}
+
+func (rc *resultCache) len() int {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return rc.ll.Len()
+}
+
+func explainCacheSize() int {
+	if v := os.Getenv("EXPLAIN_CACHE_SIZE"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			return n
+		}
+	}
+	return 1024
+}
+
+func explainCacheTTL() time.Duration {
+	if v := os.Getenv("EXPLAIN_CACHE_TTL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			return d
+		}
+	}
+	return time.Hour
+}
+
+func explainCacheRounding() float64 {
+	if v := os.Getenv("EXPLAIN_CACHE_ROUND"); v != "" {
+		if f, err := strconv.ParseFloat(v, 64); err == nil && f > 0 {
+			return f
+		}
+	}
+	return 0.01
+}
+
+// explainCacheKey buckets the metrics to the configured granularity so
+// near-identical requests share an answer.
+func explainCacheKey(p explainRequest, model string) string {
+	step := explainCacheRounding()
+	round := func(v float64) string {
+		return strconv.FormatFloat(math.Round(v/step)*step, 'f', -1, 64)
+	}
+	format := p.Format
+	if format == "" {
+		format = explainFormatText
+	}
+	return fmt.Sprintf("%s|%s|%s|%s|%s|%s|%s|%s", explainPromptVersion, format, strings.ToLower(p.Language), model,
+		round(p.Score), round(p.Symmetry), round(p.Power), round(p.Consistency))
+}
+
+func cacheDirectives(c *gin.Context) (noCache, noStore bool) {
+	for _, d := range strings.Split(c.GetHeader("Cache-Control"), ",") {
+		switch strings.ToLower(strings.TrimSpace(d)) {
+		case "no-cache":
+			noCache = true
+		case "no-store":
+			noStore = true
+		}
+	}
+	return noCache, noStore
+}
+
+// serveCachedExplain answers from the cache unless the client sent
+// Cache-Control: no-cache. Entries are looked up under the model that would
+// serve the request now, and an entry whose text states a number that the
+// request's own metrics do not ground is treated as a miss. It remembers the
+// request for writeExplainResult.
+func serveCachedExplain(c *gin.Context, p explainRequest, model string) bool {
+	size := explainCacheSize()
+	if size == 0 {
+		return false
+	}
+	c.Set("explain_cache_request", p)
+
+	if noCache, _ := cacheDirectives(c); noCache {
+		return false
+	}
+	cached, ok := explainCache.get(explainCacheKey(p, model))
+	if !ok || !cachedGrounded(cached, p) {
+		return false
+	}
+
+	resp := make(gin.H, len(cached)+2)
+	for k, v := range cached {
+		resp[k] = v
+	}
+	resp["cached"] = true
+	resp["usage"] = tokenUsage{}
+	countExplainRequest(c, model)
+	annotateOTS(c.Request, "cached", true)
+	c.JSON(http.StatusOK, resp)
+	return true
+}
+
+// cachedGrounded reports whether every number in a cached answer can be read
+// from p's metrics, since the answer may have been written for metrics that
+// only round to the same key.
+func cachedGrounded(resp gin.H, p explainRequest) bool {
+	var texts []string
+	if summary, ok := resp["summary"].(string); ok {
+		texts = append(texts, summary)
+	}
+	if coaching, ok := resp["coaching"].(coachingOutput); ok {
+		texts = append(texts, coaching.texts()...)
+	}
+	for _, t := range texts {
+		if _, ok := ungroundedNumber(t, p.metrics()); ok {
+			return false
+		}
+	}
+	return true
+}
+
+// writeExplainResult sends a successful explain response and, when cacheable,
+// stores it for later identical requests under the model that served it.
+func writeExplainResult(c *gin.Context, resp gin.H, duration int64, cacheable bool) {
+	resp["cached"] = false
+	p, ok := c.Get("explain_cache_request")
+	model, _ := resp["model"].(string)
+	if ok && cacheable && model != "" {
+		if _, noStore := cacheDirectives(c); !noStore {
+			key := explainCacheKey(p.(explainRequest), model)
+			stored := make(gin.H, len(resp))
+			for k, v := range resp {
+				if k != "usage" && k != "cached" {
+					stored[k] = v
+				}
+			}
+			explainCache.put(key, stored, explainCacheTTL(), explainCacheSize())
+		}
+	}
+	c.JSON(http.StatusOK, resp)
+	annotateResult(c, "", duration)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func postExplainWithHeaders(t *testing.T, body string, headers map[string]string) (int, map[string]any) {
+	t.Helper()
+	r := newRouter()
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/explain", bytes.NewReader([]byte(body)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-API-Key", "secret")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	var resp map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid JSON: %v; body=%s", err, w.Body.String())
+	}
+	return w.Code, resp
+}
+
+func TestExplainCache_HitsRoundedMetrics(t *testing.T) {
+	t.Setenv("EXPLAIN_CACHE_ROUND", "0.05")
+	calls := stubVertexTexts(t, "Metrics look strong.")
+
+	_, first := postExplainWithHeaders(t, `{"score":88.5,"symmetry":0.92,"power":0.81,"consistency":0.77}`, nil)
+	code, second := postExplainWithHeaders(t, `{"score":88.49,"symmetry":0.91,"power":0.81,"consistency":0.76}`, nil)
+	if code != http.StatusOK {
+		t.Fatalf("want 200, got %d %v", code, second)
+	}
+	if first["cached"] != false || second["cached"] != true {
+		t.Fatalf("unexpected cached markers: first=%v second=%v", first["cached"], second["cached"])
+	}
+	if second["summary"] != first["summary"] {
+		t.Fatalf("cached summary mismatch: %v vs %v", second["summary"], first["summary"])
+	}
+	if *calls != 1 {
+		t.Fatalf("want 1 vertex call, got %d", *calls)
+	}
+
+	_, third := postExplainWithHeaders(t, `{"score":88.5,"symmetry":0.92,"power":0.81,"consistency":0.77,"language":"ja"}`, nil)
+	if third["cached"] != false || *calls != 2 {
+		t.Fatalf("language must be part of the cache key: cached=%v calls=%d", third["cached"], *calls)
+	}
+}
+
+func TestExplainCache_RegroundsOnHit(t *testing.T) {
+	t.Setenv("EXPLAIN_CACHE_ROUND", "0.5")
+	calls := stubVertexTexts(t, "A score of 88.5 is strong.", "A score of 88.3 is strong.")
+
+	postExplainWithHeaders(t, `{"score":88.5,"symmetry":0.92,"power":0.81,"consistency":0.77}`, nil)
+	_, resp := postExplainWithHeaders(t, `{"score":88.3,"symmetry":0.92,"power":0.81,"consistency":0.77}`, nil)
+	if resp["cached"] != false || resp["summary"] != "A score of 88.3 is strong." || *calls != 2 {
+		t.Fatalf("answer citing other metrics must not be reused: %v calls=%d", resp, *calls)
+	}
+}
+
+func TestExplainCache_KeyedByServingModel(t *testing.T) {
+	stubVertexByHost(t, map[string]int{
+		"us-central1-aiplatform.googleapis.com": http.StatusServiceUnavailable,
+	})
+	t.Setenv("VERTEX_TARGETS", "us-central1/gemini-2.5-flash-lite, europe-west4/gemini-2.5-flash")
+
+	if code, resp := postExplainWithHeaders(t, explainMetrics, nil); code != http.StatusOK || resp["model"] != "gemini-2.5-flash" {
+		t.Fatalf("want failover answer, got %d %v", code, resp)
+	}
+	var p explainRequest
+	_ = json.Unmarshal([]byte(explainMetrics), &p)
+	if _, ok := explainCache.get(explainCacheKey(p, "gemini-2.5-flash-lite")); ok {
+		t.Fatalf("failover answer must not be cached under the primary model")
+	}
+	if _, ok := explainCache.get(explainCacheKey(p, "gemini-2.5-flash")); !ok {
+		t.Fatalf("failover answer must be cached under the serving model")
+	}
+
+	// While the primary cools down, the request is served from that entry.
+	if _, resp := postExplainWithHeaders(t, explainMetrics, nil); resp["cached"] != true || resp["model"] != "gemini-2.5-flash" {
+		t.Fatalf("want cached failover answer, got %v", resp)
+	}
+}
+
+func TestExplainCache_NoCacheBypasses(t *testing.T) {
+	calls := stubVertexTexts(t, "Metrics look strong.")
+
+	postExplainWithHeaders(t, explainMetrics, nil)
+	_, resp := postExplainWithHeaders(t, explainMetrics, map[string]string{"Cache-Control": "no-cache"})
+	if resp["cached"] != false || *calls != 2 {
+		t.Fatalf("no-cache must reach vertex: cached=%v calls=%d", resp["cached"], *calls)
+	}
+}
+
+func TestResultCache_TTLAndSize(t *testing.T) {
+	now := time.Unix(0, 0)
+	rc := newResultCache()
+	rc.now = func() time.Time { return now }
+
+	rc.put("a", gin.H{"summary": "a"}, time.Minute, 2)
+	rc.put("b", gin.H{"summary": "b"}, time.Minute, 2)
+	rc.get("a")
+	rc.put("c", gin.H{"summary": "c"}, time.Minute, 2)
+	if _, ok := rc.get("b"); ok {
+		t.Fatalf("least recently used entry should be evicted")
+	}
+	if rc.len() != 2 {
+		t.Fatalf("want 2 entries, got %d", rc.len())
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, ok := rc.get("a"); ok {
+		t.Fatalf("expired entry served")
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	explainerVertex = "vertex"
+	explainerRules  = "rules"
+)
+
+// explainerMode selects how explain:compare produces its summary: "vertex"
+// (default, with rule-based fallback) or "rules" (never calls Vertex).
+func explainerMode() string {
+	if strings.EqualFold(strings.TrimSpace(os.Getenv("EXPLAINER")), explainerRules) {
+		return explainerRules
+	}
+	return explainerVertex
+}
+
+type compareRequest struct {
+	Before        *sessionMetrics `json:"before,omitempty"`
+	After         *sessionMetrics `json:"after,omitempty"`
+	BeforeSession string          `json:"before_session_id,omitempty"`
+	AfterSession  string          `json:"after_session_id,omitempty"`
+	Language      string          `json:"language,omitempty"`
+}
+
+type metricDelta struct {
+	Before    float64  `json:"before"`
+	After     float64  `json:"after"`
+	Delta     float64  `json:"delta"`
+	ChangePct *float64 `json:"change_pct,omitempty"`
+}
+
+type compareDeltas struct {
+	Score       metricDelta `json:"score"`
+	Symmetry    metricDelta `json:"symmetry"`
+	Power       metricDelta `json:"power"`
+	Consistency metricDelta `json:"consistency"`
+}
+
+func (d compareDeltas) named() []struct {
+	name string
+	d    metricDelta
+} {
+	return []struct {
+		name string
+		d    metricDelta
+	}{{"score", d.Score}, {"symmetry", d.Symmetry}, {"power", d.Power}, {"consistency", d.Consistency}}
+}
+
+func newMetricDelta(before, after float64) metricDelta {
+	d := metricDelta{Before: before, After: after, Delta: round2(after - before)}
+	if before != 0 {
+		d.ChangePct = ptr(math.Round((after-before)/math.Abs(before)*1000) / 10)
+	}
+	return d
+}
+
+func computeDeltas(before, after sessionMetrics) compareDeltas {
+	return compareDeltas{
+		Score:       newMetricDelta(before.Score, after.Score),
+		Symmetry:    newMetricDelta(before.Symmetry, after.Symmetry),
+		Power:       newMetricDelta(before.Power, after.Power),
+		Consistency: newMetricDelta(before.Consistency, after.Consistency),
+	}
+}
+
+// ruleBasedComparison is the deterministic one-sentence diff used when the
+// LLM is disabled or unavailable.
+func ruleBasedComparison(d compareDeltas) string {
+	named := d.named()
+	parts := make([]string, 0, len(named))
+	for _, m := range named {
+		switch {
+		case m.d.Delta > 0:
+			parts = append(parts, fmt.Sprintf("%s rose by %g (%g → %g)", m.name, m.d.Delta, m.d.Before, m.d.After))
+		case m.d.Delta < 0:
+			parts = append(parts, fmt.Sprintf("%s fell by %g (%g → %g)", m.name, -m.d.Delta, m.d.Before, m.d.After))
+		default:
+			parts = append(parts, fmt.Sprintf("%s was unchanged (%g)", m.name, m.d.After))
+		}
+	}
+	return "Compared with the earlier session, " + strings.Join(parts[:len(parts)-1], ", ") + ", and " + parts[len(parts)-1] + "."
+}
+
+func comparePrompt(d compareDeltas, lang string) string {
+	var b strings.Builder
+	b.WriteString("You are a movement coach. Explain in 1-2 sentences what changed between a before and after session, using only these numbers:\n")
+	for _, m := range d.named() {
+		fmt.Fprintf(&b, "- %s: before=%g, after=%g, delta=%+g", m.name, m.d.Before, m.d.After, m.d.Delta)
+		if m.d.ChangePct != nil {
+			fmt.Fprintf(&b, " (%+g%%)", *m.d.ChangePct)
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString("Do not invent other numbers.")
+	b.WriteString(languageInstruction(lang))
+	return b.String()
+}
+
+// resolveCompareSide picks inline metrics or a stored session for one side.
+func resolveCompareSide(c *gin.Context, side string, inline *sessionMetrics, sessionID string) (sessionMetrics, bool) {
+	switch {
+	case inline != nil && sessionID != "":
+		c.JSON(http.StatusBadRequest, gin.H{"error": side + ": give metrics or a session id, not both", "reason_code": "INVALID_BODY"})
+	case inline != nil:
+		return *inline, true
+	case sessionID != "":
+		// Token-authenticated users only see their own sessions.
+		p, _ := currentPrincipal(c)
+		if s, ok := scoredSessions.get(sessionID); ok && (p.Subject == "" || s.SubjectID == p.Subject) {
+			return s.sessionMetrics, true
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": side + " session not found", "reason_code": "SESSION_NOT_FOUND"})
+		annotateResult(c, "SESSION_NOT_FOUND", 0)
+		return sessionMetrics{}, false
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": side + " metrics missing", "reason_code": "INVALID_BODY"})
+	}
+	annotateResult(c, "INVALID_BODY", 0)
+	return sessionMetrics{}, false
+}
+
+// explainActionHandler serves custom-method routes such as
+// /api/v1/explain:compare, which gin cannot register as static paths.
+func explainActionHandler(c *gin.Context) {
+	switch c.Param("action") {
+	case "explain:compare":
+		compareHandler(c)
+	default:
+		requestID(c)
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found", "reason_code": "NOT_FOUND"})
+		annotateResult(c, "NOT_FOUND", 0)
+	}
+}
+
+func compareHandler(c *gin.Context) {
+	requestID(c)
+	annotateOTS(c.Request, "schema_version", explainSchemaVersion)
+
+	if !authorize(c, scopeExplain) {
+		return
+	}
+	if !ensureJSONContentType(c) {
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBodyBytes())
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body", "reason_code": "INVALID_BODY"})
+		annotateResult(c, "INVALID_BODY", 0)
+		return
+	}
+	var payload compareRequest
+	if err := json.Unmarshal(body, &payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body", "reason_code": "INVALID_BODY"})
+		annotateResult(c, "INVALID_BODY", 0)
+		return
+	}
+
+	before, ok := resolveCompareSide(c, "before", payload.Before, payload.BeforeSession)
+	if !ok {
+		return
+	}
+	after, ok := resolveCompareSide(c, "after", payload.After, payload.AfterSession)
+	if !ok || !chargeQuota(c, scopeExplain) {
+		return
+	}
+
+	deltas := computeDeltas(before, after)
+	resp := gin.H{"deltas": deltas}
+
+	var duration int64
+	fallback := func(reason string) {
+		resp["summary"] = ruleBasedComparison(deltas)
+		resp["explainer"] = explainerRules
+		resp["fallback"] = reason != ""
+		if reason != "" {
+			resp["fallback_reason"] = reason
+			annotateOTS(c.Request, "fallback_reason", reason)
+			spanFromContext(c.Request.Context()).setAttr("fallback_reason", reason)
+		}
+		annotateResult(c, "", duration)
+		c.JSON(http.StatusOK, resp)
+	}
+
+	if explainerMode() == explainerRules {
+		fallback("")
+		return
+	}
+
+	projectID, err := resolveProjectID(c.Request.Context())
+	if err != nil {
+		fallback("MISCONFIGURED_PROJECT_ID")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), explainTimeout())
+	defer cancel()
+
+	client, err := newVertexClient(ctx)
+	if err != nil {
+		fallback("VERTEX_AUTH_FAILURE")
+		return
+	}
+
+	route := vertexRoute{client: client, projectID: projectID, targets: vertexTargets()}
+	vertexPayload := map[string]any{
+		"contents": []map[string]any{
+			{
+				"role":  "user",
+				"parts": []map[string]any{{"text": comparePrompt(deltas, payload.Language)}},
+			},
+		},
+	}
+	answer, elapsed, cerr := generateVertexAnswer(ctx, c, route, vertexPayload)
+	duration = elapsed
+	resp["usage"] = explainUsage(c)
+	if cerr != nil {
+		log.Printf("explain:compare: vertex unavailable (%s %d), using rules", cerr.reasonCode, cerr.status)
+		fallback(cerr.reasonCode)
+		return
+	}
+
+	resp["summary"] = answer.Text
+	resp["explainer"] = explainerVertex
+	resp["fallback"] = false
+	resp["model"] = answer.Target.Model
+	resp["region"] = answer.Target.Region
+	resp["metadata"] = answer.metadata()
+	annotateResult(c, "", duration)
+	c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"picca/api-go/internal/fakevertex"
+)
+
+func postCompare(t *testing.T, body string) (int, map[string]any) {
+	t.Helper()
+	r := newRouter()
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/explain:compare", bytes.NewReader([]byte(body)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-API-Key", "secret")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	var resp map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid JSON: %v; body=%s", err, w.Body.String())
+	}
+	return w.Code, resp
+}
+
+const compareBody = `{"before":{"score":70,"symmetry":0.75,"power":0.6,"consistency":0.72},` +
+	`"after":{"score":74,"symmetry":0.84,"power":0.6,"consistency":0.7}}`
+
+func TestCompare_Vertex(t *testing.T) {
+	fake := setupFakeVertex(t)
+	fake.Script("*", "*", fakevertex.Response{Text: "Symmetry improved the most."})
+
+	code, resp := postCompare(t, compareBody)
+	if code != http.StatusOK {
+		t.Fatalf("want 200, got %d %v", code, resp)
+	}
+	if resp["explainer"] != explainerVertex || resp["summary"] != "Symmetry improved the most." {
+		t.Fatalf("unexpected response: %v", resp)
+	}
+	deltas, _ := resp["deltas"].(map[string]any)
+	symmetry, _ := deltas["symmetry"].(map[string]any)
+	if symmetry["delta"] != 0.09 || symmetry["change_pct"] != 12.0 {
+		t.Fatalf("unexpected symmetry delta: %v", symmetry)
+	}
+	prompt, _ := json.Marshal(fake.Calls()[0].Body)
+	if !strings.Contains(string(prompt), "symmetry: before=0.75, after=0.84, delta=+0.09 (+12%)") {
+		t.Fatalf("prompt missing deltas: %s", prompt)
+	}
+}
+
+func TestCompare_FallsBackWhenVertexUnavailable(t *testing.T) {
+	fake := setupFakeVertex(t)
+	fake.Script("*", "*", fakevertex.Response{Status: http.StatusServiceUnavailable})
+
+	var (
+		code int
+		resp map[string]any
+	)
+	out := captureStdout(t, func() {
+		h := OTSMiddleware("dev·na", newRouter())
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/explain:compare", bytes.NewReader([]byte(compareBody)))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-API-Key", "secret")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		code = w.Code
+		_ = json.Unmarshal(w.Body.Bytes(), &resp)
+	})
+	if code != http.StatusOK {
+		t.Fatalf("want 200, got %d %v", code, resp)
+	}
+	var rec map[string]any
+	if err := json.Unmarshal([]byte(strings.TrimSpace(out)), &rec); err != nil {
+		t.Fatalf("OTS line: %v\n%s", err, out)
+	}
+	if rec["fallback_reason"] != resp["fallback_reason"] || rec["fallback_reason"] == nil {
+		t.Fatalf("OTS line missing fallback_reason: %s", out)
+	}
+	want := "Compared with the earlier session, score rose by 4 (70 → 74), symmetry rose by 0.09 (0.75 → 0.84), " +
+		"power was unchanged (0.6), and consistency fell by 0.02 (0.72 → 0.7)."
+	if resp["summary"] != want || resp["fallback"] != true || resp["explainer"] != explainerRules {
+		t.Fatalf("unexpected fallback: %v", resp)
+	}
+}
+
+func TestCompare_StoredSessionsWithRulesExplainer(t *testing.T) {
+	setupExplainTest(t)
+	t.Setenv("EXPLAINER", "rules")
+	prev := scoredSessions
+	scoredSessions = newSessionStore()
+	t.Cleanup(func() { scoredSessions = prev })
+
+	before := scoredSessions.add("", sessionMetrics{Score: 60, Symmetry: 0.5, Power: 0.5, Consistency: 0.5})
+	after := scoredSessions.add("", sessionMetrics{Score: 60, Symmetry: 0.5, Power: 0.5, Consistency: 0.5})
+
+	code, resp := postCompare(t, `{"before_session_id":"`+before.ID+`","after_session_id":"`+after.ID+`"}`)
+	if code != http.StatusOK || resp["fallback"] != false || resp["explainer"] != explainerRules {
+		t.Fatalf("unexpected response: %d %v", code, resp)
+	}
+
+	code, resp = postCompare(t, `{"before_session_id":"s_missing","after_session_id":"`+after.ID+`"}`)
+	if code != http.StatusNotFound || resp["reason_code"] != "SESSION_NOT_FOUND" {
+		t.Fatalf("want 404 SESSION_NOT_FOUND, got %d %v", code, resp)
+	}
+}
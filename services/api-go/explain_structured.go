@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	explainFormatText       = "text"
+	explainFormatStructured = "structured"
+)
+
+type coachingMetrics struct {
+	Symmetry    string `json:"symmetry"`
+	Power       string `json:"power"`
+	Consistency string `json:"consistency"`
+}
+
+type coachingOutput struct {
+	Headline     string          `json:"headline"`
+	Strengths    []string        `json:"strengths"`
+	Improvements []string        `json:"improvements"`
+	PerMetric    coachingMetrics `json:"per_metric"`
+}
+
+// coachingSchema is the Vertex responseSchema (OpenAPI subset) mirroring coachingOutput.
+var coachingSchema = map[string]any{
+	"type": "OBJECT",
+	"properties": map[string]any{
+		"headline":     map[string]any{"type": "STRING"},
+		"strengths":    map[string]any{"type": "ARRAY", "items": map[string]any{"type": "STRING"}},
+		"improvements": map[string]any{"type": "ARRAY", "items": map[string]any{"type": "STRING"}},
+		"per_metric": map[string]any{
+			"type": "OBJECT",
+			"properties": map[string]any{
+				"symmetry":    map[string]any{"type": "STRING"},
+				"power":       map[string]any{"type": "STRING"},
+				"consistency": map[string]any{"type": "STRING"},
+			},
+			"required": []string{"symmetry", "power", "consistency"},
+		},
+	},
+	"required": []string{"headline", "strengths", "improvements", "per_metric"},
+}
+
+var (
+	numberPattern = regexp.MustCompile(`\d+(?:\.\d+)?`)
+	// countSuffix matches what may follow a count: an optional range end
+	// ("1-3", "2 to 4") and the word being counted.
+	countSuffix = regexp.MustCompile(`^(?:\s*(?:-|–|to)\s*\d+)?\s+([\pL]+)`)
+	prevWord    = regexp.MustCompile(`([\pL]+)[\s:=]*(?:(?:is|at|of|was|by|to|=)[\s:]+)?$`)
+)
+
+// maxCount is the largest bare integer read as a count ("3 reps") rather
+// than a metric.
+const maxCount = 10
+
+var (
+	metricWords = map[string]bool{"score": true, "symmetry": true, "power": true, "consistency": true}
+	metricUnits = map[string]bool{"percent": true, "point": true, "points": true, "pts": true}
+)
+
+func structuredAttempts() int {
+	if v := os.Getenv("EXPLAIN_STRUCTURED_ATTEMPTS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			return n
+		}
+	}
+	return 2
+}
+
+func structuredPrompt(p explainRequest) string {
+	return fmt.Sprintf("You are a movement coach. Metrics: score=%g, symmetry=%g, power=%g, consistency=%g. "+
+		"Return JSON with a one-sentence headline, 1-3 strengths, 1-3 improvements and one sentence per metric. "+
+		"Only quote metric values given here; small counts such as reps or sets are fine.", p.Score, p.Symmetry, p.Power, p.Consistency) + languageInstruction(p.Language)
+}
+
+func explainStructured(ctx context.Context, c *gin.Context, route vertexRoute, payload explainRequest) {
+	vertexPayload := map[string]any{
+		"contents": []map[string]any{
+			{
+				"role":  "user",
+				"parts": []map[string]any{{"text": structuredPrompt(payload)}},
+			},
+		},
+		"generationConfig": map[string]any{
+			"responseMimeType": "application/json",
+			"responseSchema":   coachingSchema,
+		},
+	}
+	var (
+		duration int64
+		lastErr  error
+		answer   vertexAnswer
+	)
+	// Attempts split the deadline the way failover targets do, and a retry
+	// goes to the target that answered instead of the whole chain.
+	attempts := structuredAttempts()
+	for attempt := 0; attempt < attempts; attempt++ {
+		var (
+			elapsed int64
+			ok      bool
+		)
+		attemptCtx, cancel := attemptContext(ctx, attempts-attempt)
+		answer, elapsed, ok = generateVertex(attemptCtx, c, route, vertexPayload)
+		cancel()
+		duration += elapsed
+		if !ok {
+			return
+		}
+
+		out, err := parseCoaching(answer.Text, payload)
+		if err == nil {
+			writeExplainResult(c, gin.H{
+				"summary":  out.Headline,
+				"coaching": out,
+				"format":   explainFormatStructured,
+				"fallback": false,
+				"model":    answer.Target.Model,
+				"region":   answer.Target.Region,
+				"metadata": answer.metadata(),
+				"usage":    explainUsage(c),
+			}, duration, true)
+			return
+		}
+		lastErr = err
+		log.Printf("explain: structured attempt %d rejected: %v", attempt+1, err)
+		route.targets = []vertexTarget{answer.Target}
+	}
+
+	out := ruleBasedCoaching(payload)
+	writeExplainResult(c, gin.H{
+		"summary":         out.Headline,
+		"coaching":        out,
+		"format":          explainFormatStructured,
+		"fallback":        true,
+		"fallback_reason": lastErr.Error(),
+		"model":           answer.Target.Model,
+		"region":          answer.Target.Region,
+		"metadata":        answer.metadata(),
+		"usage":           explainUsage(c),
+	}, duration, false)
+}
+
+// parseCoaching decodes and validates the model's JSON against coachingOutput,
+// including the rule that every stated number must come from the input metrics.
+func parseCoaching(text string, p explainRequest) (coachingOutput, error) {
+	var out coachingOutput
+	dec := json.NewDecoder(bytes.NewReader([]byte(strings.TrimSpace(text))))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&out); err != nil {
+		return coachingOutput{}, fmt.Errorf("invalid coaching json: %w", err)
+	}
+	if dec.More() {
+		return coachingOutput{}, errors.New("invalid coaching json: trailing data")
+	}
+
+	if strings.TrimSpace(out.Headline) == "" {
+		return coachingOutput{}, errors.New("missing headline")
+	}
+	for name, items := range map[string][]string{"strengths": out.Strengths, "improvements": out.Improvements} {
+		if len(items) == 0 || len(items) > 3 {
+			return coachingOutput{}, fmt.Errorf("%s must have 1-3 items, got %d", name, len(items))
+		}
+		for _, item := range items {
+			if strings.TrimSpace(item) == "" {
+				return coachingOutput{}, fmt.Errorf("empty item in %s", name)
+			}
+		}
+	}
+	if strings.TrimSpace(out.PerMetric.Symmetry) == "" || strings.TrimSpace(out.PerMetric.Power) == "" || strings.TrimSpace(out.PerMetric.Consistency) == "" {
+		return coachingOutput{}, errors.New("missing per_metric entry")
+	}
+
+	for _, t := range out.texts() {
+		if n, ok := ungroundedNumber(t, p.metrics()); ok {
+			return coachingOutput{}, fmt.Errorf("number %q not present in input metrics", n)
+		}
+	}
+	return out, nil
+}
+
+// texts lists every free-text field of the coaching output.
+func (o coachingOutput) texts() []string {
+	texts := append([]string{o.Headline, o.PerMetric.Symmetry, o.PerMetric.Power, o.PerMetric.Consistency}, o.Strengths...)
+	return append(texts, o.Improvements...)
+}
+
+// ungroundedNumber returns the first metric-like number in text that cannot
+// be read as one of the metrics (as-is or as a percentage) at the precision
+// it was written. Small integer counts and ranges ("3 reps", "1-3 sets") are
+// not metrics and are let through unless a metric name precedes them.
+func ungroundedNumber(text string, metrics []float64) (string, bool) {
+	for _, loc := range numberPattern.FindAllStringIndex(text, -1) {
+		n := text[loc[0]:loc[1]]
+		v, err := strconv.ParseFloat(n, 64)
+		if err != nil {
+			return n, true
+		}
+		if isCount(text, loc, v) {
+			continue
+		}
+		decimals := 0
+		if i := strings.IndexByte(n, '.'); i >= 0 {
+			decimals = len(n) - i - 1
+		}
+		tolerance := 0.5*math.Pow10(-decimals) + 1e-9
+		grounded := false
+		for _, m := range metrics {
+			if math.Abs(v-m) <= tolerance || math.Abs(v-m*100) <= tolerance {
+				grounded = true
+				break
+			}
+		}
+		if !grounded {
+			return n, true
+		}
+	}
+	return "", false
+}
+
+// isCount reports whether the number at loc in text is a small integer
+// followed by the word it counts and not introduced by a metric name.
+func isCount(text string, loc []int, v float64) bool {
+	if strings.Contains(text[loc[0]:loc[1]], ".") || v > maxCount {
+		return false
+	}
+	next := countSuffix.FindStringSubmatch(text[loc[1]:])
+	if next == nil || metricUnits[strings.ToLower(next[1])] {
+		return false
+	}
+	if prev := prevWord.FindStringSubmatch(text[:loc[0]]); prev != nil && metricWords[strings.ToLower(prev[1])] {
+		return false
+	}
+	return true
+}
+
+func describeMetric(name string, v float64) string {
+	switch {
+	case v >= 0.8:
+		return fmt.Sprintf("%s is strong at %g.", name, v)
+	case v >= 0.6:
+		return fmt.Sprintf("%s is solid at %g with room to grow.", name, v)
+	default:
+		return fmt.Sprintf("%s is the main area to work on at %g.", name, v)
+	}
+}
+
+// ruleBasedCoaching builds a deterministic coachingOutput when the model's
+// structured answer cannot be used.
+func ruleBasedCoaching(p explainRequest) coachingOutput {
+	named := []struct {
+		name  string
+		value float64
+	}{
+		{"Symmetry", p.Symmetry},
+		{"Power", p.Power},
+		{"Consistency", p.Consistency},
+	}
+
+	var strengths, improvements []string
+	best, worst := named[0], named[0]
+	for _, m := range named {
+		if m.value >= 0.8 {
+			strengths = append(strengths, fmt.Sprintf("%s is a strength (%g).", m.name, m.value))
+		} else if m.value < 0.6 {
+			improvements = append(improvements, fmt.Sprintf("Focus drills on %s (%g).", strings.ToLower(m.name), m.value))
+		}
+		if m.value > best.value {
+			best = m
+		}
+		if m.value < worst.value {
+			worst = m
+		}
+	}
+	if len(strengths) == 0 {
+		strengths = append(strengths, fmt.Sprintf("%s is your best metric (%g).", best.name, best.value))
+	}
+	if len(improvements) == 0 {
+		improvements = append(improvements, fmt.Sprintf("Keep building %s (%g).", strings.ToLower(worst.name), worst.value))
+	}
+
+	return coachingOutput{
+		Headline:     fmt.Sprintf("Overall score %g: lean on %s and work on %s.", p.Score, strings.ToLower(best.name), strings.ToLower(worst.name)),
+		Strengths:    strengths,
+		Improvements: improvements,
+		PerMetric: coachingMetrics{
+			Symmetry:    describeMetric("Symmetry", p.Symmetry),
+			Power:       describeMetric("Power", p.Power),
+			Consistency: describeMetric("Consistency", p.Consistency),
+		},
+	}
+}
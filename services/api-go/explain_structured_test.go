@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// stubVertexTexts answers successive generateContent calls with the given texts
+// and returns a pointer to the number of calls made.
+func stubVertexTexts(t *testing.T, texts ...string) *int {
+	t.Helper()
+	bodies := make([]string, len(texts))
+	for i, text := range texts {
+		encoded, _ := json.Marshal(text)
+		bodies[i] = `{"candidates":[{"content":{"parts":[{"text":` + string(encoded) + `}]},"finishReason":"STOP"}]}`
+	}
+	calls, _ := stubVertexBodies(t, bodies...)
+	return calls
+}
+
+func postStructuredExplain(t *testing.T) (int, map[string]any) {
+	t.Helper()
+	return postExplain(t, `{"score":88.5,"symmetry":0.92,"power":0.81,"consistency":0.55,"format":"structured"}`)
+}
+
+const validCoaching = `{"headline":"Solid session with a 92% symmetry.","strengths":["Symmetry 0.92 is excellent."],` +
+	`"improvements":["Consistency at 0.55 needs work."],"per_metric":{"symmetry":"Very balanced.","power":"Power of 0.81 is good.","consistency":"Uneven reps; do 3 slow reps per side."}}`
+
+func TestExplainStructured_OK(t *testing.T) {
+	calls := stubVertexTexts(t, validCoaching)
+
+	code, resp := postStructuredExplain(t)
+	if code != http.StatusOK {
+		t.Fatalf("want 200, got %d; resp=%v", code, resp)
+	}
+	if resp["fallback"] != false {
+		t.Fatalf("unexpected fallback: %v", resp)
+	}
+	coaching, _ := resp["coaching"].(map[string]any)
+	if coaching["headline"] != "Solid session with a 92% symmetry." {
+		t.Fatalf("unexpected coaching: %v", coaching)
+	}
+	if *calls != 1 {
+		t.Fatalf("want 1 vertex call, got %d", *calls)
+	}
+}
+
+func TestExplainStructured_RetriesThenFallsBack(t *testing.T) {
+	t.Setenv("EXPLAIN_STRUCTURED_ATTEMPTS", "2")
+	ungrounded := strings.Replace(validCoaching, "0.81", "0.95", 1)
+	calls := stubVertexTexts(t, "not json", ungrounded)
+
+	code, resp := postStructuredExplain(t)
+	if code != http.StatusOK {
+		t.Fatalf("want 200, got %d; resp=%v", code, resp)
+	}
+	if *calls != 2 {
+		t.Fatalf("want 2 vertex calls, got %d", *calls)
+	}
+	if resp["fallback"] != true {
+		t.Fatalf("expected rule-based fallback: %v", resp)
+	}
+	if reason, _ := resp["fallback_reason"].(string); !strings.Contains(reason, "0.95") {
+		t.Fatalf("unexpected fallback reason: %q", reason)
+	}
+	coaching, _ := resp["coaching"].(map[string]any)
+	if coaching["headline"] == "" {
+		t.Fatalf("missing fallback headline: %v", coaching)
+	}
+}
+
+func TestUngroundedNumber(t *testing.T) {
+	metrics := []float64{88.5, 0.92, 0.81, 0.55}
+	cases := map[string]bool{
+		"Score of 88.5 overall":      false,
+		"Roughly 89 points":          false,
+		"Symmetry at 92%":            false,
+		"Power is 0.8":               false,
+		"Consistency improved by 20": true,
+		"Power is 0.84":              true,
+		"Do 3 reps per side":         false,
+		"Add 2 drills":               false,
+		"Work through 1-3 sets":      false,
+		"Symmetry: do 3 reps":        false,
+		"Power is 5 today":           true,
+		"Score 7 points higher":      true,
+		"Up 4% this week":            true,
+		"Hold 30 seconds":            true,
+	}
+	for text, want := range cases {
+		if _, got := ungroundedNumber(text, metrics); got != want {
+			t.Errorf("ungroundedNumber(%q) = %v, want %v", text, got, want)
+		}
+	}
+}
+
+func TestRuleBasedCoachingIsGrounded(t *testing.T) {
+	p := explainRequest{sessionMetrics: sessionMetrics{Score: 71, Symmetry: 0.42, Power: 0.88, Consistency: 0.67}}
+	out := ruleBasedCoaching(p)
+	encoded, _ := json.Marshal(out)
+	if _, err := parseCoaching(string(encoded), p); err != nil {
+		t.Fatalf("fallback coaching fails validation: %v", err)
+	}
+}
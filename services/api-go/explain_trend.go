@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const defaultHistorySessions = 5
+
+type metricTrend struct {
+	Latest          float64  `json:"latest"`
+	Previous        *float64 `json:"previous,omitempty"`
+	DeltaVsPrevious *float64 `json:"delta_vs_previous,omitempty"`
+	ChangePct       *float64 `json:"change_pct,omitempty"`
+	WindowMean      float64  `json:"window_mean"`
+	BestEver        float64  `json:"best_ever"`
+	PersonalBest    bool     `json:"personal_best"`
+	ImprovingStreak int      `json:"improving_streak"`
+}
+
+// trendSummary holds only numbers derived from session metrics; it is what
+// the trend prompt is allowed to see.
+type trendSummary struct {
+	Sessions    int         `json:"sessions"`
+	Score       metricTrend `json:"score"`
+	Symmetry    metricTrend `json:"symmetry"`
+	Power       metricTrend `json:"power"`
+	Consistency metricTrend `json:"consistency"`
+}
+
+func maxHistorySessions() int {
+	if v := os.Getenv("EXPLAIN_HISTORY_MAX"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 1 {
+			return n
+		}
+	}
+	return 20
+}
+
+func round2(v float64) float64 { return math.Round(v*100) / 100 }
+
+func ptr(v float64) *float64 { return &v }
+
+// computeMetricTrend summarises one metric over window (oldest first). allTime
+// is the full known history used for the best-ever comparison.
+func computeMetricTrend(window, allTime []float64) metricTrend {
+	n := len(window)
+	t := metricTrend{Latest: window[n-1]}
+
+	var sum float64
+	for _, v := range window {
+		sum += v
+	}
+	t.WindowMean = round2(sum / float64(n))
+
+	t.BestEver = t.Latest
+	for _, v := range allTime {
+		if v > t.BestEver {
+			t.BestEver = v
+		}
+	}
+	t.PersonalBest = n > 1 && t.Latest >= t.BestEver
+
+	if n > 1 {
+		prev := window[n-2]
+		t.Previous = ptr(prev)
+		t.DeltaVsPrevious = ptr(round2(t.Latest - prev))
+		if first := window[0]; first != 0 {
+			t.ChangePct = ptr(math.Round((t.Latest-first)/math.Abs(first)*1000) / 10)
+		}
+		for i := n - 1; i > 0 && window[i] > window[i-1]; i-- {
+			t.ImprovingStreak++
+		}
+	}
+	return t
+}
+
+// computeTrend derives per-metric trends from the last n points of series
+// (oldest first).
+func computeTrend(series []sessionMetrics, n int) trendSummary {
+	window := series
+	if len(window) > n {
+		window = window[len(window)-n:]
+	}
+	pick := func(src []sessionMetrics, f func(sessionMetrics) float64) []float64 {
+		out := make([]float64, len(src))
+		for i, m := range src {
+			out[i] = f(m)
+		}
+		return out
+	}
+	trend := func(f func(sessionMetrics) float64) metricTrend {
+		return computeMetricTrend(pick(window, f), pick(series, f))
+	}
+
+	return trendSummary{
+		Sessions:    len(window),
+		Score:       trend(func(m sessionMetrics) float64 { return m.Score }),
+		Symmetry:    trend(func(m sessionMetrics) float64 { return m.Symmetry }),
+		Power:       trend(func(m sessionMetrics) float64 { return m.Power }),
+		Consistency: trend(func(m sessionMetrics) float64 { return m.Consistency }),
+	}
+}
+
+func trendPrompt(ts trendSummary, lang string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "You are a movement coach. Give 2-3 sentences of feedback on progress over the last %d sessions, using only these derived trends:\n", ts.Sessions)
+	for _, m := range []struct {
+		name string
+		t    metricTrend
+	}{{"score", ts.Score}, {"symmetry", ts.Symmetry}, {"power", ts.Power}, {"consistency", ts.Consistency}} {
+		name, t := m.name, m.t
+		fmt.Fprintf(&b, "- %s: latest=%g, mean=%g, best_ever=%g", name, t.Latest, t.WindowMean, t.BestEver)
+		if t.ChangePct != nil {
+			fmt.Fprintf(&b, ", change_over_window=%+g%%", *t.ChangePct)
+		}
+		if t.DeltaVsPrevious != nil {
+			fmt.Fprintf(&b, ", delta_vs_previous=%+g", *t.DeltaVsPrevious)
+		}
+		if t.PersonalBest {
+			b.WriteString(", new personal best")
+		}
+		if t.ImprovingStreak > 1 {
+			fmt.Fprintf(&b, ", improved %d sessions in a row", t.ImprovingStreak)
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString("Do not invent other numbers.")
+	b.WriteString(languageInstruction(lang))
+	return b.String()
+}
+
+// resolveHistory returns the metric series for a trend explanation, oldest
+// first and ending with the request's own metrics. It is empty when the
+// request asks for a plain explanation. The request's metrics are not added
+// again when they already end the series: for stored sessions that means the
+// last one has the request's session_id, and without a session_id (or with
+// inline history) that its metrics are equal.
+func resolveHistory(c *gin.Context, payload explainRequest) ([]sessionMetrics, bool) {
+	if payload.SubjectID != "" || (payload.HistorySessions > 0 && len(payload.History) == 0) {
+		subjectID, ok := pinnedSubject(c, payload.SubjectID)
+		if !ok {
+			return nil, false
+		}
+		payload.SubjectID = subjectID
+	}
+	if len(payload.History) == 0 && payload.SubjectID == "" {
+		if payload.HistorySessions > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "history_sessions needs history or subject_id", "reason_code": "INVALID_HISTORY"})
+			annotateResult(c, "INVALID_HISTORY", 0)
+			return nil, false
+		}
+		return nil, true
+	}
+	if payload.Format == explainFormatStructured {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "structured format does not support history", "reason_code": "INVALID_FORMAT"})
+		annotateResult(c, "INVALID_FORMAT", 0)
+		return nil, false
+	}
+
+	n := payload.HistorySessions
+	if n <= 0 {
+		n = defaultHistorySessions
+	}
+	if limit := maxHistorySessions(); n > limit || len(payload.History) > limit {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "too many history sessions", "reason_code": "INVALID_HISTORY"})
+		annotateResult(c, "INVALID_HISTORY", 0)
+		return nil, false
+	}
+
+	series := append([]sessionMetrics(nil), payload.History...)
+	lastID := ""
+	if len(series) == 0 {
+		if !subjectIDPattern.MatchString(payload.SubjectID) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid subject id", "reason_code": "INVALID_SUBJECT_ID"})
+			annotateResult(c, "INVALID_SUBJECT_ID", 0)
+			return nil, false
+		}
+		for _, s := range scoredSessions.forSubject(payload.SubjectID) {
+			series = append(series, s.sessionMetrics)
+			lastID = s.ID
+		}
+		if len(series) == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "no sessions for subject", "reason_code": "SUBJECT_NOT_FOUND"})
+			annotateResult(c, "SUBJECT_NOT_FOUND", 0)
+			return nil, false
+		}
+	}
+	current := series[len(series)-1] == payload.sessionMetrics
+	if payload.SessionID != "" && lastID != "" {
+		current = payload.SessionID == lastID
+	}
+	if !current {
+		series = append(series, payload.sessionMetrics)
+	}
+	c.Set("history_sessions", n)
+	return series, true
+}
+
+func explainTrend(ctx context.Context, c *gin.Context, route vertexRoute, payload explainRequest, series []sessionMetrics) {
+	ts := computeTrend(series, c.GetInt("history_sessions"))
+	vertexPayload := map[string]any{
+		"contents": []map[string]any{
+			{
+				"role":  "user",
+				"parts": []map[string]any{{"text": trendPrompt(ts, payload.Language)}},
+			},
+		},
+	}
+	answer, duration, ok := generateVertex(ctx, c, route, vertexPayload)
+	if !ok {
+		return
+	}
+	writeExplainResult(c, gin.H{
+		"summary":  answer.Text,
+		"trend":    ts,
+		"model":    answer.Target.Model,
+		"region":   answer.Target.Region,
+		"metadata": answer.metadata(),
+		"usage":    explainUsage(c),
+	}, duration, false)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestComputeTrend(t *testing.T) {
+	series := []sessionMetrics{
+		{Score: 70, Symmetry: 0.95, Power: 0.6, Consistency: 0.7},
+		{Score: 72, Symmetry: 0.75, Power: 0.6, Consistency: 0.7},
+		{Score: 71, Symmetry: 0.78, Power: 0.62, Consistency: 0.7},
+		{Score: 74, Symmetry: 0.80, Power: 0.65, Consistency: 0.7},
+		{Score: 76, Symmetry: 0.82, Power: 0.7, Consistency: 0.7},
+		{Score: 79, Symmetry: 0.84, Power: 0.72, Consistency: 0.69},
+	}
+	ts := computeTrend(series, 5)
+
+	if ts.Sessions != 5 {
+		t.Fatalf("want 5-session window, got %d", ts.Sessions)
+	}
+	if ts.Symmetry.ChangePct == nil || *ts.Symmetry.ChangePct != 12 {
+		t.Fatalf("symmetry change over window: %v", ts.Symmetry.ChangePct)
+	}
+	if ts.Symmetry.BestEver != 0.95 || ts.Symmetry.PersonalBest {
+		t.Fatalf("best-ever must look beyond the window: %+v", ts.Symmetry)
+	}
+	if ts.Score.ImprovingStreak != 3 || !ts.Score.PersonalBest {
+		t.Fatalf("unexpected score trend: %+v", ts.Score)
+	}
+	if ts.Consistency.DeltaVsPrevious == nil || *ts.Consistency.DeltaVsPrevious != -0.01 || ts.Consistency.ImprovingStreak != 0 {
+		t.Fatalf("unexpected consistency trend: %+v", ts.Consistency)
+	}
+}
+
+func TestExplainTrend_BySubjectID(t *testing.T) {
+	prev := scoredSessions
+	scoredSessions = newSessionStore()
+	t.Cleanup(func() { scoredSessions = prev })
+
+	fake := setupFakeVertex(t)
+
+	scores := []string{
+		`{"score":70,"symmetry":0.75,"power":0.6,"consistency":0.7}`,
+		`{"score":74,"symmetry":0.84,"power":0.65,"consistency":0.72}`,
+	}
+	i := 0
+	ml := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(scores[i]))
+		i++
+	}))
+	defer ml.Close()
+	t.Setenv("API_ML_URL", ml.URL)
+
+	r := newRouter()
+	for range scores {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/score", bytes.NewBufferString(`{"fps":30,"keypoints":[]}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-API-Key", "secret")
+		req.Header.Set("X-Subject-Id", "athlete-7")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("X-Session-Id"), "s_") {
+			t.Fatalf("score: got %d session=%q", w.Code, w.Header().Get("X-Session-Id"))
+		}
+	}
+
+	code, resp := postExplain(t, `{"score":74,"symmetry":0.84,"power":0.65,"consistency":0.72,"subject_id":"athlete-7"}`)
+	if code != http.StatusOK {
+		t.Fatalf("want 200, got %d %v", code, resp)
+	}
+	var trend trendSummary
+	encoded, _ := json.Marshal(resp["trend"])
+	if err := json.Unmarshal(encoded, &trend); err != nil || trend.Sessions != 2 {
+		t.Fatalf("unexpected trend: %s", encoded)
+	}
+
+	calls := fake.Calls()
+	prompt, _ := json.Marshal(calls[len(calls)-1].Body)
+	if !strings.Contains(string(prompt), "change_over_window=+12%") || strings.Contains(string(prompt), "keypoints") {
+		t.Fatalf("trend prompt missing derived numbers: %s", prompt)
+	}
+
+	code, resp = postExplain(t, `{"score":74,"symmetry":0.84,"power":0.65,"consistency":0.72,"subject_id":"nobody"}`)
+	if code != http.StatusNotFound || resp["reason_code"] != "SUBJECT_NOT_FOUND" {
+		t.Fatalf("want 404 SUBJECT_NOT_FOUND, got %d %v", code, resp)
+	}
+}
+
+func TestExplainTrend_RepeatSessionAndMissingHistory(t *testing.T) {
+	prev := scoredSessions
+	scoredSessions = newSessionStore()
+	t.Cleanup(func() { scoredSessions = prev })
+	setupFakeVertex(t)
+
+	m := sessionMetrics{Score: 74, Symmetry: 0.84, Power: 0.65, Consistency: 0.72}
+	stored := scoredSessions.add("athlete-8", m)
+	metrics := `"score":74,"symmetry":0.84,"power":0.65,"consistency":0.72,"subject_id":"athlete-8"`
+	for _, tc := range []struct {
+		sessionID string
+		sessions  int
+	}{
+		{stored.ID, 1},
+		{"s_repeat", 2},
+		{"", 1},
+	} {
+		code, resp := postExplain(t, `{`+metrics+`,"session_id":"`+tc.sessionID+`"}`)
+		if code != http.StatusOK {
+			t.Fatalf("session %q: want 200, got %d %v", tc.sessionID, code, resp)
+		}
+		var trend trendSummary
+		encoded, _ := json.Marshal(resp["trend"])
+		if err := json.Unmarshal(encoded, &trend); err != nil || trend.Sessions != tc.sessions {
+			t.Fatalf("session %q: want %d sessions, got %s", tc.sessionID, tc.sessions, encoded)
+		}
+	}
+
+	code, resp := postExplain(t, `{"score":74,"symmetry":0.84,"power":0.65,"consistency":0.72,"history_sessions":3}`)
+	if code != http.StatusBadRequest || resp["reason_code"] != "INVALID_HISTORY" {
+		t.Fatalf("want 400 INVALID_HISTORY, got %d %v", code, resp)
+	}
+}
@@ -8,6 +8,7 @@ require (
 	cloud.google.com/go/compute/metadata v0.8.4
 	github.com/gin-gonic/gin v1.10.0
 	golang.org/x/oauth2 v0.31.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -36,5 +37,4 @@ require (
 	golang.org/x/sys v0.35.0 // indirect
 	golang.org/x/text v0.15.0 // indirect
 	google.golang.org/protobuf v1.34.1 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
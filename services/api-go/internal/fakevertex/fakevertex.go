@@ -0,0 +1,178 @@
+// Package fakevertex is an in-process stand-in for the Vertex AI
+// generateContent endpoint, used by integration tests and local compose runs.
+package fakevertex
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Response scripts what the fake returns for a matching call.
+type Response struct {
+	Status          int           // HTTP status; 0 means 200.
+	Text            string        // candidate text; empty picks a default for the request mode.
+	FinishReason    string        // defaults to STOP.
+	BlockReason     string        // non-empty returns a blocked promptFeedback instead of candidates.
+	PromptTokens    int           // 0 derives a rough count from the prompt.
+	CandidateTokens int           // 0 derives a rough count from Text.
+	Delay           time.Duration // sleep before answering, to exercise timeouts.
+}
+
+// Call records one request received by the fake.
+type Call struct {
+	Version       string
+	Project       string
+	Region        string
+	Model         string
+	Authorization string
+	RequestID     string
+	Body          map[string]any
+}
+
+// DefaultText is returned for plain-text requests with no scripted response.
+const DefaultText = "Metrics look steady overall."
+
+// DefaultCoaching is returned when the request asks for JSON output. It states
+// no numbers so it always passes the gateway's grounding check.
+const DefaultCoaching = `{"headline":"Steady session overall.","strengths":["Balanced movement."],` +
+	`"improvements":["Keep reps even."],"per_metric":{"symmetry":"Left and right look alike.",` +
+	`"power":"Drive is adequate.","consistency":"Reps are fairly even."}}`
+
+var pathPattern = regexp.MustCompile(`^/([^/]+)/projects/([^/]+)/locations/([^/]+)/publishers/google/models/([^/:]+):generateContent$`)
+
+// Server implements http.Handler. Responses are matched by "region/model",
+// then "*/model", then "region/*", then "*".
+type Server struct {
+	mu        sync.Mutex
+	responses map[string]Response
+	calls     []Call
+}
+
+// New returns a fake that answers every call successfully.
+func New() *Server {
+	return &Server{responses: map[string]Response{}}
+}
+
+// Script sets the response for region/model; either may be "*".
+func (s *Server) Script(region, model string, r Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.responses[region+"/"+model] = r
+}
+
+// Calls returns a copy of the calls received so far.
+func (s *Server) Calls() []Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Call(nil), s.calls...)
+}
+
+func (s *Server) lookup(region, model string) Response {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, key := range []string{region + "/" + model, "*/" + model, region + "/*", "*/*"} {
+		if r, ok := s.responses[key]; ok {
+			return r
+		}
+	}
+	return Response{}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m := pathPattern.FindStringSubmatch(r.URL.Path)
+	if r.Method != http.MethodPost || m == nil {
+		writeJSON(w, http.StatusNotFound, map[string]any{"error": map[string]any{"code": 404, "status": "NOT_FOUND"}})
+		return
+	}
+
+	var body map[string]any
+	raw, _ := io.ReadAll(r.Body)
+	if err := json.Unmarshal(raw, &body); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": map[string]any{"code": 400, "status": "INVALID_ARGUMENT"}})
+		return
+	}
+
+	call := Call{
+		Version:       m[1],
+		Project:       m[2],
+		Region:        m[3],
+		Model:         m[4],
+		Authorization: r.Header.Get("Authorization"),
+		RequestID:     r.Header.Get("X-Request-Id"),
+		Body:          body,
+	}
+	s.mu.Lock()
+	s.calls = append(s.calls, call)
+	s.mu.Unlock()
+
+	resp := s.lookup(call.Region, call.Model)
+	if resp.Delay > 0 {
+		select {
+		case <-time.After(resp.Delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	if resp.Status != 0 && resp.Status != http.StatusOK {
+		writeJSON(w, resp.Status, map[string]any{"error": map[string]any{"code": resp.Status, "status": http.StatusText(resp.Status)}})
+		return
+	}
+
+	promptTokens := resp.PromptTokens
+	if promptTokens == 0 {
+		promptTokens = len(raw)/4 + 1
+	}
+	if resp.BlockReason != "" {
+		writeJSON(w, http.StatusOK, map[string]any{
+			"promptFeedback": map[string]any{"blockReason": resp.BlockReason},
+			"usageMetadata":  map[string]any{"promptTokenCount": promptTokens, "totalTokenCount": promptTokens},
+		})
+		return
+	}
+
+	text := resp.Text
+	if text == "" {
+		text = DefaultText
+		if wantsJSON(body) {
+			text = DefaultCoaching
+		}
+	}
+	finish := resp.FinishReason
+	if finish == "" {
+		finish = "STOP"
+	}
+	candidateTokens := resp.CandidateTokens
+	if candidateTokens == 0 {
+		candidateTokens = len(text)/4 + 1
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"candidates": []any{map[string]any{
+			"content":      map[string]any{"role": "model", "parts": []any{map[string]any{"text": text}}},
+			"finishReason": finish,
+		}},
+		"usageMetadata": map[string]any{
+			"promptTokenCount":     promptTokens,
+			"candidatesTokenCount": candidateTokens,
+			"totalTokenCount":      promptTokens + candidateTokens,
+		},
+		"modelVersion": call.Model,
+	})
+}
+
+func wantsJSON(body map[string]any) bool {
+	cfg, _ := body["generationConfig"].(map[string]any)
+	mime, _ := cfg["responseMimeType"].(string)
+	return strings.EqualFold(mime, "application/json")
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,423 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// jwk is the subset of RFC 7517 fields needed for RS256 and ES256 keys.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg,omitempty"`
+	Use string `json:"use,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// parseJWKS returns the usable signing keys by kid. Keys of other types or
+// marked for encryption are skipped.
+func parseJWKS(raw []byte) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(raw, &set); err != nil {
+		return nil, fmt.Errorf("parse jwks: %w", err)
+	}
+	keys := map[string]crypto.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		switch k.Kty {
+		case "RSA":
+			n, errN := base64.RawURLEncoding.DecodeString(k.N)
+			e, errE := base64.RawURLEncoding.DecodeString(k.E)
+			if errN != nil || errE != nil || len(e) == 0 || len(e) > 4 {
+				return nil, fmt.Errorf("jwk %q: invalid RSA parameters", k.Kid)
+			}
+			keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case "EC":
+			if k.Crv != "P-256" {
+				continue
+			}
+			x, errX := base64.RawURLEncoding.DecodeString(k.X)
+			y, errY := base64.RawURLEncoding.DecodeString(k.Y)
+			if errX != nil || errY != nil {
+				return nil, fmt.Errorf("jwk %q: invalid EC parameters", k.Kid)
+			}
+			pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+			if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+				return nil, fmt.Errorf("jwk %q: point not on curve", k.Kid)
+			}
+			keys[k.Kid] = pub
+		}
+	}
+	return keys, nil
+}
+
+// jwksCache holds the keys from JWT_JWKS_FILE or JWT_JWKS_URL for
+// JWT_JWKS_CACHE_TTL. An unknown kid forces a refetch, at most once per
+// jwksMinRefresh, so key rollover at the issuer is picked up promptly.
+// Fetches run outside the lock; concurrent callers share one in flight.
+type jwksCache struct {
+	mu       sync.Mutex
+	source   string
+	keys     map[string]crypto.PublicKey
+	fetched  time.Time
+	inflight *jwksFetch
+	now      func() time.Time
+	client   *http.Client
+}
+
+// jwksFetch is one fetch of source; done is closed once keys and err are set.
+type jwksFetch struct {
+	source string
+	done   chan struct{}
+	keys   map[string]crypto.PublicKey
+	err    error
+}
+
+const jwksMinRefresh = 30 * time.Second
+
+var jwksKeys = newJWKSCache(time.Now)
+
+func newJWKSCache(now func() time.Time) *jwksCache {
+	return &jwksCache{now: now, client: &http.Client{Timeout: 5 * time.Second}}
+}
+
+func jwksCacheTTL() time.Duration {
+	if v := os.Getenv("JWT_JWKS_CACHE_TTL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			return d
+		}
+	}
+	return 10 * time.Minute
+}
+
+func jwksSource() string {
+	if path := strings.TrimSpace(os.Getenv("JWT_JWKS_FILE")); path != "" {
+		return path
+	}
+	return strings.TrimSpace(os.Getenv("JWT_JWKS_URL"))
+}
+
+func (j *jwksCache) fetch(source string) (map[string]crypto.PublicKey, error) {
+	var raw []byte
+	var err error
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		var resp *http.Response
+		resp, err = j.client.Get(source)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("jwks: %s returned %d", source, resp.StatusCode)
+		}
+		raw, err = io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	} else {
+		raw, err = os.ReadFile(source)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseJWKS(raw)
+}
+
+// key returns the public key for kid, fetching the set when the cache is
+// empty, expired, for another source, or missing kid.
+func (j *jwksCache) key(source, kid string) (crypto.PublicKey, error) {
+	j.mu.Lock()
+	now := j.now()
+	stale := j.source != source || j.keys == nil || now.Sub(j.fetched) >= jwksCacheTTL()
+	if !stale {
+		k, ok := j.keys[kid]
+		recent := now.Sub(j.fetched) < jwksMinRefresh
+		if ok || recent {
+			j.mu.Unlock()
+			if !ok {
+				return nil, errJWTUnknownKey
+			}
+			return k, nil
+		}
+	}
+
+	f := j.inflight
+	if f != nil && f.source == source {
+		j.mu.Unlock()
+		<-f.done
+		j.mu.Lock()
+	} else {
+		f = &jwksFetch{source: source, done: make(chan struct{})}
+		j.inflight = f
+		j.mu.Unlock()
+		f.keys, f.err = j.fetch(source)
+		j.mu.Lock()
+		if f.err == nil {
+			j.source, j.keys, j.fetched = source, f.keys, now
+		}
+		if j.inflight == f {
+			j.inflight = nil
+		}
+		close(f.done)
+	}
+	defer j.mu.Unlock()
+
+	if f.err != nil {
+		if j.source == source && j.keys != nil {
+			// Keep serving the last good set while the issuer is unreachable.
+			if k, ok := j.keys[kid]; ok {
+				return k, nil
+			}
+		}
+		return nil, fmt.Errorf("%w: %v", errJWKSUnavailable, f.err)
+	}
+	if k, ok := f.keys[kid]; ok {
+		return k, nil
+	}
+	return nil, errJWTUnknownKey
+}
+
+var (
+	errJWTUnknownKey   = errors.New("unknown signing key")
+	errJWKSUnavailable = errors.New("jwks unavailable")
+)
+
+// jwtError carries the reason code reported to the client.
+type jwtError struct {
+	reasonCode string
+	status     int
+	message    string
+}
+
+func (e *jwtError) Error() string { return e.reasonCode + ": " + e.message }
+
+func jwtFail(reasonCode, message string) *jwtError {
+	return &jwtError{reasonCode: reasonCode, status: http.StatusUnauthorized, message: message}
+}
+
+type jwtConfig struct {
+	source      string
+	issuer      string
+	audiences   []string
+	scopesClaim string
+	scopePrefix string
+	leeway      time.Duration
+}
+
+// currentJWTConfig reads the JWT_* variables. ok is false unless a JWKS
+// source, JWT_ISSUER and JWT_AUDIENCE are all configured, in which case bearer
+// tokens are not accepted: a token signed for another issuer or service must
+// never pass.
+func currentJWTConfig() (jwtConfig, bool) {
+	cfg := jwtConfig{
+		source:      jwksSource(),
+		issuer:      strings.TrimSpace(os.Getenv("JWT_ISSUER")),
+		scopesClaim: strings.TrimSpace(os.Getenv("JWT_SCOPES_CLAIM")),
+		scopePrefix: os.Getenv("JWT_SCOPE_PREFIX"),
+		leeway:      time.Minute,
+	}
+	for _, a := range strings.Split(os.Getenv("JWT_AUDIENCE"), ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			cfg.audiences = append(cfg.audiences, a)
+		}
+	}
+	if cfg.scopesClaim == "" {
+		cfg.scopesClaim = "scope"
+	}
+	if v := os.Getenv("JWT_LEEWAY"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+			cfg.leeway = d
+		}
+	}
+	return cfg, cfg.source != "" && cfg.issuer != "" && len(cfg.audiences) > 0
+}
+
+type jwtClaims struct {
+	Subject string
+	Scopes  []string
+}
+
+// verifyJWT checks a compact RS256/ES256 token's signature, expiry, issuer and
+// audience and maps its claims onto a subject and gateway scopes.
+func verifyJWT(token string, cfg jwtConfig, keys *jwksCache, now time.Time) (jwtClaims, *jwtError) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return jwtClaims{}, jwtFail("JWT_MALFORMED", "token must have three parts")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return jwtClaims{}, jwtFail("JWT_MALFORMED", "invalid header")
+	}
+	if header.Alg != "RS256" && header.Alg != "ES256" {
+		return jwtClaims{}, jwtFail("JWT_UNSUPPORTED_ALG", "unsupported alg "+header.Alg)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return jwtClaims{}, jwtFail("JWT_MALFORMED", "invalid signature encoding")
+	}
+
+	pub, err := keys.key(cfg.source, header.Kid)
+	switch {
+	case errors.Is(err, errJWTUnknownKey):
+		return jwtClaims{}, jwtFail("JWT_UNKNOWN_KEY", "no key for kid "+header.Kid)
+	case err != nil:
+		return jwtClaims{}, &jwtError{reasonCode: "JWKS_UNAVAILABLE", status: http.StatusServiceUnavailable, message: err.Error()}
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if !verifyJWTSignature(header.Alg, pub, digest[:], sig) {
+		return jwtClaims{}, jwtFail("JWT_INVALID_SIGNATURE", "signature verification failed")
+	}
+
+	var claims map[string]any
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return jwtClaims{}, jwtFail("JWT_MALFORMED", "invalid claims")
+	}
+	exp, hasExp := numericClaim(claims, "exp")
+	if !hasExp {
+		return jwtClaims{}, jwtFail("JWT_EXPIRED", "token has no exp")
+	}
+	if !now.Before(exp.Add(cfg.leeway)) {
+		return jwtClaims{}, jwtFail("JWT_EXPIRED", "token expired")
+	}
+	if nbf, ok := numericClaim(claims, "nbf"); ok && now.Add(cfg.leeway).Before(nbf) {
+		return jwtClaims{}, jwtFail("JWT_NOT_YET_VALID", "token not yet valid")
+	}
+	if iss, _ := claims["iss"].(string); cfg.issuer == "" || iss != cfg.issuer {
+		return jwtClaims{}, jwtFail("JWT_INVALID_ISSUER", "unexpected issuer")
+	}
+	if !audienceMatches(claims["aud"], cfg.audiences) {
+		return jwtClaims{}, jwtFail("JWT_INVALID_AUDIENCE", "unexpected audience")
+	}
+	sub, _ := claims["sub"].(string)
+	if !subjectIDPattern.MatchString(sub) {
+		return jwtClaims{}, jwtFail("JWT_INVALID_SUBJECT", "sub claim missing or not a valid subject id")
+	}
+	return jwtClaims{Subject: sub, Scopes: scopesFromClaim(claims[cfg.scopesClaim], cfg.scopePrefix)}, nil
+}
+
+func decodeJWTPart(part string, v any) error {
+	raw, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, v)
+}
+
+func verifyJWTSignature(alg string, pub crypto.PublicKey, digest, sig []byte) bool {
+	switch alg {
+	case "RS256":
+		k, ok := pub.(*rsa.PublicKey)
+		return ok && rsa.VerifyPKCS1v15(k, crypto.SHA256, digest, sig) == nil
+	case "ES256":
+		k, ok := pub.(*ecdsa.PublicKey)
+		if !ok || len(sig) != 64 {
+			return false
+		}
+		r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+		return ecdsa.Verify(k, digest, r, s)
+	}
+	return false
+}
+
+func numericClaim(claims map[string]any, name string) (time.Time, bool) {
+	v, ok := claims[name].(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(v), 0), true
+}
+
+func audienceMatches(aud any, want []string) bool {
+	var got []string
+	switch v := aud.(type) {
+	case string:
+		got = []string{v}
+	case []any:
+		for _, a := range v {
+			if s, ok := a.(string); ok {
+				got = append(got, s)
+			}
+		}
+	}
+	for _, g := range got {
+		for _, w := range want {
+			if g == w {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// scopesFromClaim accepts a space-separated string (OAuth "scope") or an
+// array ("scp"), keeps entries carrying prefix, and drops unknown scopes.
+func scopesFromClaim(v any, prefix string) []string {
+	var raw []string
+	switch s := v.(type) {
+	case string:
+		raw = strings.Fields(s)
+	case []any:
+		for _, item := range s {
+			if str, ok := item.(string); ok {
+				raw = append(raw, str)
+			}
+		}
+	}
+	var scopes []string
+	for _, s := range raw {
+		if !strings.HasPrefix(s, prefix) {
+			continue
+		}
+		switch s = strings.TrimPrefix(s, prefix); s {
+		case scopeScore, scopeExplain, scopeAdmin:
+			scopes = append(scopes, s)
+		}
+	}
+	return scopes
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	h := r.Header.Get("Authorization")
+	if len(h) < 7 || !strings.EqualFold(h[:7], "Bearer ") {
+		return "", false
+	}
+	return strings.TrimSpace(h[7:]), true
+}
+
+// authenticateJWT turns a verified bearer token into a principal named
+// "jwt:<sub>", so rate limits and quotas apply per end user.
+func authenticateJWT(c *gin.Context, token string) (principal, bool) {
+	cfg, ok := currentJWTConfig()
+	if !ok {
+		return principal{}, denyAuth(c, http.StatusUnauthorized, "unauthorized", "JWT_NOT_ENABLED")
+	}
+	claims, jerr := verifyJWT(token, cfg, jwksKeys, time.Now())
+	if jerr != nil {
+		return principal{}, denyAuth(c, jerr.status, "unauthorized", jerr.reasonCode)
+	}
+	return principal{Name: "jwt:" + claims.Subject, Scopes: claims.Scopes, Subject: claims.Subject}, true
+}
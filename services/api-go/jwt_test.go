@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type testSigner struct {
+	kid string
+	rsa *rsa.PrivateKey
+	ec  *ecdsa.PrivateKey
+}
+
+func (s testSigner) jwk() map[string]string {
+	b64 := base64.RawURLEncoding.EncodeToString
+	if s.rsa != nil {
+		return map[string]string{"kty": "RSA", "kid": s.kid, "n": b64(s.rsa.N.Bytes()), "e": b64(big.NewInt(int64(s.rsa.E)).Bytes())}
+	}
+	return map[string]string{"kty": "EC", "kid": s.kid, "crv": "P-256", "x": b64(s.ec.X.FillBytes(make([]byte, 32))), "y": b64(s.ec.Y.FillBytes(make([]byte, 32)))}
+}
+
+func (s testSigner) sign(t *testing.T, claims map[string]any) string {
+	t.Helper()
+	alg := "ES256"
+	if s.rsa != nil {
+		alg = "RS256"
+	}
+	h, _ := json.Marshal(map[string]string{"alg": alg, "kid": s.kid, "typ": "JWT"})
+	p, _ := json.Marshal(claims)
+	signing := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(p)
+	digest := sha256.Sum256([]byte(signing))
+	var sig []byte
+	if s.rsa != nil {
+		var err error
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, s.rsa, crypto.SHA256, digest[:]); err != nil {
+			t.Fatalf("sign: %v", err)
+		}
+	} else {
+		r, ss, err := ecdsa.Sign(rand.Reader, s.ec, digest[:])
+		if err != nil {
+			t.Fatalf("sign: %v", err)
+		}
+		sig = append(r.FillBytes(make([]byte, 32)), ss.FillBytes(make([]byte, 32))...)
+	}
+	return signing + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func newTestSigners(t *testing.T) (testSigner, testSigner) {
+	t.Helper()
+	rk, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa key: %v", err)
+	}
+	ek, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("ec key: %v", err)
+	}
+	return testSigner{kid: "rsa-1", rsa: rk}, testSigner{kid: "ec-1", ec: ek}
+}
+
+func jwksJSON(signers ...testSigner) []byte {
+	var keys []map[string]string
+	for _, s := range signers {
+		keys = append(keys, s.jwk())
+	}
+	raw, _ := json.Marshal(map[string]any{"keys": keys})
+	return raw
+}
+
+// setupJWT writes a JWKS file and configures the issuer and audience.
+func setupJWT(t *testing.T, signers ...testSigner) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, jwksJSON(signers...), 0o600); err != nil {
+		t.Fatalf("write jwks: %v", err)
+	}
+	t.Setenv("JWT_JWKS_FILE", path)
+	t.Setenv("JWT_ISSUER", "https://id.example.com/")
+	t.Setenv("JWT_AUDIENCE", "picca-api")
+	prev := jwksKeys
+	jwksKeys = newJWKSCache(time.Now)
+	t.Cleanup(func() { jwksKeys = prev })
+}
+
+func validClaims(sub string) map[string]any {
+	return map[string]any{
+		"iss":   "https://id.example.com/",
+		"aud":   []string{"picca-api", "other"},
+		"sub":   sub,
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"scope": "openid score explain",
+	}
+}
+
+func TestVerifyJWT_ReasonCodes(t *testing.T) {
+	rs, es := newTestSigners(t)
+	setupJWT(t, rs, es)
+	cfg, _ := currentJWTConfig()
+	_, stranger := newTestSigners(t)
+	stranger.kid = rs.kid
+
+	with := func(k string, v any) map[string]any {
+		c := validClaims("user-1")
+		if v == nil {
+			delete(c, k)
+		} else {
+			c[k] = v
+		}
+		return c
+	}
+	cases := map[string]struct {
+		token string
+		want  string
+	}{
+		"rs256":      {rs.sign(t, validClaims("user-1")), ""},
+		"es256":      {es.sign(t, validClaims("user-1")), ""},
+		"malformed":  {"abc.def", "JWT_MALFORMED"},
+		"alg none":   {base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + ".e30.", "JWT_UNSUPPORTED_ALG"},
+		"bad sig":    {stranger.sign(t, validClaims("user-1")), "JWT_INVALID_SIGNATURE"},
+		"expired":    {rs.sign(t, with("exp", time.Now().Add(-2*time.Minute).Unix())), "JWT_EXPIRED"},
+		"future nbf": {rs.sign(t, with("nbf", time.Now().Add(time.Hour).Unix())), "JWT_NOT_YET_VALID"},
+		"issuer":     {rs.sign(t, with("iss", "https://evil.example.com/")), "JWT_INVALID_ISSUER"},
+		"audience":   {rs.sign(t, with("aud", "someone-else")), "JWT_INVALID_AUDIENCE"},
+		"no sub":     {rs.sign(t, with("sub", nil)), "JWT_INVALID_SUBJECT"},
+	}
+	for name, tc := range cases {
+		claims, err := verifyJWT(tc.token, cfg, jwksKeys, time.Now())
+		switch {
+		case tc.want == "" && err != nil:
+			t.Errorf("%s: unexpected error %v", name, err)
+		case tc.want == "" && (claims.Subject != "user-1" || len(claims.Scopes) != 2):
+			t.Errorf("%s: unexpected claims %+v", name, claims)
+		case tc.want != "" && (err == nil || err.reasonCode != tc.want):
+			t.Errorf("%s: want %s, got %v", name, tc.want, err)
+		}
+	}
+}
+
+func TestJWKSCache_URLRefetchesOnUnknownKid(t *testing.T) {
+	rs, es := newTestSigners(t)
+	var served atomic.Value
+	served.Store(jwksJSON(rs))
+	var fetches int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		_, _ = w.Write(served.Load().([]byte))
+	}))
+	defer srv.Close()
+
+	now := time.Unix(5000, 0)
+	cache := newJWKSCache(func() time.Time { return now })
+	for i := 0; i < 3; i++ {
+		if _, err := cache.key(srv.URL, rs.kid); err != nil {
+			t.Fatalf("key: %v", err)
+		}
+	}
+	if fetches != 1 {
+		t.Fatalf("want 1 fetch while cached, got %d", fetches)
+	}
+
+	served.Store(jwksJSON(rs, es))
+	if _, err := cache.key(srv.URL, es.kid); err != errJWTUnknownKey {
+		t.Fatalf("refetch should be throttled, got %v", err)
+	}
+	now = now.Add(jwksMinRefresh)
+	if _, err := cache.key(srv.URL, es.kid); err != nil {
+		t.Fatalf("rotated key not picked up: %v", err)
+	}
+	if fetches != 2 {
+		t.Fatalf("want 2 fetches, got %d", fetches)
+	}
+}
+
+func TestJWKSCache_FetchesOutsideTheLock(t *testing.T) {
+	rs, es := newTestSigners(t)
+	release := make(chan struct{})
+	var fetches int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&fetches, 1) > 1 {
+			<-release
+		}
+		_, _ = w.Write(jwksJSON(rs, es))
+	}))
+	defer srv.Close()
+
+	now := time.Unix(5000, 0).Add(-jwksMinRefresh)
+	cache := newJWKSCache(func() time.Time { return now })
+	if _, err := cache.key(srv.URL, rs.kid); err != nil {
+		t.Fatalf("key: %v", err)
+	}
+	now = now.Add(jwksMinRefresh)
+
+	// Two lookups of an unknown kid share one slow refetch...
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := cache.key(srv.URL, "rotated")
+			errs <- err
+		}()
+	}
+	for atomic.LoadInt32(&fetches) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	// ...while lookups of cached keys are not held up behind it.
+	if _, err := cache.key(srv.URL, rs.kid); err != nil {
+		t.Fatalf("cached key blocked or failed: %v", err)
+	}
+	close(release)
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != errJWTUnknownKey {
+			t.Fatalf("want unknown key, got %v", err)
+		}
+	}
+	if fetches != 2 {
+		t.Fatalf("want the refetch shared, got %d fetches", fetches)
+	}
+}
+
+func TestBearerToken_ScoresAndPinsSubject(t *testing.T) {
+	setupExplainTest(t)
+	rs, _ := newTestSigners(t)
+	setupJWT(t, rs)
+	prevSessions := scoredSessions
+	scoredSessions = newSessionStore()
+	t.Cleanup(func() { scoredSessions = prevSessions })
+
+	ml := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"score":70,"symmetry":0.7,"power":0.7,"consistency":0.7}`))
+	}))
+	defer ml.Close()
+	t.Setenv("API_ML_URL", ml.URL)
+
+	r := newRouter()
+	send := func(token, subject string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/score", bytes.NewBufferString(`{}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		if subject != "" {
+			req.Header.Set("X-Subject-Id", subject)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	token := rs.sign(t, validClaims("user-1"))
+	w := send(token, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("score: %d %s", w.Code, w.Body.String())
+	}
+	if got := scoredSessions.forSubject("user-1"); len(got) != 1 {
+		t.Fatalf("session not stored under token subject: %+v", got)
+	}
+	if w := send(token, "user-2"); w.Code != http.StatusForbidden || !bytes.Contains(w.Body.Bytes(), []byte("SUBJECT_MISMATCH")) {
+		t.Fatalf("foreign subject: %d %s", w.Code, w.Body.String())
+	}
+
+	noScore := validClaims("user-1")
+	noScore["scope"] = "explain"
+	if w := send(rs.sign(t, noScore), ""); w.Code != http.StatusForbidden || !bytes.Contains(w.Body.Bytes(), []byte("INSUFFICIENT_SCOPE")) {
+		t.Fatalf("missing scope: %d %s", w.Code, w.Body.String())
+	}
+	for _, name := range []string{"JWT_AUDIENCE", "JWT_ISSUER", "JWT_JWKS_FILE"} {
+		t.Setenv(name, "")
+		if w := send(token, ""); w.Code != http.StatusUnauthorized || !bytes.Contains(w.Body.Bytes(), []byte("JWT_NOT_ENABLED")) {
+			t.Fatalf("jwt without %s: %d %s", name, w.Code, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"picca/api-go/reqsign"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	scopeScore   = "score"
+	scopeExplain = "explain"
+	scopeAdmin   = "admin"
+)
+
+// apiKeyEntry is one named client key. Secrets should be given as SHA-256 hex
+// digests; a plaintext "key" is accepted for local use and hashed on load.
+// Server-to-server clients may instead (or also) hold a signing_secret for
+// HMAC-signed requests; require_signature refuses the plain key for them.
+// client_subject maps a verified TLS client certificate (CN or full DN) onto
+// the entry.
+type apiKeyEntry struct {
+	Name             string       `json:"name"`
+	SHA256           string       `json:"sha256,omitempty"`
+	Key              string       `json:"key,omitempty"`
+	SigningSecret    string       `json:"signing_secret,omitempty"`
+	RequireSignature bool         `json:"require_signature,omitempty"`
+	ClientSubject    string       `json:"client_subject,omitempty"`
+	Scopes           []string     `json:"scopes"`
+	Disabled         bool         `json:"disabled,omitempty"`
+	ExpiresAt        *time.Time   `json:"expires_at,omitempty"`
+	Quota            *quotaLimits `json:"quota,omitempty"`
+
+	digest  [sha256.Size]byte
+	keyless bool
+}
+
+// fingerprint identifies the entry's credentials without exposing them, so
+// reloads can tell a rotated secret from an unchanged one.
+func (k apiKeyEntry) fingerprint() [sha256.Size]byte {
+	return sha256.Sum256([]byte(string(k.digest[:]) + k.SigningSecret + "\x00" + k.ClientSubject))
+}
+
+type keyFile struct {
+	Keys []apiKeyEntry `json:"keys"`
+}
+
+type keyStore struct {
+	keys    []apiKeyEntry
+	retired []retiredKey
+}
+
+// retiredKey is a key dropped or replaced by a reload that stays valid until
+// its grace period ends, so clients can switch over without an outage.
+type retiredKey struct {
+	entry apiKeyEntry
+	until time.Time
+}
+
+// principal is the authenticated caller. Name is safe to log; secrets never
+// leave the key store. Subject is set for end-user tokens and pins the
+// caller's session history.
+type principal struct {
+	Name    string
+	Scopes  []string
+	Quota   *quotaLimits
+	Subject string
+}
+
+func (p principal) hasScope(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func parseKeyFile(raw []byte) (*keyStore, error) {
+	var kf keyFile
+	if err := json.Unmarshal(raw, &kf); err != nil {
+		return nil, fmt.Errorf("parse key file: %w", err)
+	}
+	store := &keyStore{}
+	seen := map[string]bool{}
+	for i, k := range kf.Keys {
+		k.Name = strings.TrimSpace(k.Name)
+		if k.Name == "" {
+			return nil, fmt.Errorf("key %d: missing name", i)
+		}
+		if seen[k.Name] {
+			return nil, fmt.Errorf("key %q: duplicate name", k.Name)
+		}
+		seen[k.Name] = true
+
+		switch {
+		case k.SHA256 != "":
+			b, err := hex.DecodeString(strings.TrimSpace(k.SHA256))
+			if err != nil || len(b) != sha256.Size {
+				return nil, fmt.Errorf("key %q: sha256 must be 64 hex characters", k.Name)
+			}
+			copy(k.digest[:], b)
+		case k.Key != "":
+			k.digest = sha256.Sum256([]byte(k.Key))
+		case k.SigningSecret != "" || k.ClientSubject != "":
+			k.keyless = true
+		default:
+			return nil, fmt.Errorf("key %q: needs sha256, key, signing_secret or client_subject", k.Name)
+		}
+		k.Key = ""
+		store.keys = append(store.keys, k)
+	}
+	return store, nil
+}
+
+// envKeyStore maps the legacy single-key variables onto named keys:
+// API_KEY becomes "default" (score, explain) and ADMIN_API_KEY "admin".
+func envKeyStore() *keyStore {
+	store := &keyStore{}
+	if v := os.Getenv("API_KEY"); v != "" {
+		store.keys = append(store.keys, apiKeyEntry{Name: "default", Scopes: []string{scopeScore, scopeExplain}, digest: sha256.Sum256([]byte(v))})
+	}
+	if v := os.Getenv("ADMIN_API_KEY"); v != "" {
+		store.keys = append(store.keys, apiKeyEntry{Name: "admin", Scopes: []string{scopeAdmin}, digest: sha256.Sum256([]byte(v))})
+	}
+	return store
+}
+
+// currentKeyStore resolves keys from API_KEYS_FILE, inline API_KEYS JSON, or
+// the legacy API_KEY/ADMIN_API_KEY variables, in that order.
+func currentKeyStore() (*keyStore, error) {
+	if path := strings.TrimSpace(os.Getenv("API_KEYS_FILE")); path != "" {
+		return keyFiles.current(path)
+	}
+	if raw := strings.TrimSpace(os.Getenv("API_KEYS")); raw != "" {
+		return parseKeyFile([]byte(raw))
+	}
+	return envKeyStore(), nil
+}
+
+var (
+	errUnknownKey  = errors.New("unknown key")
+	errDisabledKey = errors.New("key disabled")
+	errExpiredKey  = errors.New("key expired")
+)
+
+// lookup compares the presented secret's digest against every active and
+// in-grace retired entry in constant time, without stopping at the first match.
+func (s *keyStore) lookup(presented string, now time.Time) (apiKeyEntry, error) {
+	digest := sha256.Sum256([]byte(presented))
+	var (
+		k     apiKeyEntry
+		found bool
+	)
+	for i := range s.keys {
+		if subtle.ConstantTimeCompare(digest[:], s.keys[i].digest[:]) == 1 && !s.keys[i].keyless {
+			k, found = s.keys[i], true
+		}
+	}
+	for i := range s.retired {
+		inGrace := now.Before(s.retired[i].until) && !s.retired[i].entry.keyless
+		if subtle.ConstantTimeCompare(digest[:], s.retired[i].entry.digest[:]) == 1 && inGrace && !found {
+			k, found = s.retired[i].entry, true
+		}
+	}
+	if !found {
+		return apiKeyEntry{}, errUnknownKey
+	}
+	return k, k.usable(now)
+}
+
+func (k apiKeyEntry) usable(now time.Time) error {
+	if k.Disabled {
+		return errDisabledKey
+	}
+	if k.ExpiresAt != nil && !now.Before(*k.ExpiresAt) {
+		return errExpiredKey
+	}
+	return nil
+}
+
+func currentPrincipal(c *gin.Context) (principal, bool) {
+	if v, ok := c.Get("principal"); ok {
+		if p, ok := v.(principal); ok {
+			return p, true
+		}
+	}
+	return principal{}, false
+}
+
+func denyAuth(c *gin.Context, status int, message, reasonCode string) bool {
+	c.JSON(status, gin.H{"error": message, "reason_code": reasonCode})
+	annotateResult(c, reasonCode, 0)
+	return false
+}
+
+// authenticate resolves the caller's principal once per request from an API
+// key or, when no key is sent, a bearer JWT. It writes the error response
+// itself when the credentials are missing or invalid.
+func authenticate(c *gin.Context) (principal, bool) {
+	if p, ok := currentPrincipal(c); ok {
+		return p, true
+	}
+	var (
+		p  principal
+		ok bool
+	)
+	switch credentialKind(c.Request) {
+	case credentialSigned:
+		p, ok = authenticateSigned(c)
+	case credentialJWT:
+		token, _ := bearerToken(c.Request)
+		p, ok = authenticateJWT(c, token)
+	case credentialCert:
+		p, ok = authenticateCert(c, verifiedClientCert(c.Request))
+	default:
+		p, ok = authenticateKey(c)
+	}
+	if !ok {
+		return principal{}, false
+	}
+	c.Set("principal", p)
+	annotateOTS(c.Request, "key_name", p.Name)
+	return p, true
+}
+
+// Credential kinds in the order authenticate prefers them. The archive
+// records the kind so replay knows which requests it can re-authenticate.
+const (
+	credentialSigned = "signed"
+	credentialJWT    = "jwt"
+	credentialCert   = "cert"
+	credentialAPIKey = "api_key"
+)
+
+// credentialKind reports which credential authenticate will check for r: a
+// request signature, then an API key, then a bearer JWT or client
+// certificate. Requests without any fall through to the API key check.
+func credentialKind(r *http.Request) string {
+	if r.Header.Get(reqsign.HeaderSignature) != "" {
+		return credentialSigned
+	}
+	if r.Header.Get("X-API-Key") != "" {
+		return credentialAPIKey
+	}
+	if _, ok := bearerToken(r); ok {
+		return credentialJWT
+	}
+	if verifiedClientCert(r) != nil {
+		return credentialCert
+	}
+	return credentialAPIKey
+}
+
+func authenticateKey(c *gin.Context) (principal, bool) {
+	store, err := currentKeyStore()
+	if err != nil || len(store.keys) == 0 {
+		return principal{}, denyAuth(c, http.StatusInternalServerError, "server misconfigured", "MISCONFIGURED_API_KEY")
+	}
+	presented := c.GetHeader("X-API-Key")
+	if presented == "" {
+		return principal{}, denyAuth(c, http.StatusUnauthorized, "unauthorized", "INVALID_API_KEY")
+	}
+	key, err := store.lookup(presented, time.Now())
+	if err != nil {
+		return principal{}, denyKeyError(c, err)
+	}
+	if key.RequireSignature {
+		return principal{}, denyAuth(c, http.StatusUnauthorized, "signed requests required", "SIGNATURE_REQUIRED")
+	}
+	return key.principal(), true
+}
+
+func (k apiKeyEntry) principal() principal {
+	return principal{Name: k.Name, Scopes: k.Scopes, Quota: k.Quota}
+}
+
+func denyKeyError(c *gin.Context, err error) bool {
+	switch {
+	case errors.Is(err, errDisabledKey):
+		return denyAuth(c, http.StatusUnauthorized, "unauthorized", "API_KEY_DISABLED")
+	case errors.Is(err, errExpiredKey):
+		return denyAuth(c, http.StatusUnauthorized, "unauthorized", "API_KEY_EXPIRED")
+	}
+	return denyAuth(c, http.StatusUnauthorized, "unauthorized", "INVALID_API_KEY")
+}
+
+// authorize authenticates the caller, checks it holds scope and applies the
+// scope's rate limits and quota checks; handlers charge the quota with
+// chargeQuota after validation. It writes the error response itself and is
+// safe to call more than once per request.
+func authorize(c *gin.Context, scope string) bool {
+	p, ok := authenticate(c)
+	if !ok {
+		return false
+	}
+	if !p.hasScope(scope) {
+		return denyAuth(c, http.StatusForbidden, "forbidden", "INSUFFICIENT_SCOPE")
+	}
+	if scope != scopeAdmin && !c.GetBool("rate_checked") {
+		c.Set("rate_checked", true)
+		if !allowRate(c, scope, p.Name) {
+			return false
+		}
+		if !checkQuota(c, scope, p) {
+			return false
+		}
+	}
+	return true
+}
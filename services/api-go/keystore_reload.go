@@ -0,0 +1,213 @@
+package main
+
+import (
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+func keyRotationGrace() time.Duration {
+	if v := os.Getenv("KEY_ROTATION_GRACE"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+			return d
+		}
+	}
+	return time.Hour
+}
+
+func keyFilePollInterval() time.Duration {
+	if v := os.Getenv("KEY_FILE_POLL_INTERVAL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			return d
+		}
+	}
+	return 10 * time.Second
+}
+
+// keyFileStore holds the keys loaded from API_KEYS_FILE. Reloads keep the
+// previous keys usable for KEY_ROTATION_GRACE when they are removed or their
+// secret changes; a key marked "disabled" in the new file is revoked at once.
+type keyFileStore struct {
+	mu      sync.RWMutex
+	path    string
+	modTime time.Time
+	size    int64
+	store   *keyStore
+	now     func() time.Time
+}
+
+var keyFiles = &keyFileStore{now: time.Now}
+
+func logKeyRotation(action, name string, until time.Time) {
+	if until.IsZero() {
+		log.Printf("key_rotation action=%s key_name=%s", action, name)
+		return
+	}
+	log.Printf("key_rotation action=%s key_name=%s grace_until=%s", action, name, until.UTC().Format(time.RFC3339))
+}
+
+// current returns the store for path, loading it on first use or when the
+// configured path changes.
+func (f *keyFileStore) current(path string) (*keyStore, error) {
+	f.mu.RLock()
+	store, loadedPath := f.store, f.path
+	f.mu.RUnlock()
+	if store != nil && loadedPath == path {
+		return store, nil
+	}
+	if err := f.reload(path, "load"); err != nil {
+		return nil, err
+	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.store, nil
+}
+
+// reload re-reads path. On a parse or read error the previous keys stay in
+// effect.
+func (f *keyFileStore) reload(path, trigger string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		log.Printf("key_rotation action=reload_failed trigger=%s err=%v", trigger, err)
+		return err
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		log.Printf("key_rotation action=reload_failed trigger=%s err=%v", trigger, err)
+		return err
+	}
+	next, err := parseKeyFile(raw)
+	if err != nil {
+		log.Printf("key_rotation action=reload_failed trigger=%s err=%v", trigger, err)
+		f.mu.Lock()
+		if f.path == path {
+			// Remember the broken version so polling does not retry it.
+			f.modTime, f.size = info.ModTime(), info.Size()
+		}
+		f.mu.Unlock()
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.store != nil && f.path == path {
+		next.retired = rotateKeys(f.store, next, f.now(), keyRotationGrace())
+	} else {
+		for _, k := range next.keys {
+			logKeyRotation("loaded", k.Name, time.Time{})
+		}
+	}
+	f.path, f.modTime, f.size, f.store = path, info.ModTime(), info.Size(), next
+	log.Printf("key_rotation action=reloaded trigger=%s keys=%d retired=%d", trigger, len(next.keys), len(next.retired))
+	return nil
+}
+
+// rotateKeys computes which keys of prev remain valid in grace after next
+// replaces it, logging every change.
+func rotateKeys(prev, next *keyStore, now time.Time, grace time.Duration) []retiredKey {
+	byName := make(map[string]apiKeyEntry, len(next.keys))
+	active := make(map[[32]byte]bool, len(next.keys))
+	for _, k := range next.keys {
+		byName[k.Name] = k
+		active[k.fingerprint()] = true
+	}
+
+	var retired []retiredKey
+	for _, r := range prev.retired {
+		if now.Before(r.until) && !active[r.entry.fingerprint()] {
+			if nk, ok := byName[r.entry.Name]; !ok || !nk.Disabled {
+				retired = append(retired, r)
+			}
+		}
+	}
+
+	until := now.Add(grace)
+	prevNames := make(map[string]bool, len(prev.keys))
+	for _, old := range prev.keys {
+		prevNames[old.Name] = true
+		nk, kept := byName[old.Name]
+		switch {
+		case kept && nk.Disabled && !old.Disabled:
+			logKeyRotation("revoked", old.Name, time.Time{})
+		case kept && nk.fingerprint() == old.fingerprint():
+		case old.Disabled || (kept && nk.Disabled):
+		case grace == 0:
+			logKeyRotation("removed", old.Name, time.Time{})
+		case kept:
+			retired = append(retired, retiredKey{entry: old, until: until})
+			logKeyRotation("rotated", old.Name, until)
+		default:
+			retired = append(retired, retiredKey{entry: old, until: until})
+			logKeyRotation("removed", old.Name, until)
+		}
+	}
+	for _, k := range next.keys {
+		if !prevNames[k.Name] {
+			logKeyRotation("added", k.Name, time.Time{})
+		}
+	}
+	return retired
+}
+
+// poll reloads API_KEYS_FILE when its size or mtime changes and drops
+// retired keys whose grace period has ended.
+func (f *keyFileStore) poll() {
+	f.mu.RLock()
+	path, modTime, size := f.path, f.modTime, f.size
+	f.mu.RUnlock()
+	if path == "" {
+		return
+	}
+	if info, err := os.Stat(path); err == nil && (!info.ModTime().Equal(modTime) || info.Size() != size) {
+		if f.reload(path, "file_change") == nil {
+			return
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.store == nil || len(f.store.retired) == 0 {
+		return
+	}
+	now := f.now()
+	var kept []retiredKey
+	for _, r := range f.store.retired {
+		if now.Before(r.until) {
+			kept = append(kept, r)
+		} else {
+			logKeyRotation("grace_expired", r.entry.Name, time.Time{})
+		}
+	}
+	if len(kept) != len(f.store.retired) {
+		f.store = &keyStore{keys: f.store.keys, retired: kept}
+	}
+}
+
+// startKeyReloader reloads the key file on every value from hup and on file
+// changes, until stop is closed.
+func startKeyReloader(hup <-chan os.Signal, stop <-chan struct{}) {
+	path := os.Getenv("API_KEYS_FILE")
+	if path == "" {
+		return
+	}
+	if _, err := keyFiles.current(path); err != nil {
+		log.Printf("keystore: initial load of %s failed: %v", path, err)
+	}
+	interval := keyFilePollInterval()
+	log.Printf("keystore: watching %s (poll %s, grace %s)", path, interval, keyRotationGrace())
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-hup:
+				_ = keyFiles.reload(path, "sighup")
+			case <-ticker.C:
+				keyFiles.poll()
+			}
+		}
+	}()
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestKeyFileStore_RotationWithGrace(t *testing.T) {
+	t.Setenv("KEY_ROTATION_GRACE", "10m")
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	f := &keyFileStore{now: func() time.Time { return now }}
+	path := filepath.Join(t.TempDir(), "keys.json")
+	write := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	valid := func(secret string) error {
+		t.Helper()
+		store, err := f.current(path)
+		if err != nil {
+			t.Fatalf("current: %v", err)
+		}
+		_, err = store.lookup(secret, now)
+		return err
+	}
+
+	write(`{"keys":[{"name":"web","key":"old-secret","scopes":["score"]},{"name":"batch","key":"batch-secret","scopes":["score"]}]}`)
+	if err := valid("old-secret"); err != nil {
+		t.Fatalf("initial key rejected: %v", err)
+	}
+
+	write(`{"keys":[{"name":"web","key":"new-secret","scopes":["score"]},{"name":"batch","key":"batch-secret","scopes":["score"],"disabled":true}]}`)
+	if err := f.reload(path, "test"); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if err := valid("new-secret"); err != nil {
+		t.Fatalf("new key rejected: %v", err)
+	}
+	if err := valid("old-secret"); err != nil {
+		t.Fatalf("old key should overlap during grace: %v", err)
+	}
+	if err := valid("batch-secret"); !errors.Is(err, errDisabledKey) {
+		t.Fatalf("disabled key must be revoked immediately, got %v", err)
+	}
+
+	write(`{not json`)
+	if err := f.reload(path, "test"); err == nil {
+		t.Fatalf("expected reload error")
+	}
+	if err := valid("new-secret"); err != nil {
+		t.Fatalf("failed reload must keep previous keys: %v", err)
+	}
+
+	now = now.Add(11 * time.Minute)
+	if err := valid("old-secret"); !errors.Is(err, errUnknownKey) {
+		t.Fatalf("old key valid after grace: %v", err)
+	}
+	f.poll()
+	if store, _ := f.current(path); len(store.retired) != 0 {
+		t.Fatalf("expired retired keys not pruned: %d", len(store.retired))
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// captureStdout returns everything fn prints to os.Stdout.
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	prev := os.Stdout
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("pipe: %v", err)
+	}
+	flushOTS()
+	os.Stdout = w
+	done := make(chan string)
+	go func() {
+		b, _ := io.ReadAll(r)
+		done <- string(b)
+	}()
+	defer func() { os.Stdout = prev }()
+	fn()
+	flushOTS()
+	w.Close()
+	return <-done
+}
+
+func writeKeyFile(t *testing.T, content string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "keys.json")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("write key file: %v", err)
+	}
+	t.Setenv("API_KEYS_FILE", path)
+}
+
+func TestKeyStore_ScopesExpiryAndDisable(t *testing.T) {
+	setupExplainTest(t)
+	writeKeyFile(t, `{"keys":[
+		{"name":"web","sha256":"`+sha256Hex("web-secret")+`","scopes":["score","explain"]},
+		{"name":"batch","sha256":"`+sha256Hex("batch-secret")+`","scopes":["score"]},
+		{"name":"old","key":"old-secret","scopes":["explain"],"expires_at":"2020-01-01T00:00:00Z"},
+		{"name":"off","key":"off-secret","scopes":["explain"],"disabled":true}
+	]}`)
+
+	cases := []struct {
+		key    string
+		status int
+		reason string
+	}{
+		{"web-secret", http.StatusOK, ""},
+		{"batch-secret", http.StatusForbidden, "INSUFFICIENT_SCOPE"},
+		{"old-secret", http.StatusUnauthorized, "API_KEY_EXPIRED"},
+		{"off-secret", http.StatusUnauthorized, "API_KEY_DISABLED"},
+		{"secret", http.StatusUnauthorized, "INVALID_API_KEY"},
+	}
+	r := newRouter()
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/explain", bytes.NewBufferString(explainMetrics))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-API-Key", tc.key)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != tc.status || !strings.Contains(w.Body.String(), tc.reason) {
+			t.Errorf("key %s: got %d %s, want %d %s", tc.key, w.Code, w.Body.String(), tc.status, tc.reason)
+		}
+	}
+}
+
+func TestKeyStore_KeyNameInOTSLog(t *testing.T) {
+	setupExplainTest(t)
+	writeKeyFile(t, `{"keys":[{"name":"partner-a","key":"partner-secret","scopes":["explain"]}]}`)
+
+	h := OTSMiddleware("test-run", newRouter())
+	out := captureStdout(t, func() {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/explain", bytes.NewBufferString(explainMetrics))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-API-Key", "partner-secret")
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	})
+	if !strings.Contains(out, `"key_name":"partner-a"`) {
+		t.Fatalf("OTS line missing key name: %s", out)
+	}
+	if strings.Contains(out, "partner-secret") {
+		t.Fatalf("secret leaked into logs: %s", out)
+	}
+}
+
+func TestParseKeyFile_Errors(t *testing.T) {
+	for name, raw := range map[string]string{
+		"missing secret": `{"keys":[{"name":"a","scopes":["score"]}]}`,
+		"bad digest":     `{"keys":[{"name":"a","sha256":"abc","scopes":["score"]}]}`,
+		"duplicate":      `{"keys":[{"name":"a","key":"x"},{"name":"a","key":"y"}]}`,
+		"missing name":   `{"keys":[{"key":"x"}]}`,
+	} {
+		if _, err := parseKeyFile([]byte(raw)); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
@@ -12,9 +12,9 @@ import (
 	"log"
 	"net"
 	"net/http"
-	"net/url"
 	"os"
 	"os/signal"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -23,7 +23,6 @@ import (
 
 	"cloud.google.com/go/compute/metadata"
 	"github.com/gin-gonic/gin"
-	"golang.org/x/oauth2/google"
 )
 
 //go:embed web/*
@@ -32,9 +31,7 @@ var webFS embed.FS
 var (
 	httpClient = &http.Client{Timeout: 3 * time.Second}
 
-	newVertexClient = func(ctx context.Context) (*http.Client, error) {
-		return google.DefaultClient(ctx, "https://www.googleapis.com/auth/cloud-platform")
-	}
+	newVertexClient = defaultVertexClient
 
 	runID = deriveRunID()
 )
@@ -62,10 +59,10 @@ func withHealthz(next http.Handler, h http.Handler) http.Handler {
 	})
 }
 
-func apiKeyMiddleware() gin.HandlerFunc {
+func apiKeyMiddleware(scope string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		requestID(c)
-		if !validateAPIKey(c) {
+		if !authorize(c, scope) {
 			c.Abort()
 			return
 		}
@@ -73,21 +70,25 @@ func apiKeyMiddleware() gin.HandlerFunc {
 	}
 }
 
-type explainRequest struct {
-	Score       float64 `json:"score"`
-	Symmetry    float64 `json:"symmetry"`
-	Power       float64 `json:"power"`
-	Consistency float64 `json:"consistency"`
-}
+// Response schema versions recorded on OTS lines; bump them when the score or
+// explain response shape changes.
+const (
+	scoreSchemaVersion   = "score.v1"
+	explainSchemaVersion = "explain.v1"
+)
 
-type vertexResponse struct {
-	Candidates []struct {
-		Content struct {
-			Parts []struct {
-				Text string `json:"text"`
-			} `json:"parts"`
-		} `json:"content"`
-	} `json:"candidates"`
+type explainRequest struct {
+	sessionMetrics
+	Format   string `json:"format,omitempty"`
+	Language string `json:"language,omitempty"`
+
+	// Trend mode: explicit prior sessions (oldest first) or a subject whose
+	// stored sessions are looked up; only the last HistorySessions are used.
+	// SessionID names the stored session being explained, if any.
+	History         []sessionMetrics `json:"history,omitempty"`
+	SubjectID       string           `json:"subject_id,omitempty"`
+	SessionID       string           `json:"session_id,omitempty"`
+	HistorySessions int              `json:"history_sessions,omitempty"`
 }
 
 func mountDemo(r *gin.Engine) {
@@ -129,47 +130,59 @@ document.getElementById('run').onclick=async()=>{
 }
 
 func mountAPI(r *gin.Engine) {
+	r.Use(metricsMiddleware(), corsMiddleware())
 	apiV1 := r.Group("/api/v1")
 	apiV1.POST("/score", scoreHandler)
-	apiV1.OPTIONS("/explain", explainOptionsHandler)
 
-	api := r.Group("/api/v1", apiKeyMiddleware())
+	api := r.Group("/api/v1", apiKeyMiddleware(scopeExplain))
 	api.POST("/explain", explainHandler)
 	log.Println("mounted /api/v1/explain")
 
-	for _, alias := range []string{"/explain", "/api/explain", "/v1/explain"} {
-		r.POST(alias, apiKeyMiddleware(), explainHandler)
+	apiV1.GET("/admin/cost", adminCostHandler)
+	apiV1.GET("/usage", usageHandler)
+	apiV1.POST("/:action", explainActionHandler)
+
+	aliases := []string{"/explain", "/api/explain", "/v1/explain"}
+	for _, alias := range aliases {
+		r.POST(alias, apiKeyMiddleware(scopeExplain), explainHandler)
+	}
+
+	// Answer OPTIONS on every API route, since corsMiddleware only handles
+	// preflights from allowed origins.
+	methods := map[string][]string{}
+	var paths []string
+	for _, rt := range r.Routes() {
+		if !strings.HasPrefix(rt.Path, "/api/") && !slices.Contains(aliases, rt.Path) {
+			continue
+		}
+		if methods[rt.Path] == nil {
+			paths = append(paths, rt.Path)
+		}
+		methods[rt.Path] = append(methods[rt.Path], rt.Method)
+	}
+	for _, path := range paths {
+		r.OPTIONS(path, optionsHandler(append([]string{http.MethodOptions}, methods[path]...)))
 	}
 }
 
-func logReq(c *gin.Context, status int, latencyMs int64, inputHash, outputHash string) {
-	if status < 400 {
-		return
+// optionsHandler answers OPTIONS with 204 and the route's Allow header.
+func optionsHandler(methods []string) gin.HandlerFunc {
+	allow := strings.Join(methods, ", ")
+	return func(c *gin.Context) {
+		c.Header("Allow", allow)
+		c.Status(http.StatusNoContent)
 	}
-	reqID := requestID(c)
-	path := c.FullPath()
-	if path == "" {
-		path = c.Request.URL.Path
-	}
-	if latencyMs < 0 {
-		latencyMs = 0
-	}
-	entry := map[string]any{
-		"ts":          time.Now().UTC().Format(time.RFC3339Nano),
-		"run_id":      runID,
-		"path":        path,
-		"status":      status,
-		"latency_ms":  latencyMs,
-		"req_id":      reqID,
-		"input_hash":  inputHash,
-		"output_hash": outputHash,
-	}
-	line, err := json.Marshal(entry)
-	if err != nil {
-		log.Printf("log marshal error: %v", err)
-		return
+}
+
+// annotateResult records an error response's reason code and the time spent
+// waiting on ML or Vertex on the request's OTS line.
+func annotateResult(c *gin.Context, reasonCode string, upstreamMs int64) {
+	if reasonCode != "" {
+		annotateOTS(c.Request, "reason_code", reasonCode)
+	}
+	if upstreamMs > 0 {
+		annotateOTS(c.Request, "upstream_ms", upstreamMs)
 	}
-	fmt.Println(string(line))
 }
 
 func maxBodyBytes() int64 {
@@ -181,40 +194,25 @@ func maxBodyBytes() int64 {
 	return 1 << 20 // 1 MiB default
 }
 
+// requestID returns the ID shared with OTSMiddleware, resolving it here when
+// the handler is served without the middleware.
 func requestID(c *gin.Context) string {
 	if v, ok := c.Get("req_id"); ok {
 		if s, ok := v.(string); ok && s != "" {
 			return s
 		}
 	}
-	reqID := strings.TrimSpace(c.GetHeader("X-Request-Id"))
-	if reqID == "" {
-		reqID = time.Now().UTC().Format(time.RFC3339Nano)
-	}
-	c.Header("X-Request-Id", reqID)
-	c.Set("req_id", reqID)
-	return reqID
-}
-
-func validateAPIKey(c *gin.Context) bool {
-	expectedKey := os.Getenv("API_KEY")
-	if expectedKey == "" {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "server misconfigured", "reason_code": "MISCONFIGURED_API_KEY"})
-		logReq(c, http.StatusInternalServerError, 0, "", "")
-		return false
-	}
-	if c.GetHeader("X-API-Key") != expectedKey {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized", "reason_code": "INVALID_API_KEY"})
-		logReq(c, http.StatusUnauthorized, 0, "", "")
-		return false
-	}
-	return true
+	var ids requestIDs
+	c.Request, ids = withRequestID(c.Request)
+	c.Header("X-Request-Id", ids.id)
+	c.Set("req_id", ids.id)
+	return ids.id
 }
 
 func ensureJSONContentType(c *gin.Context) bool {
 	if !isJSON(c.GetHeader("Content-Type")) {
 		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "unsupported media", "reason_code": "UNSUPPORTED_MEDIA_TYPE"})
-		logReq(c, http.StatusUnsupportedMediaType, 0, "", "")
+		annotateResult(c, "UNSUPPORTED_MEDIA_TYPE", 0)
 		return false
 	}
 	return true
@@ -239,55 +237,80 @@ func resolveProjectID(ctx context.Context) (string, error) {
 	return projectID, nil
 }
 
-func extractVertexSummary(body []byte) (string, error) {
-	var resp vertexResponse
-	if err := json.Unmarshal(body, &resp); err != nil {
-		return "", err
-	}
-	for _, cand := range resp.Candidates {
-		for _, part := range cand.Content.Parts {
-			if strings.TrimSpace(part.Text) != "" {
-				return part.Text, nil
-			}
-		}
-	}
-	return "", errors.New("no summary in response")
+// validateSpan wraps a handler's request validation in a span that fails
+// with the response status when validation rejected the request.
+func validateSpan[T any](c *gin.Context, name string, fn func() (T, bool)) (T, bool) {
+	_, sp := startSpan(c.Request.Context(), name, spanInternal)
+	v, ok := fn()
+	if !ok {
+		sp.setAttr("http.status_code", c.Writer.Status())
+		sp.fail(http.StatusText(c.Writer.Status()))
+	}
+	sp.finish()
+	return v, ok
 }
 
-func scoreHandler(c *gin.Context) {
-	reqID := requestID(c)
+type scoreInput struct {
+	subjectID string
+	body      []byte
+}
 
-	if !validateAPIKey(c) {
-		return
+func readScoreRequest(c *gin.Context) (scoreInput, bool) {
+	if !authorize(c, scopeScore) {
+		return scoreInput{}, false
 	}
 	if !ensureJSONContentType(c) {
-		return
+		return scoreInput{}, false
+	}
+	subjectID, ok := subjectFromHeader(c)
+	if !ok {
+		return scoreInput{}, false
 	}
 
 	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBodyBytes())
 	body, err := io.ReadAll(c.Request.Body)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body", "reason_code": "INVALID_BODY"})
-		logReq(c, http.StatusBadRequest, 0, "", "")
+		annotateResult(c, "INVALID_BODY", 0)
+		return scoreInput{}, false
+	}
+	if !chargeQuota(c, scopeScore) {
+		return scoreInput{}, false
+	}
+	return scoreInput{subjectID: subjectID, body: body}, true
+}
+
+func scoreHandler(c *gin.Context) {
+	reqID := requestID(c)
+	annotateOTS(c.Request, "schema_version", scoreSchemaVersion)
+
+	in, ok := validateSpan(c, "score.validate", func() (scoreInput, bool) { return readScoreRequest(c) })
+	if !ok {
 		return
 	}
+	subjectID, body := in.subjectID, in.body
 
 	mlURL := strings.TrimRight(os.Getenv("API_ML_URL"), "/")
 	if mlURL == "" {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "server misconfigured", "reason_code": "MISCONFIGURED_UPSTREAM"})
-		logReq(c, http.StatusInternalServerError, 0, "", "")
+		annotateResult(c, "MISCONFIGURED_UPSTREAM", 0)
 		return
 	}
 
-	upstreamReq, err := http.NewRequestWithContext(c.Request.Context(), http.MethodPost, mlURL+"/predict", bytes.NewReader(body))
+	mlCtx, mlSpan := startSpan(c.Request.Context(), "ml.predict", spanClient)
+	defer mlSpan.finish()
+	mlSpan.setAttr("http.url", mlURL+"/predict")
+	upstreamReq, err := http.NewRequestWithContext(mlCtx, http.MethodPost, mlURL+"/predict", bytes.NewReader(body))
 	if err != nil {
+		mlSpan.fail("UPSTREAM_FAILURE")
 		c.JSON(http.StatusBadGateway, gin.H{"error": "ml upstream error", "reason_code": "UPSTREAM_FAILURE"})
-		logReq(c, http.StatusBadGateway, 0, "", "")
+		annotateResult(c, "UPSTREAM_FAILURE", 0)
 		return
 	}
 	upstreamReq.Header.Set("Content-Type", "application/json")
 	upstreamReq.Header.Set("Accept", "application/json")
 	upstreamReq.Header.Set("X-Request-Id", reqID)
+	injectTraceContext(mlCtx, upstreamReq.Header)
 
 	start := time.Now()
 	resp, err := httpClient.Do(upstreamReq)
@@ -295,78 +318,128 @@ func scoreHandler(c *gin.Context) {
 	if err != nil {
 		var nerr net.Error
 		if errors.As(err, &nerr) && nerr.Timeout() {
+			gwMetrics.mlDuration.observe(time.Since(start).Seconds(), "timeout")
+			mlSpan.fail("UPSTREAM_TIMEOUT")
 			c.JSON(http.StatusGatewayTimeout, gin.H{"error": "ml upstream timeout", "reason_code": "UPSTREAM_TIMEOUT"})
-			logReq(c, http.StatusGatewayTimeout, duration, "", "")
+			annotateResult(c, "UPSTREAM_TIMEOUT", duration)
 			return
 		}
+		gwMetrics.mlDuration.observe(time.Since(start).Seconds(), "transport")
+		mlSpan.fail("UPSTREAM_FAILURE")
 		c.JSON(http.StatusBadGateway, gin.H{"error": "ml upstream error", "reason_code": "UPSTREAM_FAILURE"})
-		logReq(c, http.StatusBadGateway, duration, "", "")
+		annotateResult(c, "UPSTREAM_FAILURE", duration)
 		return
 	}
 	defer resp.Body.Close()
 
 	respBody, err := io.ReadAll(resp.Body)
+	gwMetrics.mlDuration.observe(time.Since(start).Seconds(), upstreamOutcome(resp.StatusCode))
+	mlSpan.setAttr("http.status_code", resp.StatusCode)
 	if err != nil {
+		mlSpan.fail("UPSTREAM_FAILURE")
 		c.JSON(http.StatusBadGateway, gin.H{"error": "ml upstream error", "reason_code": "UPSTREAM_FAILURE"})
-		logReq(c, http.StatusBadGateway, duration, "", "")
+		annotateResult(c, "UPSTREAM_FAILURE", duration)
 		return
 	}
+	if resp.StatusCode >= 400 {
+		mlSpan.fail("UPSTREAM_STATUS_" + strconv.Itoa(resp.StatusCode))
+	}
 
+	if resp.StatusCode == http.StatusOK {
+		if sessionID, ok := recordScoredSession(subjectID, respBody); ok {
+			c.Header("X-Session-Id", sessionID)
+		}
+	}
 	c.Header("X-Request-Id", reqID)
 	c.Data(resp.StatusCode, resp.Header.Get("Content-Type"), respBody)
-	logReq(c, resp.StatusCode, duration, "", "")
+	annotateResult(c, "", duration)
 }
 
-func explainOptionsHandler(c *gin.Context) {
-	c.Header("Allow", "OPTIONS, POST")
-	c.Header("Access-Control-Allow-Methods", "OPTIONS, POST")
-	c.Header("Access-Control-Allow-Headers", "Content-Type,X-API-Key")
-	c.Status(http.StatusOK)
+type explainInput struct {
+	payload explainRequest
+	history []sessionMetrics
 }
 
-func explainHandler(c *gin.Context) {
-	reqID := requestID(c)
-
-	if !validateAPIKey(c) {
-		return
+func readExplainRequest(c *gin.Context) (explainInput, bool) {
+	if !authorize(c, scopeExplain) {
+		return explainInput{}, false
 	}
 	if !ensureJSONContentType(c) {
-		return
+		return explainInput{}, false
 	}
 
 	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBodyBytes())
 	body, err := io.ReadAll(c.Request.Body)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body", "reason_code": "INVALID_BODY"})
-		logReq(c, http.StatusBadRequest, 0, "", "")
-		return
+		annotateResult(c, "INVALID_BODY", 0)
+		return explainInput{}, false
 	}
 
 	var payload explainRequest
 	if err := json.Unmarshal(body, &payload); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body", "reason_code": "INVALID_BODY"})
-		logReq(c, http.StatusBadRequest, 0, "", "")
-		return
+		annotateResult(c, "INVALID_BODY", 0)
+		return explainInput{}, false
 	}
+	switch payload.Format {
+	case "", explainFormatText, explainFormatStructured:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid format", "reason_code": "INVALID_FORMAT"})
+		annotateResult(c, "INVALID_FORMAT", 0)
+		return explainInput{}, false
+	}
+	history, ok := resolveHistory(c, payload)
+	if !ok || !chargeQuota(c, scopeExplain) {
+		return explainInput{}, false
+	}
+	return explainInput{payload: payload, history: history}, true
+}
 
-	region := strings.TrimSpace(os.Getenv("VERTEX_REGION"))
-	if region == "" {
-		region = "us-central1"
+func explainHandler(c *gin.Context) {
+	requestID(c)
+	annotateOTS(c.Request, "schema_version", explainSchemaVersion)
+
+	in, ok := validateSpan(c, "explain.validate", func() (explainInput, bool) { return readExplainRequest(c) })
+	if !ok {
+		return
 	}
+	payload, history := in.payload, in.history
 
-	model := strings.TrimSpace(os.Getenv("VERTEX_MODEL"))
-	if model == "" {
-		model = "gemini-2.5-flash-lite"
+	targets := vertexTargets()
+	if len(history) == 0 && serveCachedExplain(c, payload, vertexHealth.order(targets)[0].Model) {
+		return
 	}
 
 	projectID, err := resolveProjectID(c.Request.Context())
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "server misconfigured", "reason_code": "MISCONFIGURED_PROJECT_ID"})
-		logReq(c, http.StatusInternalServerError, 0, "", "")
+		annotateResult(c, "MISCONFIGURED_PROJECT_ID", 0)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), explainTimeout())
+	defer cancel()
+
+	client, err := newVertexClient(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "vertex auth error", "reason_code": "VERTEX_AUTH_FAILURE"})
+		annotateResult(c, "VERTEX_AUTH_FAILURE", 0)
+		return
+	}
+
+	route := vertexRoute{client: client, projectID: projectID, targets: targets}
+	if payload.Format == explainFormatStructured {
+		explainStructured(ctx, c, route, payload)
+		return
+	}
+	if len(history) > 0 {
+		explainTrend(ctx, c, route, payload, history)
 		return
 	}
 
 	prompt := fmt.Sprintf("Summarize these metrics: score=%g, symmetry=%g, power=%g, consistency=%g. 1-2 sentences.", payload.Score, payload.Symmetry, payload.Power, payload.Consistency)
+	prompt += languageInstruction(payload.Language)
 
 	vertexPayload := map[string]any{
 		"contents": []map[string]any{
@@ -380,85 +453,126 @@ func explainHandler(c *gin.Context) {
 			},
 		},
 	}
-	reqBytes, err := json.Marshal(vertexPayload)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error", "reason_code": "VERTEX_REQUEST_MARSHAL_ERROR"})
-		logReq(c, http.StatusInternalServerError, 0, "", "")
+	answer, duration, ok := generateVertex(ctx, c, route, vertexPayload)
+	if !ok {
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
-	defer cancel()
+	writeExplainResult(c, gin.H{
+		"summary":  answer.Text,
+		"model":    answer.Target.Model,
+		"region":   answer.Target.Region,
+		"metadata": answer.metadata(),
+		"usage":    explainUsage(c),
+	}, duration, true)
+}
 
-	client, err := newVertexClient(ctx)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "vertex auth error", "reason_code": "VERTEX_AUTH_FAILURE"})
-		logReq(c, http.StatusInternalServerError, 0, "", "")
-		return
+func languageInstruction(lang string) string {
+	lang = strings.TrimSpace(lang)
+	if lang == "" {
+		return ""
 	}
+	return fmt.Sprintf(" Respond in language %q.", lang)
+}
+
+type vertexResult struct {
+	body       []byte
+	durationMs int64
+}
 
-	escapedProject := url.PathEscape(projectID)
-	escapedRegion := url.PathEscape(region)
-	escapedModel := url.PathEscape(model)
+// vertexCallError describes a failed generateContent call. Retryable errors
+// (429, 5xx, transport failures) let the caller move on to the next target.
+type vertexCallError struct {
+	status      int
+	reasonCode  string
+	message     string
+	body        []byte
+	contentType string
+	retryable   bool
+	durationMs  int64
+	extra       gin.H
+}
 
-	host := fmt.Sprintf("%s-aiplatform.googleapis.com", region)
-	if region == "global" {
-		host = "aiplatform.googleapis.com"
+func (e *vertexCallError) write(c *gin.Context) {
+	if e.body != nil {
+		c.Data(e.status, e.contentType, e.body)
+	} else {
+		resp := gin.H{"error": e.message, "reason_code": e.reasonCode}
+		for k, v := range e.extra {
+			resp[k] = v
+		}
+		c.JSON(e.status, resp)
 	}
-	vertexURL := fmt.Sprintf("https://%s/v1/projects/%s/locations/%s/publishers/google/models/%s:generateContent", host, escapedProject, escapedRegion, escapedModel)
+	annotateResult(c, e.reasonCode, e.durationMs)
+}
 
+// callVertex posts a generateContent request; non-2xx upstream bodies are
+// kept on the error so they can be relayed to the client.
+func callVertex(ctx context.Context, reqID string, client *http.Client, vertexURL string, reqBytes []byte) (vertexResult, *vertexCallError) {
 	vertexReq, err := http.NewRequestWithContext(ctx, http.MethodPost, vertexURL, bytes.NewReader(reqBytes))
 	if err != nil {
-		c.JSON(http.StatusBadGateway, gin.H{"error": "vertex upstream error", "reason_code": "VERTEX_REQUEST_BUILD_FAILURE"})
-		logReq(c, http.StatusBadGateway, 0, "", "")
-		return
+		return vertexResult{}, &vertexCallError{status: http.StatusBadGateway, reasonCode: "VERTEX_REQUEST_BUILD_FAILURE", message: "vertex upstream error"}
 	}
 	vertexReq.Header.Set("Content-Type", "application/json")
 	vertexReq.Header.Set("Accept", "application/json")
 	vertexReq.Header.Set("X-Request-Id", reqID)
+	injectTraceContext(ctx, vertexReq.Header)
 
 	start := time.Now()
-	vertexResp, err := client.Do(vertexReq)
-	duration := time.Since(start).Milliseconds()
-	if err != nil {
+	transportError := func(err error) *vertexCallError {
+		duration := time.Since(start).Milliseconds()
 		var nerr net.Error
-		if errors.As(err, &nerr) && nerr.Timeout() {
-			c.JSON(http.StatusGatewayTimeout, gin.H{"error": "vertex upstream timeout", "reason_code": "VERTEX_UPSTREAM_TIMEOUT"})
-			logReq(c, http.StatusGatewayTimeout, duration, "", "")
-			return
+		if errors.As(err, &nerr) && nerr.Timeout() || errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return &vertexCallError{status: http.StatusGatewayTimeout, reasonCode: "VERTEX_UPSTREAM_TIMEOUT", message: "vertex upstream timeout", retryable: true, durationMs: duration}
 		}
-		c.JSON(http.StatusBadGateway, gin.H{"error": "vertex upstream error", "reason_code": "VERTEX_UPSTREAM_FAILURE"})
-		logReq(c, http.StatusBadGateway, duration, "", "")
-		return
+		return &vertexCallError{status: http.StatusBadGateway, reasonCode: "VERTEX_UPSTREAM_FAILURE", message: "vertex upstream error", retryable: true, durationMs: duration}
+	}
+	vertexResp, err := client.Do(vertexReq)
+	if err != nil {
+		return vertexResult{}, transportError(err)
 	}
+	duration := time.Since(start).Milliseconds()
 	defer vertexResp.Body.Close()
 
 	respBody, err := io.ReadAll(vertexResp.Body)
 	if err != nil {
-		c.JSON(http.StatusBadGateway, gin.H{"error": "vertex upstream error", "reason_code": "VERTEX_UPSTREAM_FAILURE"})
-		logReq(c, http.StatusBadGateway, duration, "", "")
-		return
+		return vertexResult{}, transportError(err)
 	}
 
 	if vertexResp.StatusCode < 200 || vertexResp.StatusCode >= 300 {
-		c.Data(vertexResp.StatusCode, vertexResp.Header.Get("Content-Type"), respBody)
-		logReq(c, vertexResp.StatusCode, duration, "", "")
-		return
-	}
-
-	summary, err := extractVertexSummary(respBody)
-	if err != nil {
-		c.JSON(http.StatusBadGateway, gin.H{"error": "vertex upstream error", "reason_code": "VERTEX_INVALID_RESPONSE"})
-		logReq(c, http.StatusBadGateway, duration, "", "")
-		return
+		return vertexResult{}, &vertexCallError{
+			status:      vertexResp.StatusCode,
+			reasonCode:  "VERTEX_UPSTREAM_FAILURE",
+			body:        respBody,
+			contentType: vertexResp.Header.Get("Content-Type"),
+			retryable:   vertexResp.StatusCode == http.StatusTooManyRequests || vertexResp.StatusCode >= 500,
+			durationMs:  duration,
+		}
 	}
+	return vertexResult{body: respBody, durationMs: duration}, nil
+}
 
-	c.JSON(http.StatusOK, gin.H{
-		"summary": summary,
-		"model":   model,
-		"region":  region,
-	})
-	logReq(c, http.StatusOK, duration, "", "")
+// newServeMux routes health, ops and metrics outside the router. Only the
+// API and its explain aliases are traced and written to OTS; demo pages and
+// /v1/ping go straight to the router.
+func newServeMux(r, healthHandler http.Handler) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("/healthz", healthHandler)
+	mux.Handle("/healthz/", healthHandler)
+	mux.Handle("/livez", healthHandler)
+	mux.Handle("/livez/", healthHandler)
+	mux.Handle("/readyz", healthHandler)
+	mux.Handle("/readyz/", healthHandler)
+	mux.Handle("/ops", OpsHandler())
+	mux.Handle("/ops/", OpsHandler())
+	mux.Handle("/metrics", metricsHandler())
+	mux.Handle("/ops/slo", sloHandler())
+	traced := TraceMiddleware(OTSMiddleware(runID, r))
+	mux.Handle("/api/", traced)
+	mux.Handle("/explain", traced)
+	mux.Handle("/v1/explain", traced)
+	mux.Handle("/", r)
+	return mux
 }
 
 func main() {
@@ -485,17 +599,7 @@ func main() {
 	}
 	addr := ":" + port
 
-	mux := http.NewServeMux()
-	mux.Handle("/healthz", healthHandler)
-	mux.Handle("/healthz/", healthHandler)
-	mux.Handle("/livez", healthHandler)
-	mux.Handle("/livez/", healthHandler)
-	mux.Handle("/readyz", healthHandler)
-	mux.Handle("/readyz/", healthHandler)
-	mux.Handle("/ops", OpsHandler())
-	mux.Handle("/ops/", OpsHandler())
-	mux.Handle("/api/", OTSMiddleware(runID, r))
-	mux.Handle("/", r)
+	mux := newServeMux(r, healthHandler)
 
 	srv := &http.Server{
 		Addr:              addr,
@@ -506,12 +610,41 @@ func main() {
 		IdleTimeout:       120 * time.Second,
 		MaxHeaderBytes:    1 << 20,
 	}
+	srv.RegisterOnShutdown(opsFeed.close)
 
 	shutdownCh := make(chan os.Signal, 1)
 	signal.Notify(shutdownCh, syscall.SIGINT, syscall.SIGTERM)
 	defer signal.Stop(shutdownCh)
 
+	stopBackground := make(chan struct{})
+	defer close(stopBackground)
+	keyHupCh := make(chan os.Signal, 1)
+	signal.Notify(keyHupCh, syscall.SIGHUP)
+	defer signal.Stop(keyHupCh)
+	startKeyReloader(keyHupCh, stopBackground)
+	otsOutput() // open OTS sinks now so a bad one is logged at startup
+	startSLOEvaluator()
+	flushQuota := startQuotaPersistence(stopBackground)
+
+	tlsSet, useTLS, err := currentTLSSettings()
+	if err != nil {
+		log.Fatalf("tls: %v", err)
+	}
+	if useTLS {
+		certs, err := newTLSReloader(tlsSet)
+		if err != nil {
+			log.Fatalf("tls: %v", err)
+		}
+		srv.TLSConfig = certs.config()
+		tlsHupCh := make(chan os.Signal, 1)
+		signal.Notify(tlsHupCh, syscall.SIGHUP)
+		defer signal.Stop(tlsHupCh)
+		certs.start(tlsHupCh, stopBackground)
+	}
+
+	shutdownDone := make(chan struct{})
 	go func() {
+		defer close(shutdownDone)
 		sig := <-shutdownCh
 		log.Printf("server: received %s, initiating shutdown", sig)
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -519,10 +652,19 @@ func main() {
 		if err := srv.Shutdown(ctx); err != nil {
 			log.Printf("server shutdown error: %v", err)
 		}
+		flushQuota()
+		flushTraces()
+		flushOTS()
+		flushArchive()
 	}()
 
-	log.Printf("server ready on %s; run_id=%s", addr, runID)
-	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+	log.Printf("server ready on %s; run_id=%s tls=%t", addr, runID, useTLS)
+	serve := srv.ListenAndServe
+	if useTLS {
+		serve = func() error { return srv.ListenAndServeTLS("", "") }
+	}
+	if err := serve(); err != nil && err != http.ErrServerClosed {
 		log.Fatalf("listen: %v", err)
 	}
+	<-shutdownDone
 }
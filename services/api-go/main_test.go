@@ -45,7 +45,15 @@ func setupExplainTest(t *testing.T) {
 	t.Helper()
 
 	prevClient := newVertexClient
-	t.Cleanup(func() { newVertexClient = prevClient })
+	prevCache := explainCache
+	prevHealth := vertexHealth
+	explainCache = newResultCache()
+	vertexHealth = newTargetHealth()
+	t.Cleanup(func() {
+		newVertexClient = prevClient
+		explainCache = prevCache
+		vertexHealth = prevHealth
+	})
 
 	newVertexClient = func(ctx context.Context) (*http.Client, error) {
 		return &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
@@ -250,19 +258,24 @@ func TestExplainHandler_OPTIONS(t *testing.T) {
 	setupExplainTest(t)
 
 	r := newRouter()
+	for _, path := range []string{"/api/v1/explain", "/api/v1/score", "/api/v1/usage", "/v1/explain"} {
+		req := httptest.NewRequest(http.MethodOptions, path, nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != http.StatusNoContent {
+			t.Fatalf("%s: want 204, got %d; body=%s", path, w.Code, w.Body.String())
+		}
+		if allow := w.Header().Get("Allow"); !strings.Contains(allow, "OPTIONS") {
+			t.Fatalf("%s: unexpected Allow header: %q", path, allow)
+		}
+	}
 	req := httptest.NewRequest(http.MethodOptions, "/api/v1/explain", nil)
 	w := httptest.NewRecorder()
 	r.ServeHTTP(w, req)
-
-	if w.Code != http.StatusOK {
-		t.Fatalf("want 200, got %d; body=%s", w.Code, w.Body.String())
-	}
-	if allow := w.Header().Get("Allow"); !strings.Contains(allow, "OPTIONS") || !strings.Contains(allow, "POST") {
+	if allow := w.Header().Get("Allow"); allow != "OPTIONS, POST" {
 		t.Fatalf("unexpected Allow header: %q", allow)
 	}
-	if methods := w.Header().Get("Access-Control-Allow-Methods"); methods == "" {
-		t.Fatalf("missing Access-Control-Allow-Methods header")
-	}
 }
 
 func TestExplainHandler_AliasPaths(t *testing.T) {
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// latencyBuckets are upper bounds in seconds, chosen around the 500ms p95
+// target in ops/lachesis/slo.yaml.
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// counterVec is a Prometheus counter family keyed by label values.
+type counterVec struct {
+	name, help string
+	labels     []string
+
+	mu     sync.Mutex
+	series map[string]*counterSeries
+}
+
+type counterSeries struct {
+	labels []string
+	value  float64
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	return &counterVec{name: name, help: help, labels: labels, series: map[string]*counterSeries{}}
+}
+
+func (v *counterVec) inc(labels ...string) {
+	key := strings.Join(labels, "\xff")
+	v.mu.Lock()
+	s, ok := v.series[key]
+	if !ok {
+		s = &counterSeries{labels: labels}
+		v.series[key] = s
+	}
+	s.value++
+	v.mu.Unlock()
+}
+
+// histogramVec is a Prometheus histogram family keyed by label values.
+type histogramVec struct {
+	name, help string
+	labels     []string
+	buckets    []float64
+
+	mu     sync.Mutex
+	series map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	labels []string
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	return &histogramVec{name: name, help: help, labels: labels, buckets: buckets, series: map[string]*histogramSeries{}}
+}
+
+func (v *histogramVec) observe(seconds float64, labels ...string) {
+	key := strings.Join(labels, "\xff")
+	v.mu.Lock()
+	s, ok := v.series[key]
+	if !ok {
+		s = &histogramSeries{labels: labels, counts: make([]uint64, len(v.buckets))}
+		v.series[key] = s
+	}
+	for i, b := range v.buckets {
+		if seconds <= b {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.sum += seconds
+	v.mu.Unlock()
+}
+
+type gatewayMetrics struct {
+	requests        *counterVec
+	requestDuration *histogramVec
+	mlDuration      *histogramVec
+	vertexDuration  *histogramVec
+	otsDropped      *counterVec
+	otsErrors       *counterVec
+	archiveDropped  *counterVec
+	inFlight        atomic.Int64
+}
+
+func newGatewayMetrics() *gatewayMetrics {
+	return &gatewayMetrics{
+		requests: newCounterVec("picca_http_requests_total",
+			"HTTP requests by route, method, status and reason_code.", "route", "method", "status", "reason_code"),
+		requestDuration: newHistogramVec("picca_http_request_duration_seconds",
+			"Inbound request latency by route.", latencyBuckets, "route", "method"),
+		mlDuration: newHistogramVec("picca_ml_upstream_duration_seconds",
+			"ML /predict call latency by outcome.", latencyBuckets, "outcome"),
+		vertexDuration: newHistogramVec("picca_vertex_request_duration_seconds",
+			"Vertex generateContent call latency by region, model and outcome.", latencyBuckets, "region", "model", "outcome"),
+		otsDropped: newCounterVec("picca_ots_dropped_total",
+			"OTS lines dropped because a sink's queue was full.", "sink"),
+		otsErrors: newCounterVec("picca_ots_write_errors_total",
+			"OTS sink write or flush failures.", "sink"),
+		archiveDropped: newCounterVec("picca_archive_dropped_total",
+			"Sampled request archives not written because the queue was full or the write failed."),
+	}
+}
+
+var gwMetrics = newGatewayMetrics()
+
+// upstreamOutcome buckets an upstream HTTP status into ok, client_error or
+// server_error. Calls that got no response use "timeout" or "transport".
+func upstreamOutcome(status int) string {
+	switch {
+	case status >= 500:
+		return "server_error"
+	case status >= 400:
+		return "client_error"
+	}
+	return "ok"
+}
+
+// reasonCodeFromBody extracts reason_code from a JSON error body.
+func reasonCodeFromBody(b []byte) string {
+	var body struct {
+		ReasonCode string `json:"reason_code"`
+	}
+	_ = json.Unmarshal(b, &body)
+	return body.ReasonCode
+}
+
+// metricsWriter keeps the start of error bodies so the reason_code label can
+// be read back.
+type metricsWriter struct {
+	gin.ResponseWriter
+	errBuf bytes.Buffer
+}
+
+func (w *metricsWriter) Write(b []byte) (int, error) {
+	if w.Status() >= 400 && w.errBuf.Len() < 4096 {
+		w.errBuf.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *metricsWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// metricsMiddleware counts and times every request under its route template,
+// so path parameters do not create new series.
+func metricsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		gwMetrics.inFlight.Add(1)
+		defer gwMetrics.inFlight.Add(-1)
+		mw := &metricsWriter{ResponseWriter: c.Writer}
+		c.Writer = mw
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = sloUnmatchedRoute
+		}
+		status := c.Writer.Status()
+		reason := ""
+		if status >= 400 {
+			reason = reasonCodeFromBody(mw.errBuf.Bytes())
+		}
+		elapsed := time.Since(start)
+		gwMetrics.requests.inc(route, c.Request.Method, strconv.Itoa(status), reason)
+		gwMetrics.requestDuration.observe(elapsed.Seconds(), route, c.Request.Method)
+		if c.Request.Method != http.MethodOptions {
+			// CORS preflights are answered by the middleware and say nothing
+			// about the route's latency or success.
+			sloStats.observe(route, status, elapsed)
+		}
+	}
+}
+
+func escapeLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+func labelPairs(names, values []string, extra ...string) string {
+	var parts []string
+	for i, n := range names {
+		parts = append(parts, n+`="`+escapeLabel(values[i])+`"`)
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		parts = append(parts, extra[i]+`="`+escapeLabel(extra[i+1])+`"`)
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func (v *counterVec) write(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", v.name, v.help, v.name)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	keys := make([]string, 0, len(v.series))
+	for k := range v.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		s := v.series[k]
+		fmt.Fprintf(w, "%s%s %s\n", v.name, labelPairs(v.labels, s.labels), formatFloat(s.value))
+	}
+}
+
+func (v *histogramVec) write(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", v.name, v.help, v.name)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	keys := make([]string, 0, len(v.series))
+	for k := range v.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		s := v.series[k]
+		for i, b := range v.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", v.name, labelPairs(v.labels, s.labels, "le", formatFloat(b)), s.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", v.name, labelPairs(v.labels, s.labels, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", v.name, labelPairs(v.labels, s.labels), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", v.name, labelPairs(v.labels, s.labels), s.count)
+	}
+}
+
+func (m *gatewayMetrics) write(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP picca_build_info Build and run identity of the gateway.\n# TYPE picca_build_info gauge\n")
+	fmt.Fprintf(w, "picca_build_info%s 1\n", labelPairs([]string{"run_id", "go_version"}, []string{runID, runtime.Version()}))
+	fmt.Fprintf(w, "# HELP picca_http_in_flight_requests Requests currently being served.\n# TYPE picca_http_in_flight_requests gauge\n")
+	fmt.Fprintf(w, "picca_http_in_flight_requests %d\n", m.inFlight.Load())
+	m.requests.write(w)
+	m.requestDuration.write(w)
+	m.mlDuration.write(w)
+	m.vertexDuration.write(w)
+	m.otsDropped.write(w)
+	m.otsErrors.write(w)
+	m.archiveDropped.write(w)
+}
+
+// metricsHandler serves the Prometheus text format. When METRICS_TOKEN is
+// set, scrapers must send it as a bearer token.
+func metricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token := os.Getenv("METRICS_TOKEN"); token != "" {
+			got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		bw := bufio.NewWriter(w)
+		gwMetrics.write(bw)
+		_ = bw.Flush()
+	})
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetrics_CountsRoutesAndUpstreams(t *testing.T) {
+	setupExplainTest(t)
+	prev := gwMetrics
+	gwMetrics = newGatewayMetrics()
+	t.Cleanup(func() { gwMetrics = prev })
+
+	ml := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"score":70,"symmetry":0.7,"power":0.7,"consistency":0.7}`))
+	}))
+	defer ml.Close()
+	t.Setenv("API_ML_URL", ml.URL)
+
+	r := newRouter()
+	for _, key := range []string{"secret", "wrong"} {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/score", bytes.NewBufferString(`{}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-API-Key", key)
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/v1/explain:compare", nil))
+
+	t.Setenv("METRICS_TOKEN", "scrape")
+	w := httptest.NewRecorder()
+	metricsHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("want 401 without token, got %d", w.Code)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Authorization", "Bearer scrape")
+	w = httptest.NewRecorder()
+	metricsHandler().ServeHTTP(w, req)
+	out := w.Body.String()
+
+	for _, want := range []string{
+		`picca_http_requests_total{route="/api/v1/score",method="POST",status="200",reason_code=""} 1`,
+		`picca_http_requests_total{route="/api/v1/score",method="POST",status="401",reason_code="INVALID_API_KEY"} 1`,
+		`picca_http_requests_total{route="/api/v1/:action",method="POST",status="401",reason_code="INVALID_API_KEY"} 1`,
+		`picca_http_request_duration_seconds_count{route="/api/v1/score",method="POST"} 2`,
+		`picca_ml_upstream_duration_seconds_bucket{outcome="ok",le="+Inf"} 1`,
+		`picca_http_in_flight_requests 0`,
+		`picca_build_info{run_id="` + runID + `"`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in:\n%s", want, out)
+		}
+	}
+}
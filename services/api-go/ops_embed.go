@@ -1,17 +1,209 @@
 package main
 
 import (
-	_ "embed"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"embed"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net"
 	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 )
 
-//go:embed ops.html
-var opsHTML []byte
+//go:embed opsui
+var opsUI embed.FS
 
-// OpsHandler serves the embedded ops.html over net/http.
+var startedAt = time.Now().UTC()
+
+// configFingerprintPrefixes select the settings that shape gateway behaviour.
+// For secrets only the name and whether it is set count; values are never
+// hashed or shown.
+var (
+	configFingerprintPrefixes = []string{"API_", "ADMIN_API_KEY", "VERTEX_", "EXPLAIN", "RATE_LIMIT_", "QUOTA_", "CORS_", "JWT_", "TLS_", "TRACE_", "SESSION_", "KEY_", "SIGNATURE_", "SLO_", "OTS_", "ARCHIVE_", "OPS_", "METRICS_TOKEN", "MAX_BODY_BYTES", "PORT", "PROJECT_ID"}
+	configSecretNames         = map[string]bool{"API_KEY": true, "ADMIN_API_KEY": true, "API_KEYS": true, "VERTEX_TOKEN": true, "METRICS_TOKEN": true, "OPS_TOKEN": true}
+)
+
+// configFingerprint hashes the relevant environment so two instances can be
+// compared at a glance. It returns the names that went into the hash.
+func configFingerprint() (string, []string) {
+	var names []string
+	for _, kv := range os.Environ() {
+		name, _, _ := strings.Cut(kv, "=")
+		for _, p := range configFingerprintPrefixes {
+			if strings.HasPrefix(name, p) {
+				names = append(names, name)
+				break
+			}
+		}
+	}
+	sort.Strings(names)
+	h := sha256.New()
+	var shown []string
+	for _, n := range names {
+		if configSecretNames[n] {
+			fmt.Fprintf(h, "%s set=%t\n", n, os.Getenv(n) != "")
+			continue
+		}
+		fmt.Fprintf(h, "%s=%s\n", n, os.Getenv(n))
+		shown = append(shown, n)
+	}
+	return hex.EncodeToString(h.Sum(nil))[:12], shown
+}
+
+// backendProbe caches the ML service's /healthz result for a few seconds so
+// dashboards do not multiply probe traffic.
+type backendProbe struct {
+	mu      sync.Mutex
+	checked time.Time
+	result  map[string]any
+}
+
+var mlProbe backendProbe
+
+func (p *backendProbe) status(ctx context.Context) map[string]any {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if time.Since(p.checked) < 5*time.Second && p.result != nil {
+		return p.result
+	}
+	mlURL := strings.TrimRight(os.Getenv("API_ML_URL"), "/")
+	res := map[string]any{"configured": mlURL != ""}
+	if mlURL != "" {
+		ctx, cancel := context.WithTimeout(ctx, time.Second)
+		defer cancel()
+		start := time.Now()
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, mlURL+"/healthz", nil)
+		resp, err := httpClient.Do(req)
+		res["latency_ms"] = time.Since(start).Milliseconds()
+		if err != nil {
+			res["ok"], res["error"] = false, err.Error()
+		} else {
+			resp.Body.Close()
+			res["ok"], res["status"] = resp.StatusCode == http.StatusOK, resp.StatusCode
+		}
+	}
+	p.checked, p.result = time.Now(), res
+	return res
+}
+
+func opsStatus(ctx context.Context) map[string]any {
+	fp, keys := configFingerprint()
+	return map[string]any{
+		"run_id":             runID,
+		"started_at":         startedAt.Format(time.RFC3339),
+		"uptime_s":           int64(time.Since(startedAt).Seconds()),
+		"config_fingerprint": fp,
+		"config_keys":        keys,
+		"routes":             sloStats.rolling(),
+		"vertex_targets":     vertexHealth.snapshot(vertexTargets()),
+		"ml_backend":         mlProbe.status(ctx),
+		"in_flight":          gwMetrics.inFlight.Load(),
+	}
+}
+
+// opsAuthorized checks OPS_TOKEN as a bearer token or a "token" query
+// parameter (EventSource cannot send headers). Without OPS_TOKEN only
+// loopback clients are allowed.
+func opsAuthorized(r *http.Request) bool {
+	token := os.Getenv("OPS_TOKEN")
+	if token == "" {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		ip := net.ParseIP(host)
+		return err == nil && ip != nil && ip.IsLoopback()
+	}
+	got := r.URL.Query().Get("token")
+	if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		got = strings.TrimPrefix(h, "Bearer ")
+	}
+	return subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
+// opsEvents streams server-sent events: the recent OTS backlog and then live
+// "ots" records, plus a "status" snapshot every OPS_STATUS_INTERVAL (5s),
+// until the client goes away or the server shuts down.
+func opsEvents(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+	// The stream outlives the server's WriteTimeout.
+	_ = rc.SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Accel-Buffering", "no")
+
+	interval := 5 * time.Second
+	if v := os.Getenv("OPS_STATUS_INTERVAL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			interval = d
+		}
+	}
+	sendStatus := func() {
+		if b, err := json.Marshal(opsStatus(r.Context())); err == nil {
+			fmt.Fprintf(w, "event: status\ndata: %s\n\n", b)
+		}
+	}
+
+	feed := opsFeed
+	backlog, ch := feed.subscribe()
+	defer feed.unsubscribe(ch)
+	for _, line := range backlog {
+		fmt.Fprintf(w, "event: ots\ndata: %s\n\n", line)
+	}
+	sendStatus()
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-feed.done():
+			return
+		case line := <-ch:
+			fmt.Fprintf(w, "event: ots\ndata: %s\n\n", line)
+		case <-ticker.C:
+			sendStatus()
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// OpsHandler serves the embedded dashboard under /ops with its JSON status
+// (/ops/status) and live stream (/ops/events).
 func OpsHandler() http.Handler {
+	sub, _ := fs.Sub(opsUI, "opsui")
+	assets := http.StripPrefix("/ops/", http.FileServer(http.FS(sub)))
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "text/html; charset=utf-8")
-		_, _ = w.Write(opsHTML)
+		switch r.URL.Path {
+		case "/ops", "/ops/":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			page, _ := fs.ReadFile(sub, "index.html")
+			_, _ = w.Write(page)
+		case "/ops/status", "/ops/events":
+			if !opsAuthorized(r) {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			if r.URL.Path == "/ops/events" {
+				opsEvents(w, r)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.Header().Set("Cache-Control", "no-store")
+			_ = json.NewEncoder(w).Encode(opsStatus(r.Context()))
+		default:
+			assets.ServeHTTP(w, r)
+		}
 	})
 }
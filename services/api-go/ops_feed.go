@@ -0,0 +1,66 @@
+package main
+
+import (
+	"sync"
+)
+
+// feedHub fans OTS lines out to dashboard subscribers and keeps the most
+// recent ones for new connections. Slow subscribers miss lines rather than
+// holding up requests.
+type feedHub struct {
+	mu      sync.Mutex
+	backlog [][]byte
+	size    int
+	subs    map[chan []byte]struct{}
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+var opsFeed = newFeedHub(200)
+
+func newFeedHub(size int) *feedHub {
+	return &feedHub{size: size, subs: map[chan []byte]struct{}{}, closed: make(chan struct{})}
+}
+
+func (h *feedHub) publish(line []byte) {
+	line = append([]byte(nil), line...)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.backlog) == h.size {
+		h.backlog = append(h.backlog[:0], h.backlog[1:]...)
+	}
+	h.backlog = append(h.backlog, line)
+	for ch := range h.subs {
+		select {
+		case ch <- line:
+		default:
+		}
+	}
+}
+
+// subscribe returns the current backlog and a channel of later lines.
+func (h *feedHub) subscribe() ([][]byte, chan []byte) {
+	ch := make(chan []byte, 64)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.subs[ch] = struct{}{}
+	return append([][]byte(nil), h.backlog...), ch
+}
+
+func (h *feedHub) unsubscribe(ch chan []byte) {
+	h.mu.Lock()
+	delete(h.subs, ch)
+	h.mu.Unlock()
+}
+
+// close ends every subscription. main registers it with RegisterOnShutdown so
+// open dashboards do not hold up a graceful shutdown.
+func (h *feedHub) close() {
+	h.closeOnce.Do(func() { close(h.closed) })
+}
+
+// done is closed once the hub is.
+func (h *feedHub) done() <-chan struct{} {
+	return h.closed
+}
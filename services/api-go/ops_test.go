@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOpsStatus_Fields(t *testing.T) {
+	t.Setenv("API_ML_URL", "")
+	t.Setenv("OPS_TOKEN", "")
+	req := httptest.NewRequest(http.MethodGet, "/ops/status", nil)
+	w := httptest.NewRecorder()
+	OpsHandler().ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("remote client without OPS_TOKEN: %d", w.Code)
+	}
+
+	req.RemoteAddr = "127.0.0.1:40000"
+	w = httptest.NewRecorder()
+	OpsHandler().ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status: %d", w.Code)
+	}
+	var got map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []string{"run_id", "started_at", "uptime_s", "config_fingerprint", "config_keys", "routes", "vertex_targets", "ml_backend", "in_flight"} {
+		if _, ok := got[k]; !ok {
+			t.Errorf("missing %q in %s", k, w.Body.String())
+		}
+	}
+}
+
+func TestOpsHandler_TokenAndAssets(t *testing.T) {
+	t.Setenv("OPS_TOKEN", "ops-secret")
+	h := OpsHandler()
+	get := func(target, auth string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		if auth != "" {
+			req.Header.Set("Authorization", "Bearer "+auth)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+	if w := get("/ops/status", ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("no token: %d", w.Code)
+	}
+	if w := get("/ops/status", "wrong"); w.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong token: %d", w.Code)
+	}
+	if w := get("/ops/status", "ops-secret"); w.Code != http.StatusOK {
+		t.Fatalf("bearer token: %d", w.Code)
+	}
+	if w := get("/ops/status?token=ops-secret", ""); w.Code != http.StatusOK {
+		t.Fatalf("query token: %d", w.Code)
+	}
+	if w := get("/ops/", ""); w.Code != http.StatusOK || strings.Contains(w.Body.String(), "https://") {
+		t.Fatalf("page: %d, must not load external resources", w.Code)
+	}
+	for _, asset := range []string{"/ops/ops.js", "/ops/ops.css"} {
+		if w := get(asset, ""); w.Code != http.StatusOK {
+			t.Fatalf("%s: %d", asset, w.Code)
+		}
+	}
+}
+
+func TestOpsEvents_BacklogAndStatus(t *testing.T) {
+	t.Setenv("OPS_TOKEN", "")
+	t.Setenv("API_ML_URL", "")
+	prev := opsFeed
+	opsFeed = newFeedHub(2)
+	t.Cleanup(func() { opsFeed = prev })
+	for _, l := range []string{`{"n":1}`, `{"n":2}`, `{"n":3}`} {
+		opsFeed.publish([]byte(l))
+	}
+
+	srv := httptest.NewServer(OpsHandler())
+	defer srv.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/ops/events", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("content type %q", ct)
+	}
+
+	var events []string
+	sc := bufio.NewScanner(resp.Body)
+	for sc.Scan() && len(events) < 3 {
+		if d, ok := strings.CutPrefix(sc.Text(), "data: "); ok {
+			events = append(events, d)
+		}
+	}
+	if len(events) != 3 || events[0] != `{"n":2}` || events[1] != `{"n":3}` || !strings.Contains(events[2], `"config_fingerprint"`) {
+		t.Fatalf("events: %q", events)
+	}
+}
+
+func TestConfigFingerprint_HidesSecrets(t *testing.T) {
+	t.Setenv("VERTEX_TOKEN", "a")
+	t.Setenv("VERTEX_MODEL", "gemini-test")
+	fp1, keys := configFingerprint()
+	for _, k := range keys {
+		if k == "VERTEX_TOKEN" {
+			t.Fatalf("secret name listed: %v", keys)
+		}
+	}
+	t.Setenv("VERTEX_TOKEN", "b")
+	if fp2, _ := configFingerprint(); fp1 != fp2 || len(fp1) != 12 {
+		t.Fatalf("fingerprint %q / %q must not depend on the secret value", fp1, fp2)
+	}
+	t.Setenv("VERTEX_TOKEN", "")
+	if fp3, _ := configFingerprint(); fp1 == fp3 {
+		t.Fatalf("fingerprint should change when a secret is unset")
+	}
+	t.Setenv("VERTEX_MODEL", "gemini-other")
+	if fp4, _ := configFingerprint(); fp4 == fp1 {
+		t.Fatalf("fingerprint should change with a setting value")
+	}
+	for _, name := range []string{"OTS_SINKS", "ARCHIVE_DIR", "EXPLAINER", "OPS_STATUS_INTERVAL"} {
+		before, _ := configFingerprint()
+		t.Setenv(name, "changed")
+		if after, _ := configFingerprint(); after == before {
+			t.Fatalf("fingerprint should change with %s", name)
+		}
+	}
+}
+
+func TestOpsEvents_EndOnShutdown(t *testing.T) {
+	t.Setenv("OPS_TOKEN", "")
+	t.Setenv("API_ML_URL", "")
+	prev := opsFeed
+	opsFeed = newFeedHub(2)
+	t.Cleanup(func() { opsFeed = prev })
+
+	srv := httptest.NewUnstartedServer(OpsHandler())
+	srv.Config.RegisterOnShutdown(opsFeed.close)
+	srv.Start()
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/ops/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	sc := bufio.NewScanner(resp.Body)
+	for sc.Scan() {
+		if strings.HasPrefix(sc.Text(), "event: status") {
+			break
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := srv.Config.Shutdown(ctx); err != nil {
+		t.Fatalf("open event stream held up shutdown: %v", err)
+	}
+}
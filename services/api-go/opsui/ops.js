@@ -0,0 +1,71 @@
+(() => {
+  const $ = sel => document.querySelector(sel);
+  const token = new URLSearchParams(location.search).get('token');
+  const q = token ? `?token=${encodeURIComponent(token)}` : '';
+  const MAX_ROWS = 50;
+  let slo = null;
+
+  const cell = (text, cls) => {
+    const td = document.createElement('td');
+    td.textContent = text;
+    if (cls) td.className = cls;
+    return td;
+  };
+  const row = cells => {
+    const tr = document.createElement('tr');
+    cells.forEach(c => tr.appendChild(c));
+    return tr;
+  };
+  const pct = v => v == null ? '–' : `${(v * 100).toFixed(2)}%`;
+  const num = v => v == null ? '–' : String(v);
+
+  function renderStatus(s) {
+    $('#rid').textContent = s.run_id;
+    $('#fp').textContent = s.config_fingerprint;
+    $('#fp').title = (s.config_keys || []).join('\n');
+    $('#uptime').textContent = `${Math.floor(s.uptime_s / 60)}m`;
+    $('#inflight').textContent = s.in_flight;
+
+    const routes = $('#routes');
+    routes.replaceChildren();
+    (s.routes || []).forEach(r => {
+      $('#window').textContent = `(rolling ${r.window})`;
+      const p95cls = slo && r.p95_ms != null ? (r.p95_ms <= slo.p95_ms ? 'ok' : 'ng') : '';
+      const succls = slo && r.success != null ? (r.success >= slo.success ? 'ok' : 'ng') : '';
+      routes.appendChild(row([cell(r.route === '*' ? '全体' : r.route), cell(num(r.requests)), cell(num(r.p95_ms), p95cls), cell(pct(r.success), succls)]));
+    });
+
+    const backends = $('#backends');
+    backends.replaceChildren();
+    const ml = s.ml_backend || {};
+    const mlState = !ml.configured ? ['未設定', 'warn'] : ml.ok ? ['OK', 'ok'] : ['NG', 'ng'];
+    backends.appendChild(row([cell('ML /healthz'), cell(mlState[0], mlState[1]), cell(ml.error || (ml.status ? `HTTP ${ml.status} · ${ml.latency_ms} ms` : ''))]));
+    (s.vertex_targets || []).forEach(t => {
+      const state = t.cooling_down ? ['cooldown', 'ng'] : t.consecutive_failures > 0 ? ['degraded', 'warn'] : ['OK', 'ok'];
+      backends.appendChild(row([cell(`Vertex ${t.target}`), cell(state[0], state[1]), cell(`failures ${t.consecutive_failures} / total ${t.total_failures}`)]));
+    });
+  }
+
+  function addOTS(rec) {
+    const cls = rec.status >= 500 ? 'ng' : rec.status >= 400 ? 'warn' : 'ok';
+    const tr = row([cell(rec.ts), cell(rec.path), cell(num(rec.status), cls), cell(num(rec.latency_ms)), cell(rec.req_id)]);
+    const body = $('#ots');
+    body.prepend(tr);
+    while (body.children.length > MAX_ROWS) body.lastChild.remove();
+  }
+
+  fetch(`/ops/slo${q}`, {cache: 'no-store'})
+    .then(r => r.ok ? r.json() : null)
+    .then(j => {
+      if (!j) return;
+      slo = j.objectives;
+      $('#slo').textContent = `SLO: p95 ≤ ${slo.p95_ms} ms (${slo.latency_window}) · success ≥ ${pct(slo.success)} (${slo.success_window})`;
+    })
+    .catch(() => {});
+
+  const es = new EventSource(`/ops/events${q}`);
+  es.onopen = () => { $('#conn').textContent = 'LIVE'; $('#conn').className = 'tag ok'; };
+  es.onerror = () => { $('#conn').textContent = '再接続中…'; $('#conn').className = 'tag ng'; };
+  es.addEventListener('status', e => renderStatus(JSON.parse(e.data)));
+  es.addEventListener('ots', e => { try { addOTS(JSON.parse(e.data)); } catch (_) {} });
+})();
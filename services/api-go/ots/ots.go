@@ -0,0 +1,119 @@
+// Package ots defines the Observation Trace Sheet record written by the
+// gateway and the ML service: one JSON object per line whose leading keys
+// follow the fixed order in kagami/signals.md.
+//
+//	{"ts":…,"run_id":…,"path":…,"status":…,"latency_ms":…,"req_id":…,
+//	 "input_hash":…,"output_hash":…,"schema":"ots/1", <enrichment>…}
+//
+// Records marshal in that order; Validate checks a line against it.
+package ots
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// Schema is the version written to the schema key. Bump it when a fixed key
+// changes meaning or position.
+const Schema = "ots/1"
+
+// FixedKeys are the keys every record starts with, in order.
+var FixedKeys = []string{"ts", "run_id", "path", "status", "latency_ms", "req_id", "input_hash", "output_hash", "schema"}
+
+// EnrichedKeys are optional gateway keys that follow the fixed ones in this
+// order when present; any other extras come after them sorted by name.
+var EnrichedKeys = []string{"client_req_id", "trace_id", "key_name", "reason_code", "upstream_ms", "schema_version"}
+
+// TimeFormat is the ts layout: RFC 3339 in UTC with up to nine fractional
+// digits, as produced by time.RFC3339Nano.
+const TimeFormat = "2006-01-02T15:04:05.999999999Z07:00"
+
+// MaxCaptureBytes is the largest body that gets hashed.
+const MaxCaptureBytes = 1 << 20
+
+// BodyHash is the input_hash/output_hash of a body: the first 16 hex
+// characters of its SHA-256.
+func BodyHash(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+// Record is one OTS line. Hashes are blank when a body is unavailable or
+// larger than the capture limit.
+type Record struct {
+	TS         string
+	RunID      string
+	Path       string
+	Status     int
+	LatencyMs  float64
+	ReqID      string
+	InputHash  string
+	OutputHash string
+	Schema     string
+
+	// Extra holds enrichment keys; entries that collide with a fixed key
+	// are ignored.
+	Extra map[string]any
+}
+
+// MarshalJSON encodes r with the fixed keys first, then EnrichedKeys, then the
+// remaining extras by name.
+func (r Record) MarshalJSON() ([]byte, error) {
+	schema := r.Schema
+	if schema == "" {
+		schema = Schema
+	}
+	fixed := []any{r.TS, r.RunID, r.Path, r.Status, r.LatencyMs, r.ReqID, r.InputHash, r.OutputHash, schema}
+
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	write := func(k string, v any) error {
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		kb, _ := json.Marshal(k)
+		vb, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Errorf("ots: %s: %w", k, err)
+		}
+		buf.Write(kb)
+		buf.WriteByte(':')
+		buf.Write(vb)
+		return nil
+	}
+	for i, k := range FixedKeys {
+		if err := write(k, fixed[i]); err != nil {
+			return nil, err
+		}
+	}
+	done := map[string]bool{}
+	for _, k := range FixedKeys {
+		done[k] = true
+	}
+	for _, k := range EnrichedKeys {
+		if v, ok := r.Extra[k]; ok {
+			if err := write(k, v); err != nil {
+				return nil, err
+			}
+			done[k] = true
+		}
+	}
+	var rest []string
+	for k := range r.Extra {
+		if !done[k] {
+			rest = append(rest, k)
+		}
+	}
+	sort.Strings(rest)
+	for _, k := range rest {
+		if err := write(k, r.Extra[k]); err != nil {
+			return nil, err
+		}
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
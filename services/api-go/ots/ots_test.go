@@ -0,0 +1,59 @@
+package ots
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestRecord_MarshalKeyOrder(t *testing.T) {
+	r := Record{
+		TS: "2026-03-01T00:00:00.5Z", RunID: "dev·na", Path: "/p", Status: 400, LatencyMs: 1.5, ReqID: "r",
+		Extra: map[string]any{"usage": 3, "reason_code": "X", "key_name": "k", "cached": true, "status": 999},
+	}
+	line, err := json.Marshal(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"ts":"2026-03-01T00:00:00.5Z","run_id":"dev·na","path":"/p","status":400,"latency_ms":1.5,"req_id":"r","input_hash":"","output_hash":"","schema":"ots/1","key_name":"k","reason_code":"X","cached":true,"usage":3}`
+	if string(line) != want {
+		t.Fatalf("got  %s\nwant %s", line, want)
+	}
+	if errs := Validate(line); errs != nil {
+		t.Fatalf("own output invalid: %v", errs)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	const good = `{"ts":"2026-03-01T12:00:00Z","run_id":"9ac1beef·na","path":"/predict","status":200,"latency_ms":3.2,"req_id":"","input_hash":"0123456789abcdef","output_hash":"","schema":"ots/1"}`
+	if errs := Validate([]byte(good)); errs != nil {
+		t.Fatalf("python-style line rejected: %v", errs)
+	}
+
+	cases := map[string]struct {
+		line string
+		want string
+	}{
+		"not json":       {`ts=1`, "not a JSON object"},
+		"order":          {`{"run_id":"a·b","ts":"2026-03-01T12:00:00Z"}`, `key 1: want "ts", got "run_id"`},
+		"missing schema": {strings.Replace(good, `,"schema":"ots/1"`, "", 1), `key 9: want "schema", got end of object`},
+		"old schema":     {strings.Replace(good, `"ots/1"`, `"ots/0"`, 1), "schema: unsupported"},
+		"local ts":       {strings.Replace(good, "12:00:00Z", "12:00:00+09:00", 1), "ts:"},
+		"bad date":       {strings.Replace(good, "03-01", "13-01", 1), "ts:"},
+		"run id":         {strings.Replace(good, `9ac1beef·na`, "dev", 1), "run_id:"},
+		"hash length":    {strings.Replace(good, "0123456789abcdef", "0123456789abcdef00", 1), "input_hash:"},
+		"status type":    {strings.Replace(good, `"status":200`, `"status":"200"`, 1), "status: want number"},
+		"duplicate":      {strings.Replace(good, `"schema":"ots/1"`, `"schema":"ots/1","ts":"x"`, 1), "duplicate key"},
+		"upstream":       {strings.Replace(good, `}`, `,"upstream_ms":-1}`, 1), "upstream_ms: negative"},
+	}
+	for name, tc := range cases {
+		errs := Validate([]byte(tc.line))
+		found := false
+		for _, e := range errs {
+			found = found || strings.Contains(e.Error(), tc.want)
+		}
+		if !found {
+			t.Errorf("%s: want error containing %q, got %v", name, tc.want, errs)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package ots
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	tsPattern    = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(\.\d{1,9})?Z$`)
+	hashPattern  = regexp.MustCompile(`^[0-9a-f]{16}$`)
+	runIDPattern = regexp.MustCompile(`^[A-Za-z0-9._@-]+·[A-Za-z0-9._@-]+$`)
+	reqIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+)
+
+// Validate checks one JSONL line and returns every problem found; nil means
+// the line conforms to Schema.
+func Validate(line []byte) []error {
+	keys, vals, err := decodeOrdered(line)
+	if err != nil {
+		return []error{err}
+	}
+	var errs []error
+	fail := func(format string, args ...any) { errs = append(errs, fmt.Errorf(format, args...)) }
+
+	for i, want := range FixedKeys {
+		if i >= len(keys) || keys[i] != want {
+			got := "end of object"
+			if i < len(keys) {
+				got = fmt.Sprintf("%q", keys[i])
+			}
+			fail("key %d: want %q, got %s", i+1, want, got)
+			break
+		}
+	}
+
+	str := func(k string) (string, bool) {
+		raw, ok := vals[k]
+		if !ok {
+			fail("%s: missing", k)
+			return "", false
+		}
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			fail("%s: want string, got %s", k, raw)
+			return "", false
+		}
+		return s, true
+	}
+	num := func(k string) (float64, bool) {
+		raw, ok := vals[k]
+		if !ok {
+			fail("%s: missing", k)
+			return 0, false
+		}
+		var f float64
+		if err := json.Unmarshal(raw, &f); err != nil {
+			fail("%s: want number, got %s", k, raw)
+			return 0, false
+		}
+		return f, true
+	}
+
+	if s, ok := str("schema"); ok && s != Schema {
+		fail("schema: unsupported %q (want %q)", s, Schema)
+	}
+	if s, ok := str("ts"); ok {
+		if !tsPattern.MatchString(s) {
+			fail("ts: %q is not RFC 3339 UTC with a Z suffix", s)
+		} else if _, err := time.Parse(TimeFormat, s); err != nil {
+			fail("ts: %v", err)
+		}
+	}
+	if s, ok := str("run_id"); ok && !runIDPattern.MatchString(s) {
+		fail("run_id: %q is not <script_sha>·<model_ckpt>", s)
+	}
+	if s, ok := str("path"); ok && !strings.HasPrefix(s, "/") {
+		fail("path: %q does not start with /", s)
+	}
+	if f, ok := num("status"); ok && (f != float64(int(f)) || f < 100 || f > 599) {
+		fail("status: %v is not an HTTP status", f)
+	}
+	if f, ok := num("latency_ms"); ok && f < 0 {
+		fail("latency_ms: negative %v", f)
+	}
+	if s, ok := str("req_id"); ok && s != "" && !reqIDPattern.MatchString(s) {
+		fail("req_id: %q has invalid characters or length", s)
+	}
+	for _, k := range []string{"input_hash", "output_hash"} {
+		if s, ok := str(k); ok && s != "" && !hashPattern.MatchString(s) {
+			fail("%s: %q is not blank or 16 lowercase hex characters", k, s)
+		}
+	}
+	if _, ok := vals["upstream_ms"]; ok {
+		if f, ok := num("upstream_ms"); ok && f < 0 {
+			fail("upstream_ms: negative %v", f)
+		}
+	}
+	for _, k := range []string{"client_req_id", "trace_id", "key_name", "reason_code", "schema_version"} {
+		if _, ok := vals[k]; ok {
+			str(k)
+		}
+	}
+	return errs
+}
+
+// decodeOrdered reads a single JSON object, keeping its key order and
+// rejecting duplicate keys and trailing data.
+func decodeOrdered(line []byte) ([]string, map[string]json.RawMessage, error) {
+	dec := json.NewDecoder(bytes.NewReader(line))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil, nil, fmt.Errorf("not a JSON object")
+	}
+	var keys []string
+	vals := map[string]json.RawMessage{}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid JSON: %v", err)
+		}
+		k := tok.(string)
+		var v json.RawMessage
+		if err := dec.Decode(&v); err != nil {
+			return nil, nil, fmt.Errorf("invalid JSON at %q: %v", k, err)
+		}
+		if _, dup := vals[k]; dup {
+			return nil, nil, fmt.Errorf("duplicate key %q", k)
+		}
+		keys = append(keys, k)
+		vals[k] = v
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, nil, fmt.Errorf("invalid JSON: %v", err)
+	}
+	if _, err := dec.Token(); err == nil {
+		return nil, nil, fmt.Errorf("trailing data after object")
+	}
+	return keys, vals, nil
+}
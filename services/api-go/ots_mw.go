@@ -2,19 +2,21 @@ package main
 
 import (
 	"bytes"
-	"crypto/rand"
-	"crypto/sha256"
+	"context"
 	"encoding/hex"
 	"encoding/json"
-	"fmt"
 	"io"
 	"math"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
+
+	"picca/api-go/archive"
+	"picca/api-go/ots"
 )
 
-const maxCaptureBytes = 1 << 20 // 1 MiB cap for hashing/logging
+const maxCaptureBytes = ots.MaxCaptureBytes
 
 type captureRW struct {
 	http.ResponseWriter
@@ -50,15 +52,31 @@ func (w *captureRW) Write(b []byte) (int, error) {
 	return w.ResponseWriter.Write(b)
 }
 
-func sha16(b []byte) string {
-	sum := sha256.Sum256(b)
-	return hex.EncodeToString(sum[:])[:16]
+type otsAnnotationsKey struct{}
+
+// otsAnnotations carries extra fields that handlers attach to the request's
+// OTS line; keys are appended after the fixed schema keys.
+type otsAnnotations struct {
+	mu     sync.Mutex
+	fields map[string]any
+}
+
+// annotateOTS records key=value on the OTS line for r, if r is traced.
+func annotateOTS(r *http.Request, key string, value any) {
+	if r == nil {
+		return
+	}
+	a, ok := r.Context().Value(otsAnnotationsKey{}).(*otsAnnotations)
+	if !ok {
+		return
+	}
+	a.mu.Lock()
+	a.fields[key] = value
+	a.mu.Unlock()
 }
 
-func newReqID() string {
-	var b [8]byte
-	_, _ = rand.Read(b[:])
-	return hex.EncodeToString(b[:])
+func sha16(b []byte) string {
+	return ots.BodyHash(b)
 }
 
 func isJSON(ct string) bool {
@@ -66,15 +84,19 @@ func isJSON(ct string) bool {
 	return strings.Contains(ct, "json")
 }
 
-// OTSMiddleware wraps handlers to emit Observation Trace Sheet JSONL lines.
+// OTSMiddleware wraps the router to emit exactly one Observation Trace Sheet
+// JSONL line per request. Handlers add to it through annotateOTS.
 func OTSMiddleware(runID string, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		var inHash string
+		var (
+			inHash string
+			inBody []byte
+		)
 		if r.Body != nil && r.ContentLength != 0 && isJSON(r.Header.Get("Content-Type")) {
 			body, err := io.ReadAll(io.LimitReader(r.Body, maxCaptureBytes+1))
 			if err == nil && len(body) > 0 {
 				if len(body) <= maxCaptureBytes {
-					inHash = sha16(body)
+					inHash, inBody = sha16(body), body
 				}
 				r.Body = io.NopCloser(bytes.NewReader(body))
 			} else {
@@ -82,6 +104,17 @@ func OTSMiddleware(runID string, next http.Handler) http.Handler {
 			}
 		}
 
+		annotations := &otsAnnotations{fields: map[string]any{}}
+		r = r.WithContext(context.WithValue(r.Context(), otsAnnotationsKey{}, annotations))
+		r, ids := withRequestID(r)
+		w.Header().Set("X-Request-Id", ids.id)
+		if ids.clientID != "" {
+			annotations.fields["client_req_id"] = ids.clientID
+		}
+		if sp := spanFromContext(r.Context()); sp != nil {
+			annotations.fields["trace_id"] = hex.EncodeToString(sp.tc.TraceID[:])
+		}
+
 		crw := &captureRW{ResponseWriter: w}
 		start := time.Now()
 		next.ServeHTTP(crw, r)
@@ -98,27 +131,51 @@ func OTSMiddleware(runID string, next http.Handler) http.Handler {
 			}
 		}
 
-		reqID := crw.Header().Get("X-Request-Id")
-		if reqID == "" {
-			reqID = r.Header.Get("X-Request-Id")
-		}
-		if reqID == "" {
-			reqID = newReqID()
-			crw.Header().Set("X-Request-Id", reqID)
+		if inHash != "" {
+			if a := currentArchiver(); a != nil {
+				var outBody []byte
+				if outHash != "" {
+					outBody = crw.buf.Bytes()
+				}
+				var headers map[string]string
+				for _, name := range archive.ReplayHeaders {
+					if v := r.Header.Get(name); v != "" {
+						if headers == nil {
+							headers = map[string]string{}
+						}
+						headers[name] = v
+					}
+				}
+				a.offer(archive.Entry{
+					TS: time.Now().UTC().Format(time.RFC3339Nano), RunID: runID, ReqID: ids.id,
+					Method: r.Method, URI: r.URL.RequestURI(), ContentType: r.Header.Get("Content-Type"),
+					Status: crw.status, InputHash: inHash, OutputHash: outHash,
+					Headers: headers, Auth: credentialKind(r),
+				}, inBody, outBody)
+			}
 		}
 
-		rec := map[string]any{
-			"ts":          time.Now().UTC().Format(time.RFC3339Nano),
-			"run_id":      runID,
-			"path":        r.URL.Path,
-			"status":      crw.status,
-			"latency_ms":  latMS,
-			"req_id":      reqID,
-			"input_hash":  inHash,
-			"output_hash": outHash,
+		rec := ots.Record{
+			TS:         time.Now().UTC().Format(time.RFC3339Nano),
+			RunID:      runID,
+			Path:       r.URL.Path,
+			Status:     crw.status,
+			LatencyMs:  latMS,
+			ReqID:      ids.id,
+			InputHash:  inHash,
+			OutputHash: outHash,
+		}
+		annotations.mu.Lock()
+		rec.Extra = annotations.fields
+		annotations.mu.Unlock()
+		if _, ok := rec.Extra["reason_code"]; !ok && crw.status >= 400 {
+			if reason := reasonCodeFromBody(crw.buf.Bytes()); reason != "" {
+				rec.Extra["reason_code"] = reason
+			}
 		}
 		if line, err := json.Marshal(rec); err == nil {
-			fmt.Println(string(line))
+			otsOutput().emit(line)
+			opsFeed.publish(line)
 		}
 	})
 }
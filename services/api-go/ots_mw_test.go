@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"picca/api-go/ots"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestOTSMiddleware_OneEnrichedLinePerError(t *testing.T) {
+	t.Setenv("API_KEY", "secret")
+	t.Setenv("API_ML_URL", "")
+	h := OTSMiddleware("dev·na", newRouter())
+
+	out := captureStdout(t, func() {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/score", bytes.NewBufferString(`{}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-API-Key", "secret")
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	})
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("want one line, got %d:\n%s", len(lines), out)
+	}
+	var rec map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &rec); err != nil {
+		t.Fatal(err)
+	}
+	if rec["status"] != float64(http.StatusInternalServerError) || rec["reason_code"] != "MISCONFIGURED_UPSTREAM" ||
+		rec["key_name"] != "default" || rec["schema_version"] != scoreSchemaVersion {
+		t.Fatalf("line not enriched: %s", lines[0])
+	}
+	if errs := ots.Validate([]byte(lines[0])); errs != nil {
+		t.Fatalf("line does not match the OTS schema: %v\n%s", errs, lines[0])
+	}
+}
+
+func TestOTSMiddleware_RelayedReasonCode(t *testing.T) {
+	ml := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		_, _ = w.Write([]byte(`{"detail":"bad keypoints","reason_code":"INVALID_KEYPOINTS"}`))
+	}))
+	defer ml.Close()
+	t.Setenv("API_KEY", "secret")
+	t.Setenv("API_ML_URL", ml.URL)
+	h := OTSMiddleware("run-test", newRouter())
+
+	out := captureStdout(t, func() {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/score", bytes.NewBufferString(`{}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-API-Key", "secret")
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	})
+	var rec map[string]any
+	if err := json.Unmarshal([]byte(strings.TrimSpace(out)), &rec); err != nil {
+		t.Fatalf("want one line: %v\n%s", err, out)
+	}
+	if rec["reason_code"] != "INVALID_KEYPOINTS" || rec["output_hash"] == "" {
+		t.Fatalf("relayed error not recorded: %s", out)
+	}
+}
+
+func TestNewServeMux_OTSOnlyOnAPIAndAliases(t *testing.T) {
+	t.Setenv("API_KEY", "secret")
+	r := newRouter()
+	r.GET("/v1/ping", func(c *gin.Context) { c.String(http.StatusOK, "pong") })
+	mux := newServeMux(r, http.NotFoundHandler())
+
+	for _, tc := range []struct {
+		method, path string
+		lines        int
+	}{
+		{http.MethodGet, "/v1/ping", 0},
+		{http.MethodGet, "/demo", 0},
+		{http.MethodGet, "/nope", 0},
+		{http.MethodPost, "/api/v1/score", 1},
+		{http.MethodPost, "/explain", 1},
+		{http.MethodPost, "/v1/explain", 1},
+	} {
+		out := captureStdout(t, func() {
+			mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(tc.method, tc.path, nil))
+		})
+		if got := strings.Count(out, "\n"); got != tc.lines {
+			t.Errorf("%s %s: %d OTS lines, want %d:\n%s", tc.method, tc.path, got, tc.lines, out)
+		}
+	}
+}
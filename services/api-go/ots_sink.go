@@ -0,0 +1,363 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// otsSink is a destination for OTS lines. write gets one line without the
+// trailing newline; flush pushes out anything the sink buffers itself.
+type otsSink interface {
+	write(line []byte) error
+	flush() error
+	close() error
+}
+
+// stdoutSink resolves os.Stdout per write so redirection keeps working.
+type stdoutSink struct{}
+
+func (stdoutSink) write(line []byte) error {
+	_, err := fmt.Fprintf(os.Stdout, "%s\n", line)
+	return err
+}
+
+func (stdoutSink) flush() error { return nil }
+func (stdoutSink) close() error { return nil }
+
+// fileSink writes dir/YYYYMMDD/api-go-NNN.jsonl, moving to a new file when
+// the UTC date changes or the current one would grow past maxBytes.
+type fileSink struct {
+	dir      string
+	maxBytes int64
+	now      func() time.Time
+
+	f    *os.File
+	w    *bufio.Writer
+	day  string
+	seq  int
+	size int64
+}
+
+func newFileSink(dir string, maxBytes int64) *fileSink {
+	return &fileSink{dir: dir, maxBytes: maxBytes, now: time.Now}
+}
+
+func (s *fileSink) write(line []byte) error {
+	day := s.now().UTC().Format("20060102")
+	n := int64(len(line)) + 1
+	if s.f == nil || day != s.day || (s.size > 0 && s.size+n > s.maxBytes) {
+		if err := s.rotate(day); err != nil {
+			return err
+		}
+	}
+	if _, err := s.w.Write(line); err != nil {
+		return err
+	}
+	if err := s.w.WriteByte('\n'); err != nil {
+		return err
+	}
+	s.size += n
+	return nil
+}
+
+// rotate opens the first file of day that still has room, appending to it so
+// a restart continues where the last process stopped.
+func (s *fileSink) rotate(day string) error {
+	if err := s.close(); err != nil {
+		log.Printf("ots: closing %s: %v", s.day, err)
+	}
+	if day != s.day {
+		s.day, s.seq = day, 0
+	}
+	dir := filepath.Join(s.dir, day)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	for {
+		s.seq++
+		path := filepath.Join(dir, fmt.Sprintf("api-go-%03d.jsonl", s.seq))
+		var size int64
+		if fi, err := os.Stat(path); err == nil {
+			if size = fi.Size(); size >= s.maxBytes {
+				continue
+			}
+		}
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return err
+		}
+		s.f, s.w, s.size = f, bufio.NewWriter(f), size
+		return nil
+	}
+}
+
+func (s *fileSink) flush() error {
+	if s.w == nil {
+		return nil
+	}
+	return s.w.Flush()
+}
+
+func (s *fileSink) close() error {
+	if s.f == nil {
+		return nil
+	}
+	err := s.w.Flush()
+	if cerr := s.f.Close(); err == nil {
+		err = cerr
+	}
+	s.f, s.w = nil, nil
+	return err
+}
+
+// udpSink sends one datagram per line, either raw or framed as an RFC 5424
+// syslog message (facility local0, severity info).
+type udpSink struct {
+	conn     net.Conn
+	syslog   bool
+	hostname string
+}
+
+func newUDPSink(addr string, syslog bool) (*udpSink, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	host, _ := os.Hostname()
+	if host == "" {
+		host = "-"
+	}
+	return &udpSink{conn: conn, syslog: syslog, hostname: host}, nil
+}
+
+func (s *udpSink) write(line []byte) error {
+	if s.syslog {
+		hdr := fmt.Sprintf("<134>1 %s %s picca-api-go %d ots - ", time.Now().UTC().Format("2006-01-02T15:04:05.000Z"), s.hostname, os.Getpid())
+		line = append([]byte(hdr), line...)
+	}
+	_, err := s.conn.Write(line)
+	return err
+}
+
+func (s *udpSink) flush() error { return nil }
+func (s *udpSink) close() error { return s.conn.Close() }
+
+// otsQueue feeds one sink from its own goroutine so a slow destination never
+// holds up requests or the other sinks. Lines are dropped when it is full.
+type otsQueue struct {
+	name     string
+	sink     otsSink
+	lines    chan []byte
+	flushReq chan chan struct{}
+	quit     chan struct{}
+}
+
+func newOTSQueue(name string, sink otsSink, size int) *otsQueue {
+	q := &otsQueue{name: name, sink: sink, lines: make(chan []byte, size), flushReq: make(chan chan struct{}), quit: make(chan struct{})}
+	go q.run()
+	return q
+}
+
+func (q *otsQueue) write(line []byte) {
+	if err := q.sink.write(line); err != nil {
+		gwMetrics.otsErrors.inc(q.name)
+	}
+}
+
+func (q *otsQueue) run() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case line := <-q.lines:
+			q.write(line)
+		case <-ticker.C:
+			_ = q.sink.flush()
+		case done := <-q.flushReq:
+			for drained := false; !drained; {
+				select {
+				case line := <-q.lines:
+					q.write(line)
+				default:
+					drained = true
+				}
+			}
+			if err := q.sink.flush(); err != nil {
+				gwMetrics.otsErrors.inc(q.name)
+			}
+			close(done)
+		case <-q.quit:
+			return
+		}
+	}
+}
+
+// flush writes everything queued so far and waits for the sink to flush.
+func (q *otsQueue) flush() {
+	done := make(chan struct{})
+	q.flushReq <- done
+	<-done
+}
+
+func (q *otsQueue) close() {
+	q.flush()
+	close(q.quit)
+	if err := q.sink.close(); err != nil {
+		log.Printf("ots: closing %s sink: %v", q.name, err)
+	}
+}
+
+// otsWriter fans each OTS line out to the configured sinks. Once closed it
+// counts further lines as dropped instead of queueing them to stopped sinks.
+type otsWriter struct {
+	queues []*otsQueue
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+func (w *otsWriter) emit(line []byte) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	for _, q := range w.queues {
+		if w.closed {
+			gwMetrics.otsDropped.inc(q.name)
+			continue
+		}
+		select {
+		case q.lines <- line:
+		default:
+			gwMetrics.otsDropped.inc(q.name)
+		}
+	}
+}
+
+func (w *otsWriter) flush() {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		return
+	}
+	for _, q := range w.queues {
+		q.flush()
+	}
+}
+
+// close drains the queues into their sinks; lines emitted before it took
+// the lock are written, later ones are dropped.
+func (w *otsWriter) close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return
+	}
+	w.closed = true
+	for _, q := range w.queues {
+		q.close()
+	}
+}
+
+// buildOTSWriter opens the sinks named in OTS_SINKS. A sink that cannot be
+// opened is logged and skipped; with none left, lines still go to stdout.
+func buildOTSWriter(names []string, dir string, maxBytes int64, udpAddr, syslogAddr string, buffer int) *otsWriter {
+	w := &otsWriter{}
+	for _, name := range names {
+		var (
+			sink otsSink
+			err  error
+		)
+		switch name {
+		case "stdout":
+			sink = stdoutSink{}
+		case "file":
+			sink = newFileSink(dir, maxBytes)
+		case "udp":
+			if udpAddr == "" {
+				err = fmt.Errorf("OTS_UDP_ADDR is not set")
+				break
+			}
+			sink, err = newUDPSink(udpAddr, false)
+		case "syslog":
+			sink, err = newUDPSink(syslogAddr, true)
+		default:
+			err = fmt.Errorf("unknown sink")
+		}
+		if err != nil {
+			log.Printf("ots: %s sink disabled: %v", name, err)
+			continue
+		}
+		w.queues = append(w.queues, newOTSQueue(name, sink, buffer))
+	}
+	if len(w.queues) == 0 {
+		w.queues = append(w.queues, newOTSQueue("stdout", stdoutSink{}, buffer))
+	}
+	return w
+}
+
+var (
+	otsMu  sync.Mutex
+	otsOut atomic.Pointer[otsWriter]
+)
+
+// otsOutput returns the OTS writer, building it from the environment on
+// first use; requests only pay for an atomic load.
+func otsOutput() *otsWriter {
+	if w := otsOut.Load(); w != nil {
+		return w
+	}
+	otsMu.Lock()
+	defer otsMu.Unlock()
+	if w := otsOut.Load(); w != nil {
+		return w
+	}
+	w := otsWriterFromEnv()
+	otsOut.Store(w)
+	return w
+}
+
+// reloadOTS rebuilds the writer from the environment, swaps it in and closes
+// the previous one after draining it.
+func reloadOTS() {
+	otsMu.Lock()
+	prev := otsOut.Swap(otsWriterFromEnv())
+	otsMu.Unlock()
+	if prev != nil {
+		prev.close()
+	}
+}
+
+// otsWriterFromEnv follows OTS_SINKS (comma list of stdout, file, udp,
+// syslog; default stdout), OTS_FILE_DIR (logs/lachesis), OTS_FILE_MAX_BYTES
+// (64 MiB), OTS_UDP_ADDR, OTS_SYSLOG_ADDR (localhost:514) and OTS_BUFFER
+// (4096 lines per sink).
+func otsWriterFromEnv() *otsWriter {
+	names := splitList(envOr("OTS_SINKS", "stdout"))
+	dir := envOr("OTS_FILE_DIR", "logs/lachesis")
+	maxBytes := int64(64 << 20)
+	if n, err := strconv.ParseInt(os.Getenv("OTS_FILE_MAX_BYTES"), 10, 64); err == nil && n > 0 {
+		maxBytes = n
+	}
+	udpAddr := strings.TrimSpace(os.Getenv("OTS_UDP_ADDR"))
+	syslogAddr := envOr("OTS_SYSLOG_ADDR", "localhost:514")
+	buffer := 4096
+	if n, err := strconv.Atoi(os.Getenv("OTS_BUFFER")); err == nil && n > 0 {
+		buffer = n
+	}
+	return buildOTSWriter(names, dir, maxBytes, udpAddr, syslogAddr, buffer)
+}
+
+// flushOTS drains every sink; main calls it on shutdown.
+func flushOTS() {
+	if w := otsOut.Load(); w != nil {
+		w.flush()
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileSink_RotatesByDateAndSize(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2026, 3, 1, 23, 59, 0, 0, time.UTC)
+	s := newFileSink(dir, 20)
+	s.now = func() time.Time { return now }
+
+	for _, l := range []string{`{"n":1}`, `{"n":2}`, `{"n":3}`} {
+		if err := s.write([]byte(l)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now = now.Add(2 * time.Minute)
+	if err := s.write([]byte(`{"n":4}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"20260301/api-go-001.jsonl": "{\"n\":1}\n{\"n\":2}\n",
+		"20260301/api-go-002.jsonl": "{\"n\":3}\n",
+		"20260302/api-go-001.jsonl": "{\"n\":4}\n",
+	}
+	for name, content := range want {
+		b, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil || string(b) != content {
+			t.Errorf("%s = %q, %v; want %q", name, b, err, content)
+		}
+	}
+
+	// A restart appends to the last file that still has room.
+	s = newFileSink(dir, 20)
+	s.now = func() time.Time { return now }
+	_ = s.write([]byte(`{"n":5}`))
+	_ = s.close()
+	if b, _ := os.ReadFile(filepath.Join(dir, "20260302/api-go-001.jsonl")); string(b) != "{\"n\":4}\n{\"n\":5}\n" {
+		t.Fatalf("restart did not append: %q", b)
+	}
+}
+
+type blockingSink struct{ release chan struct{} }
+
+func (s blockingSink) write([]byte) error { <-s.release; return nil }
+func (blockingSink) flush() error         { return nil }
+func (blockingSink) close() error         { return nil }
+
+func TestOTSWriter_DropsWhenQueueFull(t *testing.T) {
+	prev := gwMetrics
+	gwMetrics = newGatewayMetrics()
+	t.Cleanup(func() { gwMetrics = prev })
+
+	sink := blockingSink{release: make(chan struct{})}
+	w := &otsWriter{queues: []*otsQueue{newOTSQueue("slow", sink, 1)}}
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 5; i++ {
+			w.emit([]byte(`{}`))
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("emit blocked on a slow sink")
+	}
+	close(sink.release)
+	w.flush()
+
+	gwMetrics.otsDropped.mu.Lock()
+	s := gwMetrics.otsDropped.series["slow"]
+	gwMetrics.otsDropped.mu.Unlock()
+	if s == nil || s.value < 3 {
+		t.Fatalf("dropped lines not counted: %+v", s)
+	}
+}
+
+func TestOTSWriter_CountsLinesAfterCloseAsDropped(t *testing.T) {
+	prev := gwMetrics
+	gwMetrics = newGatewayMetrics()
+	t.Cleanup(func() { gwMetrics = prev })
+
+	var got []string
+	sink := &recordingSink{lines: &got}
+	w := &otsWriter{queues: []*otsQueue{newOTSQueue("rec", sink, 8)}}
+	w.emit([]byte(`{"req_id":"a"}`))
+	w.close()
+	w.emit([]byte(`{"req_id":"b"}`))
+	w.flush()
+
+	if len(got) != 1 || got[0] != `{"req_id":"a"}` {
+		t.Fatalf("sink got %q, want only the line emitted before close", got)
+	}
+	gwMetrics.otsDropped.mu.Lock()
+	s := gwMetrics.otsDropped.series["rec"]
+	gwMetrics.otsDropped.mu.Unlock()
+	if s == nil || s.value != 1 {
+		t.Fatalf("line after close not counted as dropped: %+v", s)
+	}
+}
+
+type recordingSink struct{ lines *[]string }
+
+func (s *recordingSink) write(line []byte) error {
+	*s.lines = append(*s.lines, string(line))
+	return nil
+}
+func (*recordingSink) flush() error { return nil }
+func (*recordingSink) close() error { return nil }
+
+func TestUDPSink_SyslogFraming(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("udp unavailable: %v", err)
+	}
+	defer pc.Close()
+	s, err := newUDPSink(pc.LocalAddr().String(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+	if err := s.write([]byte(`{"status":200}`)); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1024)
+	_ = pc.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(buf[:n])
+	if !strings.HasPrefix(got, "<134>1 ") || !strings.Contains(got, " picca-api-go ") || !strings.HasSuffix(got, ` ots - {"status":200}`) {
+		t.Fatalf("datagram %q", got)
+	}
+}
+
+func TestOTSOutput_FileSinkFromEnv(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("OTS_SINKS", "file, bogus")
+	t.Setenv("OTS_FILE_DIR", dir)
+	reloadOTS()
+	t.Cleanup(func() {
+		os.Unsetenv("OTS_SINKS")
+		reloadOTS()
+	})
+
+	otsOutput().emit([]byte(`{"req_id":"a"}`))
+	flushOTS()
+	matches, _ := filepath.Glob(filepath.Join(dir, "*", "*.jsonl"))
+	if len(matches) != 1 {
+		t.Fatalf("files: %v", matches)
+	}
+	if b, _ := os.ReadFile(matches[0]); string(b) != "{\"req_id\":\"a\"}\n" {
+		t.Fatalf("content %q", b)
+	}
+}
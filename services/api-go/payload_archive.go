@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"picca/api-go/archive"
+)
+
+type archiveJob struct {
+	entry   archive.Entry
+	in, out []byte
+}
+
+// payloadArchiver writes sampled exchanges to an archive.Store from its own
+// goroutine, so requests never wait on the disk; overflow is dropped.
+type payloadArchiver struct {
+	store     archive.Store
+	rate      float64
+	retention time.Duration
+	maxBytes  int64
+
+	queue    chan archiveJob
+	flushReq chan chan struct{}
+	quit     chan struct{}
+}
+
+func newPayloadArchiver(store archive.Store, rate float64, retention time.Duration, maxBytes int64) *payloadArchiver {
+	a := &payloadArchiver{
+		store: store, rate: rate, retention: retention, maxBytes: maxBytes,
+		queue: make(chan archiveJob, 256), flushReq: make(chan chan struct{}), quit: make(chan struct{}),
+	}
+	go a.run()
+	return a
+}
+
+// offer queues the exchange when it falls in the sample.
+func (a *payloadArchiver) offer(e archive.Entry, in, out []byte) {
+	if a.rate < 1 && rand.Float64() >= a.rate {
+		return
+	}
+	select {
+	case a.queue <- archiveJob{entry: e, in: in, out: out}:
+	default:
+		gwMetrics.archiveDropped.inc()
+	}
+}
+
+func (a *payloadArchiver) write(j archiveJob) {
+	if err := a.store.Put(j.entry, j.in, j.out, time.Now()); err != nil {
+		gwMetrics.archiveDropped.inc()
+		log.Printf("archive: %s: %v", j.entry.ReqID, err)
+	}
+}
+
+func (a *payloadArchiver) prune() {
+	if n, err := a.store.Prune(time.Now(), a.retention, a.maxBytes); err != nil {
+		log.Printf("archive: prune: %v", err)
+	} else if n > 0 {
+		log.Printf("archive: pruned %d files", n)
+	}
+}
+
+func (a *payloadArchiver) run() {
+	a.prune()
+	ticker := time.NewTicker(10 * time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case j := <-a.queue:
+			a.write(j)
+		case <-ticker.C:
+			a.prune()
+		case done := <-a.flushReq:
+			for drained := false; !drained; {
+				select {
+				case j := <-a.queue:
+					a.write(j)
+				default:
+					drained = true
+				}
+			}
+			close(done)
+		case <-a.quit:
+			return
+		}
+	}
+}
+
+func (a *payloadArchiver) flush() {
+	done := make(chan struct{})
+	a.flushReq <- done
+	<-done
+}
+
+func (a *payloadArchiver) close() {
+	a.flush()
+	close(a.quit)
+}
+
+var (
+	archiveMu  sync.Mutex
+	archiveKey string
+	archiver   *payloadArchiver
+)
+
+// currentArchiver follows ARCHIVE_DIR (unset disables archiving),
+// ARCHIVE_SAMPLE_RATE (0-1, default 1), ARCHIVE_RETENTION (168h) and
+// ARCHIVE_MAX_BYTES (1 GiB), building the archiver once per configuration.
+func currentArchiver() *payloadArchiver {
+	dir := strings.TrimSpace(os.Getenv("ARCHIVE_DIR"))
+	rate := 1.0
+	if f, err := strconv.ParseFloat(os.Getenv("ARCHIVE_SAMPLE_RATE"), 64); err == nil && f >= 0 && f <= 1 {
+		rate = f
+	}
+	retention := 7 * 24 * time.Hour
+	if d, err := time.ParseDuration(os.Getenv("ARCHIVE_RETENTION")); err == nil && d > 0 {
+		retention = d
+	}
+	maxBytes := int64(1 << 30)
+	if n, err := strconv.ParseInt(os.Getenv("ARCHIVE_MAX_BYTES"), 10, 64); err == nil && n > 0 {
+		maxBytes = n
+	}
+	key := fmt.Sprintf("%s|%g|%s|%d", dir, rate, retention, maxBytes)
+
+	archiveMu.Lock()
+	defer archiveMu.Unlock()
+	if key == archiveKey {
+		return archiver
+	}
+	if prev := archiver; prev != nil {
+		go prev.close()
+	}
+	archiveKey, archiver = key, nil
+	if dir != "" && rate > 0 {
+		archiver = newPayloadArchiver(archive.Store{Dir: dir}, rate, retention, maxBytes)
+		log.Printf("archive: storing %.0f%% of requests in %s (retention %s, cap %d bytes)", rate*100, dir, retention, maxBytes)
+	}
+	return archiver
+}
+
+// flushArchive writes out queued exchanges; main calls it on shutdown.
+func flushArchive() {
+	archiveMu.Lock()
+	a := archiver
+	archiveMu.Unlock()
+	if a != nil {
+		a.flush()
+	}
+}
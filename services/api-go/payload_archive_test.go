@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"picca/api-go/archive"
+)
+
+func TestOTSMiddleware_ArchivesSampledRequests(t *testing.T) {
+	ml := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"score":70,"symmetry":0.7,"power":0.7,"consistency":0.7}`))
+	}))
+	defer ml.Close()
+	dir := t.TempDir()
+	t.Setenv("API_KEY", "secret")
+	t.Setenv("API_ML_URL", ml.URL)
+	t.Setenv("ARCHIVE_DIR", dir)
+	t.Cleanup(func() {
+		flushArchive()
+		os.Unsetenv("ARCHIVE_DIR")
+		currentArchiver()
+	})
+
+	h := OTSMiddleware("dev·na", newRouter())
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/score", bytes.NewBufferString(`{"fps":30,"keypoints":[]}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-API-Key", "secret")
+	req.Header.Set("X-Subject-Id", "athlete-1")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	flushArchive()
+
+	store := archive.Store{Dir: dir}
+	var entries []archive.Entry
+	_ = store.Entries(func(e archive.Entry) error { entries = append(entries, e); return nil })
+	if len(entries) != 1 {
+		t.Fatalf("entries: %+v", entries)
+	}
+	e := entries[0]
+	if e.Status != http.StatusOK || e.URI != "/api/v1/score" || e.InputHash != sha16([]byte(`{"fps":30,"keypoints":[]}`)) {
+		t.Fatalf("entry %+v", e)
+	}
+	if e.Auth != credentialAPIKey || len(e.Headers) != 1 || e.Headers["X-Subject-Id"] != "athlete-1" {
+		t.Fatalf("entry auth=%q headers=%v", e.Auth, e.Headers)
+	}
+	if out, err := store.Blob(e.OutputHash); err != nil || sha16(out) != e.OutputHash {
+		t.Fatalf("output blob: %s, %v", out, err)
+	}
+
+	t.Setenv("ARCHIVE_SAMPLE_RATE", "0")
+	if currentArchiver() != nil {
+		t.Fatal("sample rate 0 should disable archiving")
+	}
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// quotaLimits caps calls and explain tokens per key per UTC day and month.
+// Zero means unlimited.
+type quotaLimits struct {
+	ScoreDaily           int64 `json:"score_daily,omitempty"`
+	ScoreMonthly         int64 `json:"score_monthly,omitempty"`
+	ExplainDaily         int64 `json:"explain_daily,omitempty"`
+	ExplainMonthly       int64 `json:"explain_monthly,omitempty"`
+	ExplainTokensDaily   int64 `json:"explain_tokens_daily,omitempty"`
+	ExplainTokensMonthly int64 `json:"explain_tokens_monthly,omitempty"`
+}
+
+// defaultQuota reads QUOTA_DEFAULT (inline JSON) for keys without their own
+// "quota" entry.
+func defaultQuota() *quotaLimits {
+	raw := strings.TrimSpace(os.Getenv("QUOTA_DEFAULT"))
+	if raw == "" {
+		return nil
+	}
+	var q quotaLimits
+	if err := json.Unmarshal([]byte(raw), &q); err != nil {
+		log.Printf("quota: ignoring QUOTA_DEFAULT: %v", err)
+		return nil
+	}
+	return &q
+}
+
+type usageCounts struct {
+	Score         int64 `json:"score"`
+	Explain       int64 `json:"explain"`
+	ExplainTokens int64 `json:"explain_tokens"`
+}
+
+type keyUsage struct {
+	Day     string      `json:"day"`
+	Month   string      `json:"month"`
+	Daily   usageCounts `json:"daily"`
+	Monthly usageCounts `json:"monthly"`
+}
+
+// quotaTracker counts usage per key for the current UTC day and month and
+// persists the counters to QUOTA_STATE_FILE so restarts do not reset them.
+type quotaTracker struct {
+	mu    sync.Mutex
+	usage map[string]*keyUsage
+	dirty bool
+	now   func() time.Time
+}
+
+var quotaUsage = newQuotaTracker(time.Now)
+
+func newQuotaTracker(now func() time.Time) *quotaTracker {
+	return &quotaTracker{usage: map[string]*keyUsage{}, now: now}
+}
+
+// current returns name's counters, resetting any period that has rolled over.
+func (q *quotaTracker) current(name string) *keyUsage {
+	now := q.now().UTC()
+	day, month := now.Format("2006-01-02"), now.Format("2006-01")
+	u, ok := q.usage[name]
+	if !ok {
+		u = &keyUsage{}
+		q.usage[name] = u
+	}
+	if u.Day != day {
+		u.Day, u.Daily = day, usageCounts{}
+	}
+	if u.Month != month {
+		u.Month, u.Monthly = month, usageCounts{}
+	}
+	return u
+}
+
+type quotaDenial struct {
+	limit  string
+	period string
+	reset  time.Time
+}
+
+// denial reports the first exhausted limit for class ("score" or "explain").
+// The caller holds q.mu.
+func (q *quotaTracker) denial(u *keyUsage, class string, limits quotaLimits) *quotaDenial {
+	now := q.now().UTC()
+	nextDay := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+	nextMonth := time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+
+	type check struct {
+		used, limit int64
+		name        string
+		period      string
+		reset       time.Time
+	}
+	var checks []check
+	switch class {
+	case scopeScore:
+		checks = []check{
+			{u.Daily.Score, limits.ScoreDaily, "score_daily", "day", nextDay},
+			{u.Monthly.Score, limits.ScoreMonthly, "score_monthly", "month", nextMonth},
+		}
+	case scopeExplain:
+		checks = []check{
+			{u.Daily.Explain, limits.ExplainDaily, "explain_daily", "day", nextDay},
+			{u.Monthly.Explain, limits.ExplainMonthly, "explain_monthly", "month", nextMonth},
+			{u.Daily.ExplainTokens, limits.ExplainTokensDaily, "explain_tokens_daily", "day", nextDay},
+			{u.Monthly.ExplainTokens, limits.ExplainTokensMonthly, "explain_tokens_monthly", "month", nextMonth},
+		}
+	}
+	for _, ch := range checks {
+		if ch.limit > 0 && ch.used >= ch.limit {
+			return &quotaDenial{limit: ch.name, period: ch.period, reset: ch.reset}
+		}
+	}
+	return nil
+}
+
+// check reports whether class is exhausted without counting a call.
+func (q *quotaTracker) check(name, class string, limits quotaLimits) *quotaDenial {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.denial(q.current(name), class, limits)
+}
+
+// admit checks class against limits and, when allowed, counts the call.
+func (q *quotaTracker) admit(name, class string, limits quotaLimits) *quotaDenial {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	u := q.current(name)
+	if d := q.denial(u, class, limits); d != nil {
+		return d
+	}
+
+	switch class {
+	case scopeScore:
+		u.Daily.Score++
+		u.Monthly.Score++
+	case scopeExplain:
+		u.Daily.Explain++
+		u.Monthly.Explain++
+	}
+	q.dirty = true
+	return nil
+}
+
+func (q *quotaTracker) addTokens(name string, tokens int64) {
+	if tokens <= 0 {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	u := q.current(name)
+	u.Daily.ExplainTokens += tokens
+	u.Monthly.ExplainTokens += tokens
+	q.dirty = true
+}
+
+func (q *quotaTracker) snapshot(name string) keyUsage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return *q.current(name)
+}
+
+func (q *quotaTracker) load(path string) error {
+	raw, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	usage := map[string]*keyUsage{}
+	if err := json.Unmarshal(raw, &usage); err != nil {
+		return err
+	}
+	q.mu.Lock()
+	q.usage = usage
+	q.mu.Unlock()
+	return nil
+}
+
+// flush writes the counters atomically (temp file + rename) if they changed,
+// leaving out periods that have ended. The counters stay dirty until a write
+// succeeds.
+func (q *quotaTracker) flush(path string) error {
+	q.mu.Lock()
+	if !q.dirty {
+		q.mu.Unlock()
+		return nil
+	}
+	q.dropExpired()
+	raw, err := json.Marshal(q.usage)
+	q.dirty = false
+	q.mu.Unlock()
+	if err == nil {
+		err = writeFileAtomic(path, raw)
+	}
+	if err != nil {
+		q.mu.Lock()
+		q.dirty = true
+		q.mu.Unlock()
+	}
+	return err
+}
+
+// dropExpired forgets keys with no usage in the current month and resets
+// the past day of the rest. The caller holds q.mu.
+func (q *quotaTracker) dropExpired() {
+	month := q.now().UTC().Format("2006-01")
+	for name, u := range q.usage {
+		if u.Month != month {
+			delete(q.usage, name)
+			continue
+		}
+		q.current(name)
+	}
+}
+
+func writeFileAtomic(path string, raw []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".quota-*.json")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func quotaFlushInterval() time.Duration {
+	if v := os.Getenv("QUOTA_FLUSH_INTERVAL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			return d
+		}
+	}
+	return 5 * time.Second
+}
+
+// startQuotaPersistence loads QUOTA_STATE_FILE and flushes it periodically.
+// The returned func performs the final flush on shutdown.
+func startQuotaPersistence(stop <-chan struct{}) func() {
+	path := strings.TrimSpace(os.Getenv("QUOTA_STATE_FILE"))
+	if path == "" {
+		return func() {}
+	}
+	if err := quotaUsage.load(path); err != nil {
+		log.Printf("quota: load %s: %v", path, err)
+	}
+	flush := func() {
+		if err := quotaUsage.flush(path); err != nil {
+			log.Printf("quota: flush %s: %v", path, err)
+		}
+	}
+	go func() {
+		ticker := time.NewTicker(quotaFlushInterval())
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				flush()
+			}
+		}
+	}()
+	return flush
+}
+
+func principalQuota(p principal) quotaLimits {
+	if p.Quota != nil {
+		return *p.Quota
+	}
+	if q := defaultQuota(); q != nil {
+		return *q
+	}
+	return quotaLimits{}
+}
+
+// checkQuota answers 429 QUOTA_EXCEEDED when the caller has already used up
+// a daily or monthly allowance for class. It does not count the call; see
+// chargeQuota.
+func checkQuota(c *gin.Context, class string, p principal) bool {
+	return quotaAllowed(c, quotaUsage.check(p.Name, class, principalQuota(p)))
+}
+
+// chargeQuota counts the call against the caller's allowance for class once
+// the request has passed validation, so rejected bodies cost nothing. It
+// answers 429 if concurrent calls used up the allowance in the meantime.
+func chargeQuota(c *gin.Context, class string) bool {
+	p, ok := currentPrincipal(c)
+	if !ok || c.GetBool("quota_charged") {
+		return true
+	}
+	c.Set("quota_charged", true)
+	return quotaAllowed(c, quotaUsage.admit(p.Name, class, principalQuota(p)))
+}
+
+func quotaAllowed(c *gin.Context, denial *quotaDenial) bool {
+	if denial == nil {
+		return true
+	}
+	retry := denial.reset.Sub(quotaUsage.now())
+	if retry < time.Second {
+		retry = time.Second
+	}
+	c.Header("Retry-After", strconv.Itoa(int(retry.Seconds())))
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error":       "quota exceeded",
+		"reason_code": "QUOTA_EXCEEDED",
+		"limit":       denial.limit,
+		"period":      denial.period,
+		"resets_at":   denial.reset.Format(time.RFC3339),
+	})
+	annotateResult(c, "QUOTA_EXCEEDED", 0)
+	return false
+}
+
+// usageHandler lets any authenticated key read its own consumption.
+func usageHandler(c *gin.Context) {
+	requestID(c)
+	p, ok := authenticate(c)
+	if !ok {
+		return
+	}
+	u := quotaUsage.snapshot(p.Name)
+	c.JSON(http.StatusOK, gin.H{
+		"key_name": p.Name,
+		"day":      gin.H{"period": u.Day, "usage": u.Daily},
+		"month":    gin.H{"period": u.Month, "usage": u.Monthly},
+		"limits":   principalQuota(p),
+	})
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestQuotaTracker_LimitsAndRollover(t *testing.T) {
+	now := time.Date(2026, 1, 31, 23, 0, 0, 0, time.UTC)
+	q := newQuotaTracker(func() time.Time { return now })
+	limits := quotaLimits{ExplainDaily: 2, ExplainMonthly: 3, ExplainTokensDaily: 100}
+
+	for i := 0; i < 2; i++ {
+		if d := q.admit("web", scopeExplain, limits); d != nil {
+			t.Fatalf("call %d denied: %+v", i, d)
+		}
+	}
+	d := q.admit("web", scopeExplain, limits)
+	if d == nil || d.limit != "explain_daily" || !d.reset.Equal(time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("want daily denial, got %+v", d)
+	}
+	if d := q.admit("batch", scopeExplain, limits); d != nil {
+		t.Fatalf("other key affected: %+v", d)
+	}
+
+	// The next day is also a new month, so both counters reset.
+	now = now.Add(2 * time.Hour)
+	if d := q.admit("web", scopeExplain, limits); d != nil {
+		t.Fatalf("rollover did not reset counters: %+v", d)
+	}
+	q.addTokens("web", 150)
+	if d := q.admit("web", scopeExplain, limits); d == nil || d.limit != "explain_tokens_daily" {
+		t.Fatalf("want token denial, got %+v", d)
+	}
+	if got := q.snapshot("web"); got.Month != "2026-02" || got.Monthly.Explain != 1 || got.Monthly.ExplainTokens != 150 {
+		t.Fatalf("unexpected snapshot %+v", got)
+	}
+}
+
+func TestQuotaTracker_PersistsAcrossRestarts(t *testing.T) {
+	now := time.Date(2026, 3, 3, 12, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+	path := filepath.Join(t.TempDir(), "quota.json")
+
+	q := newQuotaTracker(clock)
+	q.admit("web", scopeScore, quotaLimits{})
+	q.addTokens("web", 42)
+	if err := q.flush(path); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+
+	restarted := newQuotaTracker(clock)
+	if err := restarted.load(path); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	got := restarted.snapshot("web")
+	if got.Daily.Score != 1 || got.Daily.ExplainTokens != 42 {
+		t.Fatalf("counters not restored: %+v", got)
+	}
+}
+
+func TestQuotaTracker_FlushDropsEndedPeriodsAndRetries(t *testing.T) {
+	now := time.Date(2026, 3, 31, 12, 0, 0, 0, time.UTC)
+	q := newQuotaTracker(func() time.Time { return now })
+	q.admit("old", scopeScore, quotaLimits{})
+	now = now.Add(24 * time.Hour)
+	q.admit("web", scopeScore, quotaLimits{})
+
+	if err := q.flush(filepath.Join(t.TempDir(), "missing", "quota.json")); err == nil {
+		t.Fatalf("expected write error")
+	}
+	if !q.dirty {
+		t.Fatalf("failed flush must leave the counters dirty")
+	}
+
+	path := filepath.Join(t.TempDir(), "quota.json")
+	if err := q.flush(path); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	restarted := newQuotaTracker(func() time.Time { return now })
+	if err := restarted.load(path); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if _, ok := restarted.usage["old"]; ok || restarted.usage["web"] == nil {
+		t.Fatalf("want only keys with usage this month, got %v", restarted.usage)
+	}
+}
+
+func TestScoreHandler_QuotaExceededAndUsage(t *testing.T) {
+	prev := quotaUsage
+	now := time.Date(2026, 3, 1, 23, 0, 0, 0, time.UTC)
+	quotaUsage = newQuotaTracker(func() time.Time { return now })
+	t.Cleanup(func() { quotaUsage = prev })
+
+	ml := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"score":70,"symmetry":0.7,"power":0.7,"consistency":0.7}`))
+	}))
+	defer ml.Close()
+	t.Setenv("API_ML_URL", ml.URL)
+	writeKeyFile(t, `{"keys":[
+		{"name":"trial","key":"trial-secret","scopes":["score"],"quota":{"score_daily":1}}
+	]}`)
+
+	r := newRouter()
+	send := func(method, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(`{}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-API-Key", "trial-secret")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	// A request rejected by validation does not use up the allowance.
+	bad := httptest.NewRequest(http.MethodPost, "/api/v1/score", bytes.NewBufferString(`{}`))
+	bad.Header.Set("Content-Type", "text/plain")
+	bad.Header.Set("X-API-Key", "trial-secret")
+	bw := httptest.NewRecorder()
+	r.ServeHTTP(bw, bad)
+	if bw.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("bad content type: %d %s", bw.Code, bw.Body.String())
+	}
+
+	if w := send(http.MethodPost, "/api/v1/score"); w.Code != http.StatusOK {
+		t.Fatalf("first call: %d %s", w.Code, w.Body.String())
+	}
+	w := send(http.MethodPost, "/api/v1/score")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "3600" || !bytes.Contains(w.Body.Bytes(), []byte("QUOTA_EXCEEDED")) {
+		t.Fatalf("second call: %d retry=%q body=%s", w.Code, w.Header().Get("Retry-After"), w.Body.String())
+	}
+
+	w = send(http.MethodGet, "/api/v1/usage")
+	if w.Code != http.StatusOK {
+		t.Fatalf("usage: %d %s", w.Code, w.Body.String())
+	}
+	var body struct {
+		KeyName string `json:"key_name"`
+		Day     struct {
+			Usage usageCounts `json:"usage"`
+		} `json:"day"`
+		Limits quotaLimits `json:"limits"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode usage: %v", err)
+	}
+	if body.KeyName != "trial" || body.Day.Usage.Score != 1 || body.Limits.ScoreDaily != 1 {
+		t.Fatalf("unexpected usage %s", w.Body.String())
+	}
+}
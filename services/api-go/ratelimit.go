@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// rateSpec is a token-bucket limit: Rate tokens per second, up to Burst.
+type rateSpec struct {
+	Rate  float64
+	Burst float64
+}
+
+// parseRateSpec reads "N/s", "N/m" or "N/h", optionally followed by ":burst".
+// The burst defaults to N.
+func parseRateSpec(v string) (rateSpec, error) {
+	v = strings.TrimSpace(v)
+	rate, burst, hasBurst := strings.Cut(v, ":")
+	count, unit, ok := strings.Cut(rate, "/")
+	if !ok {
+		return rateSpec{}, fmt.Errorf("rate %q: want N/s, N/m or N/h", v)
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(count), 64)
+	if err != nil || n <= 0 {
+		return rateSpec{}, fmt.Errorf("rate %q: bad count", v)
+	}
+	var per time.Duration
+	switch strings.TrimSpace(unit) {
+	case "s":
+		per = time.Second
+	case "m":
+		per = time.Minute
+	case "h":
+		per = time.Hour
+	default:
+		return rateSpec{}, fmt.Errorf("rate %q: unknown unit", v)
+	}
+	spec := rateSpec{Rate: n / per.Seconds(), Burst: n}
+	if hasBurst {
+		b, err := strconv.ParseFloat(strings.TrimSpace(burst), 64)
+		if err != nil || b < 1 {
+			return rateSpec{}, fmt.Errorf("rate %q: bad burst", v)
+		}
+		spec.Burst = b
+	}
+	return spec, nil
+}
+
+// rateLimitsFor returns the per-key and global limits for a route class
+// ("score" or "explain") from RATE_LIMIT_<CLASS>_PER_KEY / _GLOBAL.
+func rateLimitsFor(class string) (perKey, global *rateSpec) {
+	read := func(name string) *rateSpec {
+		v := os.Getenv(name)
+		if v == "" {
+			return nil
+		}
+		spec, err := parseRateSpec(v)
+		if err != nil {
+			log.Printf("ratelimit: ignoring %s: %v", name, err)
+			return nil
+		}
+		return &spec
+	}
+	prefix := "RATE_LIMIT_" + strings.ToUpper(class)
+	return read(prefix + "_PER_KEY"), read(prefix + "_GLOBAL")
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	spec   rateSpec
+}
+
+func (b *tokenBucket) refill(now time.Time, spec rateSpec) {
+	if b.spec != spec {
+		b.spec = spec
+		b.tokens = math.Min(b.tokens, spec.Burst)
+	}
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(spec.Burst, b.tokens+elapsed*spec.Rate)
+	}
+	b.last = now
+}
+
+// full reports whether b would be back at its burst by now, making it
+// indistinguishable from a new bucket.
+func (b *tokenBucket) full(now time.Time) bool {
+	return b.tokens+now.Sub(b.last).Seconds()*b.spec.Rate >= b.spec.Burst
+}
+
+// bucketSweepInterval is how often take drops buckets that have refilled.
+const bucketSweepInterval = time.Minute
+
+// rateLimiter holds token buckets keyed by "<class>|<key name>" or
+// "<class>|*" for the global bucket. now is injectable for tests.
+type rateLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	now       func() time.Time
+	lastSweep time.Time
+}
+
+var requestLimiter = newRateLimiter(time.Now)
+
+func newRateLimiter(now func() time.Time) *rateLimiter {
+	return &rateLimiter{buckets: map[string]*tokenBucket{}, now: now}
+}
+
+type rateDecision struct {
+	allowed    bool
+	limit      int
+	remaining  int
+	reset      time.Duration
+	retryAfter time.Duration
+}
+
+type bucketRequest struct {
+	key  string
+	spec rateSpec
+}
+
+// take consumes one token from every bucket if all have one available. The
+// decision reports the most constrained bucket, and Retry-After covers the
+// slowest of the buckets that denied.
+func (l *rateLimiter) take(reqs []bucketRequest) rateDecision {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	if now.Sub(l.lastSweep) >= bucketSweepInterval {
+		l.sweep(now)
+	}
+
+	buckets := make([]*tokenBucket, len(reqs))
+	allowed := true
+	for i, r := range reqs {
+		b, ok := l.buckets[r.key]
+		if !ok {
+			b = &tokenBucket{tokens: r.spec.Burst, last: now, spec: r.spec}
+			l.buckets[r.key] = b
+		}
+		b.refill(now, r.spec)
+		buckets[i] = b
+		if b.tokens < 1 {
+			allowed = false
+		}
+	}
+	if allowed {
+		for _, b := range buckets {
+			b.tokens--
+		}
+	}
+
+	d := rateDecision{allowed: allowed, remaining: math.MaxInt}
+	for _, b := range buckets {
+		if b.tokens < 1 {
+			d.retryAfter = max(d.retryAfter, secondsToDuration((1-b.tokens)/b.spec.Rate))
+		}
+		remaining := int(math.Floor(b.tokens))
+		if remaining >= d.remaining {
+			continue
+		}
+		d.remaining = remaining
+		d.limit = int(b.spec.Burst)
+		d.reset = secondsToDuration((b.spec.Burst - b.tokens) / b.spec.Rate)
+	}
+	return d
+}
+
+// sweep drops idle buckets that have refilled, so keys that stop calling do
+// not accumulate.
+func (l *rateLimiter) sweep(now time.Time) {
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if b.full(now) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+func secondsToDuration(s float64) time.Duration {
+	return time.Duration(math.Ceil(s)) * time.Second
+}
+
+// allowRate applies the configured limits for class to the caller, setting
+// RateLimit-* headers and answering 429 RATE_LIMITED when exhausted.
+func allowRate(c *gin.Context, class, name string) bool {
+	perKey, global := rateLimitsFor(class)
+	var reqs []bucketRequest
+	if perKey != nil {
+		reqs = append(reqs, bucketRequest{key: class + "|" + name, spec: *perKey})
+	}
+	if global != nil {
+		reqs = append(reqs, bucketRequest{key: class + "|*", spec: *global})
+	}
+	if len(reqs) == 0 {
+		return true
+	}
+
+	d := requestLimiter.take(reqs)
+	c.Header("RateLimit-Limit", strconv.Itoa(d.limit))
+	c.Header("RateLimit-Remaining", strconv.Itoa(d.remaining))
+	c.Header("RateLimit-Reset", strconv.Itoa(int(d.reset.Seconds())))
+	if d.allowed {
+		return true
+	}
+	c.Header("Retry-After", strconv.Itoa(int(d.retryAfter.Seconds())))
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": "rate limited", "reason_code": "RATE_LIMITED"})
+	annotateResult(c, "RATE_LIMITED", 0)
+	return false
+}
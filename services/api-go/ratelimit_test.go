@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseRateSpec(t *testing.T) {
+	cases := map[string]rateSpec{
+		"10/s":    {Rate: 10, Burst: 10},
+		"60/m:5":  {Rate: 1, Burst: 5},
+		"3600/h":  {Rate: 1, Burst: 3600},
+		" 2/s:4 ": {Rate: 2, Burst: 4},
+	}
+	for in, want := range cases {
+		got, err := parseRateSpec(in)
+		if err != nil || got != want {
+			t.Errorf("parseRateSpec(%q) = %+v, %v; want %+v", in, got, err, want)
+		}
+	}
+	for _, bad := range []string{"10", "x/s", "10/d", "10/s:0", "-1/s"} {
+		if _, err := parseRateSpec(bad); err == nil {
+			t.Errorf("parseRateSpec(%q): expected error", bad)
+		}
+	}
+}
+
+func TestRateLimiter_TokenBucket(t *testing.T) {
+	now := time.Unix(1000, 0)
+	l := newRateLimiter(func() time.Time { return now })
+	perKey := bucketRequest{key: "score|a", spec: rateSpec{Rate: 1, Burst: 2}}
+	global := bucketRequest{key: "score|*", spec: rateSpec{Rate: 10, Burst: 3}}
+
+	for i := 0; i < 2; i++ {
+		if d := l.take([]bucketRequest{perKey, global}); !d.allowed {
+			t.Fatalf("request %d denied", i)
+		}
+	}
+	d := l.take([]bucketRequest{perKey, global})
+	if d.allowed || d.remaining != 0 || d.limit != 2 || d.retryAfter != time.Second {
+		t.Fatalf("want per-key denial with 1s retry, got %+v", d)
+	}
+
+	// A denied request must not drain the global bucket.
+	other := bucketRequest{key: "score|b", spec: rateSpec{Rate: 1, Burst: 2}}
+	if d := l.take([]bucketRequest{other, global}); !d.allowed {
+		t.Fatalf("global bucket drained by denied request: %+v", d)
+	}
+	if d := l.take([]bucketRequest{other, global}); d.allowed {
+		t.Fatalf("global burst of 3 exceeded: %+v", d)
+	}
+
+	now = now.Add(1500 * time.Millisecond)
+	if d := l.take([]bucketRequest{perKey}); !d.allowed {
+		t.Fatalf("bucket did not refill: %+v", d)
+	}
+}
+
+func TestRateLimiter_RetryAfterCoversEveryDenial(t *testing.T) {
+	now := time.Unix(1000, 0)
+	l := newRateLimiter(func() time.Time { return now })
+	perKey := bucketRequest{key: "score|a", spec: rateSpec{Rate: 1, Burst: 1}}
+	global := bucketRequest{key: "score|*", spec: rateSpec{Rate: 0.1, Burst: 3}}
+
+	l.take([]bucketRequest{perKey, global})
+	l.take([]bucketRequest{{key: "score|b", spec: perKey.spec}, global})
+	l.take([]bucketRequest{{key: "score|c", spec: perKey.spec}, global})
+	d := l.take([]bucketRequest{perKey, global})
+	if d.allowed || d.retryAfter != 10*time.Second {
+		t.Fatalf("want the global bucket's 10s wait, got %+v", d)
+	}
+}
+
+func TestRateLimiter_SweepsRefilledBuckets(t *testing.T) {
+	now := time.Unix(1000, 0)
+	l := newRateLimiter(func() time.Time { return now })
+	fast := rateSpec{Rate: 1, Burst: 2}
+	slow := rateSpec{Rate: 0.001, Burst: 2}
+
+	l.take([]bucketRequest{{key: "score|idle", spec: fast}})
+	l.take([]bucketRequest{{key: "score|busy", spec: slow}})
+	now = now.Add(bucketSweepInterval)
+	l.take([]bucketRequest{{key: "score|other", spec: fast}})
+	if _, ok := l.buckets["score|idle"]; ok {
+		t.Fatalf("refilled idle bucket was not evicted")
+	}
+	if _, ok := l.buckets["score|busy"]; !ok {
+		t.Fatalf("bucket still below burst was evicted")
+	}
+}
+
+func TestScoreHandler_RateLimited(t *testing.T) {
+	now := time.Unix(2000, 0)
+	prev := requestLimiter
+	requestLimiter = newRateLimiter(func() time.Time { return now })
+	t.Cleanup(func() { requestLimiter = prev })
+
+	ml := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"score":70,"symmetry":0.7,"power":0.7,"consistency":0.7}`))
+	}))
+	defer ml.Close()
+	t.Setenv("API_KEY", "secret")
+	t.Setenv("API_ML_URL", ml.URL)
+	t.Setenv("RATE_LIMIT_SCORE_PER_KEY", "60/m:1")
+
+	r := newRouter()
+	send := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/score", bytes.NewBufferString(`{}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-API-Key", "secret")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := send(); w.Code != http.StatusOK || w.Header().Get("RateLimit-Remaining") != "0" {
+		t.Fatalf("first call: %d remaining=%q", w.Code, w.Header().Get("RateLimit-Remaining"))
+	}
+	w := send()
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "1" || !bytes.Contains(w.Body.Bytes(), []byte("RATE_LIMITED")) {
+		t.Fatalf("second call: %d retry=%q body=%s", w.Code, w.Header().Get("Retry-After"), w.Body.String())
+	}
+	now = now.Add(time.Second)
+	if w := send(); w.Code != http.StatusOK {
+		t.Fatalf("after refill: %d", w.Code)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// requestIDPattern is the policy for client-supplied X-Request-Id values.
+// Anything else is replaced and kept only as client_req_id.
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+const maxClientRequestIDLen = 128
+
+var uuidV7State struct {
+	mu     sync.Mutex
+	lastMs int64
+	seq    uint16
+}
+
+// newRequestID returns a UUIDv7: 48 bits of Unix milliseconds, then a 12-bit
+// counter that keeps IDs from one process ordered within a millisecond, then
+// 62 random bits.
+func newRequestID() string {
+	ms := time.Now().UnixMilli()
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+
+	uuidV7State.mu.Lock()
+	if ms <= uuidV7State.lastMs {
+		ms = uuidV7State.lastMs
+		uuidV7State.seq++
+		if uuidV7State.seq > 0x0fff {
+			ms++
+			uuidV7State.seq = 0
+		}
+	} else {
+		uuidV7State.seq = binary.BigEndian.Uint16(b[6:8]) & 0x07ff
+	}
+	uuidV7State.lastMs = ms
+	seq := uuidV7State.seq
+	uuidV7State.mu.Unlock()
+
+	b[0], b[1], b[2], b[3], b[4], b[5] = byte(ms>>40), byte(ms>>32), byte(ms>>24), byte(ms>>16), byte(ms>>8), byte(ms)
+	b[6] = 0x70 | byte(seq>>8)
+	b[7] = byte(seq)
+	b[8] = 0x80 | b[8]&0x3f
+
+	h := hex.EncodeToString(b[:])
+	return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:32]
+}
+
+// resolveRequestID accepts a valid client ID as is. An invalid one is
+// replaced, and returned truncated and UTF-8-sanitised as clientID so it can
+// still be correlated in logs.
+func resolveRequestID(header string) (id, clientID string) {
+	header = strings.TrimSpace(header)
+	if requestIDPattern.MatchString(header) {
+		return header, ""
+	}
+	if header != "" {
+		if len(header) > maxClientRequestIDLen {
+			header = header[:maxClientRequestIDLen]
+		}
+		clientID = strings.ToValidUTF8(header, "")
+	}
+	return newRequestID(), clientID
+}
+
+type requestIDKey struct{}
+
+type requestIDs struct {
+	id, clientID string
+}
+
+// withRequestID resolves the request's ID once, rewrites X-Request-Id on the
+// request so handlers and upstream calls see the same value, and stores both
+// IDs in the context.
+func withRequestID(r *http.Request) (*http.Request, requestIDs) {
+	if ids, ok := r.Context().Value(requestIDKey{}).(requestIDs); ok {
+		return r, ids
+	}
+	var ids requestIDs
+	ids.id, ids.clientID = resolveRequestID(r.Header.Get("X-Request-Id"))
+	r.Header.Set("X-Request-Id", ids.id)
+	return r.WithContext(context.WithValue(r.Context(), requestIDKey{}, ids)), ids
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var uuidV7Pattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+func TestNewRequestID_UUIDv7SortedAndUnique(t *testing.T) {
+	seen := map[string]bool{}
+	prev := ""
+	for i := 0; i < 5000; i++ {
+		id := newRequestID()
+		if !uuidV7Pattern.MatchString(id) {
+			t.Fatalf("not a UUIDv7: %s", id)
+		}
+		if seen[id] || id <= prev {
+			t.Fatalf("id %s not unique and increasing after %s", id, prev)
+		}
+		seen[id], prev = true, id
+	}
+}
+
+func TestResolveRequestID(t *testing.T) {
+	if id, client := resolveRequestID("trace-123:abc"); id != "trace-123:abc" || client != "" {
+		t.Fatalf("valid id replaced: %s %s", id, client)
+	}
+	for _, bad := range []string{"has space", "<script>", strings.Repeat("a", 200)} {
+		id, client := resolveRequestID(bad)
+		if !uuidV7Pattern.MatchString(id) || client == "" || len(client) > maxClientRequestIDLen {
+			t.Errorf("resolveRequestID(%q) = %q, %q", bad, id, client)
+		}
+	}
+	if id, client := resolveRequestID(""); !uuidV7Pattern.MatchString(id) || client != "" {
+		t.Fatalf("empty header: %s %q", id, client)
+	}
+}
+
+func TestOTSMiddleware_SharesRequestIDWithHandlers(t *testing.T) {
+	setupExplainTest(t)
+	h := OTSMiddleware("run-test", newRouter())
+
+	var w *httptest.ResponseRecorder
+	out := captureStdout(t, func() {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/score", bytes.NewBufferString(`{}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Request-Id", "bad id\n")
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+	})
+
+	id := w.Header().Get("X-Request-Id")
+	if !uuidV7Pattern.MatchString(id) {
+		t.Fatalf("response id %q is not generated", id)
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("want exactly one OTS line, got %d:\n%s", len(lines), out)
+	}
+	for _, line := range lines {
+		var rec map[string]any
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatalf("bad line %q: %v", line, err)
+		}
+		if rec["req_id"] != id || rec["client_req_id"] != "bad id" {
+			t.Fatalf("line does not share request id: %s", line)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package reqsign
+
+import (
+	"bytes"
+	"io"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSign_RoundTrip(t *testing.T) {
+	secret := []byte("s3cret")
+	req := httptest.NewRequest("POST", "/api/v1/score?debug=1", bytes.NewBufferString(`{"a":1}`))
+	if err := Sign(req, "batch", secret, time.Unix(1767225600, 0)); err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	body, _ := io.ReadAll(req.Body)
+	if string(body) != `{"a":1}` {
+		t.Fatalf("body not restored: %q", body)
+	}
+	if req.Header.Get(HeaderKey) != "batch" || req.Header.Get(HeaderTimestamp) != "1767225600" || len(req.Header.Get(HeaderNonce)) != 32 {
+		t.Fatalf("unexpected headers %v", req.Header)
+	}
+
+	sts := StringToSign("POST", "/api/v1/score?debug=1", "1767225600", req.Header.Get(HeaderNonce), BodyHash(body))
+	if !Verify(secret, sts, req.Header.Get(HeaderSignature)) {
+		t.Fatal("signature did not verify")
+	}
+	if Verify([]byte("other"), sts, req.Header.Get(HeaderSignature)) {
+		t.Fatal("signature verified under wrong secret")
+	}
+	if Verify(secret, StringToSign("POST", "/api/v1/score?debug=1", "1767225600", req.Header.Get(HeaderNonce), BodyHash([]byte("{}"))), req.Header.Get(HeaderSignature)) {
+		t.Fatal("signature verified for a different body")
+	}
+}
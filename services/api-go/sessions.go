@@ -0,0 +1,162 @@
+package main
+
+import (
+	"container/list"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// sessionMetrics are the four numbers the ML service returns for one scored
+// session. They are the only data kept per session; keypoints are never stored.
+type sessionMetrics struct {
+	Score       float64 `json:"score"`
+	Symmetry    float64 `json:"symmetry"`
+	Power       float64 `json:"power"`
+	Consistency float64 `json:"consistency"`
+}
+
+func (m sessionMetrics) metrics() []float64 {
+	return []float64{m.Score, m.Symmetry, m.Power, m.Consistency}
+}
+
+type scoredSession struct {
+	ID        string    `json:"session_id"`
+	SubjectID string    `json:"subject_id,omitempty"`
+	ScoredAt  time.Time `json:"scored_at"`
+	sessionMetrics
+}
+
+var subjectIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:@-]{1,128}$`)
+
+// sessionStore keeps the most recent scored sessions in memory, evicting the
+// oldest once it holds SESSION_STORE_SIZE entries.
+type sessionStore struct {
+	mu        sync.Mutex
+	order     *list.List
+	byID      map[string]*list.Element
+	bySubject map[string][]string
+	now       func() time.Time
+}
+
+var scoredSessions = newSessionStore()
+
+func newSessionStore() *sessionStore {
+	return &sessionStore{
+		order:     list.New(),
+		byID:      map[string]*list.Element{},
+		bySubject: map[string][]string{},
+		now:       time.Now,
+	}
+}
+
+func sessionStoreSize() int {
+	if v := os.Getenv("SESSION_STORE_SIZE"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			return n
+		}
+	}
+	return 10000
+}
+
+func newSessionID() string {
+	var b [12]byte
+	_, _ = rand.Read(b[:])
+	return "s_" + hex.EncodeToString(b[:])
+}
+
+func (s *sessionStore) add(subjectID string, m sessionMetrics) scoredSession {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sess := scoredSession{ID: newSessionID(), SubjectID: subjectID, ScoredAt: s.now().UTC(), sessionMetrics: m}
+	s.byID[sess.ID] = s.order.PushBack(sess)
+	if subjectID != "" {
+		s.bySubject[subjectID] = append(s.bySubject[subjectID], sess.ID)
+	}
+
+	for s.order.Len() > sessionStoreSize() {
+		oldest := s.order.Front()
+		old := s.order.Remove(oldest).(scoredSession)
+		delete(s.byID, old.ID)
+		if ids := s.bySubject[old.SubjectID]; len(ids) > 0 && ids[0] == old.ID {
+			if len(ids) == 1 {
+				delete(s.bySubject, old.SubjectID)
+			} else {
+				s.bySubject[old.SubjectID] = ids[1:]
+			}
+		}
+	}
+	return sess
+}
+
+func (s *sessionStore) get(id string) (scoredSession, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	el, ok := s.byID[id]
+	if !ok {
+		return scoredSession{}, false
+	}
+	return el.Value.(scoredSession), true
+}
+
+// forSubject returns the subject's stored sessions, oldest first.
+func (s *sessionStore) forSubject(subjectID string) []scoredSession {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := s.bySubject[subjectID]
+	out := make([]scoredSession, 0, len(ids))
+	for _, id := range ids {
+		if el, ok := s.byID[id]; ok {
+			out = append(out, el.Value.(scoredSession))
+		}
+	}
+	return out
+}
+
+// subjectFromHeader reads the optional X-Subject-Id header. An invalid value
+// is rejected rather than silently dropped. Token-authenticated callers are
+// always their own subject.
+func subjectFromHeader(c *gin.Context) (string, bool) {
+	subjectID := strings.TrimSpace(c.GetHeader("X-Subject-Id"))
+	if subjectID != "" && !subjectIDPattern.MatchString(subjectID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid subject id", "reason_code": "INVALID_SUBJECT_ID"})
+		annotateResult(c, "INVALID_SUBJECT_ID", 0)
+		return "", false
+	}
+	return pinnedSubject(c, subjectID)
+}
+
+// pinnedSubject enforces that a principal with a Subject only names itself;
+// an empty requested subject defaults to the principal's.
+func pinnedSubject(c *gin.Context, requested string) (string, bool) {
+	p, _ := currentPrincipal(c)
+	if p.Subject == "" {
+		return requested, true
+	}
+	if requested != "" && requested != p.Subject {
+		c.JSON(http.StatusForbidden, gin.H{"error": "subject does not match token", "reason_code": "SUBJECT_MISMATCH"})
+		annotateResult(c, "SUBJECT_MISMATCH", 0)
+		return "", false
+	}
+	return p.Subject, true
+}
+
+// recordScoredSession stores the metrics from a successful ML response and
+// returns the new session ID.
+func recordScoredSession(subjectID string, mlBody []byte) (string, bool) {
+	var m sessionMetrics
+	if err := json.Unmarshal(mlBody, &m); err != nil {
+		return "", false
+	}
+	return scoredSessions.add(subjectID, m).ID, true
+}
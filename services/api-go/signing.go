@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"picca/api-go/reqsign"
+
+	"github.com/gin-gonic/gin"
+)
+
+func signatureMaxSkew() time.Duration {
+	if v := os.Getenv("SIGNATURE_MAX_SKEW"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			return d
+		}
+	}
+	return 5 * time.Minute
+}
+
+func nonceCacheSize() int {
+	if v := os.Getenv("SIGNATURE_NONCE_CACHE_SIZE"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			return n
+		}
+	}
+	return 100000
+}
+
+// nonceCache remembers nonces until they fall outside the skew window, after
+// which the timestamp check alone rejects a replay. When full it evicts the
+// oldest entry.
+type nonceCache struct {
+	mu    sync.Mutex
+	order *list.List
+	seen  map[string]*list.Element
+	now   func() time.Time
+}
+
+type nonceEntry struct {
+	key   string
+	until time.Time
+}
+
+var usedNonces = newNonceCache(time.Now)
+
+func newNonceCache(now func() time.Time) *nonceCache {
+	return &nonceCache{order: list.New(), seen: map[string]*list.Element{}, now: now}
+}
+
+// remember records key and reports false if it was already seen.
+func (n *nonceCache) remember(key string, ttl time.Duration) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	now := n.now()
+	for e := n.order.Front(); e != nil; e = n.order.Front() {
+		if ent := e.Value.(nonceEntry); now.Before(ent.until) {
+			break
+		}
+		n.order.Remove(e)
+		delete(n.seen, e.Value.(nonceEntry).key)
+	}
+	if _, ok := n.seen[key]; ok {
+		return false
+	}
+	for n.order.Len() >= nonceCacheSize() {
+		e := n.order.Front()
+		n.order.Remove(e)
+		delete(n.seen, e.Value.(nonceEntry).key)
+	}
+	n.seen[key] = n.order.PushBack(nonceEntry{key: key, until: now.Add(ttl)})
+	return true
+}
+
+// signingCandidates returns the active entry named name and any retired one
+// still in grace, so a rotated signing secret keeps working until it ends.
+func (s *keyStore) signingCandidates(name string, now time.Time) []apiKeyEntry {
+	var out []apiKeyEntry
+	for _, k := range s.keys {
+		if k.Name == name && k.SigningSecret != "" {
+			out = append(out, k)
+		}
+	}
+	for _, r := range s.retired {
+		if r.entry.Name == name && r.entry.SigningSecret != "" && now.Before(r.until) {
+			out = append(out, r.entry)
+		}
+	}
+	return out
+}
+
+// authenticateSigned verifies an HMAC-signed request (see package reqsign).
+// The body is buffered for hashing and restored for the handler.
+func authenticateSigned(c *gin.Context) (principal, bool) {
+	store, err := currentKeyStore()
+	if err != nil {
+		return principal{}, denyAuth(c, http.StatusInternalServerError, "server misconfigured", "MISCONFIGURED_API_KEY")
+	}
+	name := c.GetHeader(reqsign.HeaderKey)
+	ts := c.GetHeader(reqsign.HeaderTimestamp)
+	nonce := c.GetHeader(reqsign.HeaderNonce)
+	if name == "" || ts == "" || nonce == "" || len(nonce) > 128 {
+		return principal{}, denyAuth(c, http.StatusUnauthorized, "missing signing headers", "INVALID_SIGNATURE")
+	}
+
+	now := usedNonces.now()
+	skew := signatureMaxSkew()
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return principal{}, denyAuth(c, http.StatusUnauthorized, "invalid timestamp", "INVALID_SIGNATURE")
+	}
+	if d := now.Sub(time.Unix(sec, 0)); d > skew || d < -skew {
+		return principal{}, denyAuth(c, http.StatusUnauthorized, "timestamp outside allowed skew", "STALE_REQUEST")
+	}
+
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxBodyBytes()+1))
+	if err != nil {
+		return principal{}, denyAuth(c, http.StatusBadRequest, "invalid body", "INVALID_BODY")
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	sts := reqsign.StringToSign(c.Request.Method, c.Request.URL.RequestURI(), ts, nonce, reqsign.BodyHash(body))
+	var (
+		key     apiKeyEntry
+		matched bool
+	)
+	for _, k := range store.signingCandidates(name, now) {
+		if reqsign.Verify([]byte(k.SigningSecret), sts, c.GetHeader(reqsign.HeaderSignature)) {
+			key, matched = k, true
+			break
+		}
+	}
+	if !matched {
+		return principal{}, denyAuth(c, http.StatusUnauthorized, "signature mismatch", "INVALID_SIGNATURE")
+	}
+	if err := key.usable(now); err != nil {
+		return principal{}, denyKeyError(c, err)
+	}
+	// Only a verified nonce is recorded, so forged requests cannot burn it.
+	if !usedNonces.remember(name+"|"+nonce, 2*skew) {
+		return principal{}, denyAuth(c, http.StatusUnauthorized, "nonce already used", "STALE_REQUEST")
+	}
+	return key.principal(), true
+}
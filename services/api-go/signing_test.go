@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"picca/api-go/reqsign"
+)
+
+func TestSignedRequests(t *testing.T) {
+	now := time.Unix(1767225600, 0)
+	prev := usedNonces
+	usedNonces = newNonceCache(func() time.Time { return now })
+	t.Cleanup(func() { usedNonces = prev })
+
+	ml := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"score":70,"symmetry":0.7,"power":0.7,"consistency":0.7}`))
+	}))
+	defer ml.Close()
+	t.Setenv("API_ML_URL", ml.URL)
+	writeKeyFile(t, `{"keys":[
+		{"name":"batch","key":"batch-key","signing_secret":"hmac-secret","require_signature":true,"scopes":["score"]},
+		{"name":"etl","signing_secret":"etl-secret","scopes":["score"]}
+	]}`)
+
+	r := newRouter()
+	signed := func(name, secret string, at time.Time) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/score", bytes.NewBufferString(`{"keypoints":[]}`))
+		req.Header.Set("Content-Type", "application/json")
+		if err := reqsign.Sign(req, name, []byte(secret), at); err != nil {
+			t.Fatalf("sign: %v", err)
+		}
+		return req
+	}
+	do := func(req *http.Request) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	expect := func(label string, w *httptest.ResponseRecorder, code int, reason string) {
+		t.Helper()
+		if w.Code != code || (reason != "" && !bytes.Contains(w.Body.Bytes(), []byte(reason))) {
+			t.Fatalf("%s: want %d %s, got %d %s", label, code, reason, w.Code, w.Body.String())
+		}
+	}
+
+	req := signed("batch", "hmac-secret", now)
+	expect("signed", do(req), http.StatusOK, "")
+
+	replay := signed("batch", "hmac-secret", now)
+	replay.Header.Set(reqsign.HeaderNonce, req.Header.Get(reqsign.HeaderNonce))
+	replay.Header.Set(reqsign.HeaderSignature, req.Header.Get(reqsign.HeaderSignature))
+	expect("replay", do(replay), http.StatusUnauthorized, "STALE_REQUEST")
+
+	expect("skew", do(signed("batch", "hmac-secret", now.Add(-10*time.Minute))), http.StatusUnauthorized, "STALE_REQUEST")
+	expect("wrong secret", do(signed("batch", "guess", now)), http.StatusUnauthorized, "INVALID_SIGNATURE")
+	expect("sign-only key", do(signed("etl", "etl-secret", now)), http.StatusOK, "")
+
+	tampered := signed("etl", "etl-secret", now)
+	tampered.Body = httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(`{"keypoints":[1]}`)).Body
+	expect("tampered body", do(tampered), http.StatusUnauthorized, "INVALID_SIGNATURE")
+
+	plain := httptest.NewRequest(http.MethodPost, "/api/v1/score", bytes.NewBufferString(`{}`))
+	plain.Header.Set("Content-Type", "application/json")
+	plain.Header.Set("X-API-Key", "batch-key")
+	expect("plain key", do(plain), http.StatusUnauthorized, "SIGNATURE_REQUIRED")
+}
@@ -0,0 +1,431 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// sloFile mirrors ops/lachesis/slo.yaml.
+type sloFile struct {
+	Service string                     `yaml:"service"`
+	Metrics map[string]sloMetricConfig `yaml:"metrics"`
+}
+
+type sloMetricConfig struct {
+	Target *float64 `yaml:"target"`
+	Window string   `yaml:"window"`
+}
+
+// sloConfig holds the objectives the gateway can evaluate itself: p95 latency
+// and success ratio. Cost is rolled up offline and ignored here.
+type sloConfig struct {
+	Service        string
+	P95Ms          float64
+	LatencyWindow  time.Duration
+	Success        float64
+	SuccessWindow  time.Duration
+	BurnWindows    []time.Duration
+	bucketDuration time.Duration
+}
+
+// parseSLOWindow accepts "rolling 15m", "15m", "1h" or "1d".
+func parseSLOWindow(s string) (time.Duration, error) {
+	s = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(s), "rolling"))
+	if strings.HasSuffix(s, "d") {
+		var days int
+		if _, err := fmt.Sscanf(s, "%dd", &days); err != nil || days <= 0 {
+			return 0, fmt.Errorf("invalid window %q", s)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid window %q", s)
+	}
+	return d, nil
+}
+
+func parseSLOFile(raw []byte) (sloConfig, error) {
+	var f sloFile
+	if err := yaml.Unmarshal(raw, &f); err != nil {
+		return sloConfig{}, fmt.Errorf("parse slo file: %w", err)
+	}
+	cfg := sloConfig{Service: f.Service, bucketDuration: 10 * time.Second}
+	for name, dst := range map[string]struct {
+		target *float64
+		window *time.Duration
+	}{
+		"p95_ms":  {&cfg.P95Ms, &cfg.LatencyWindow},
+		"success": {&cfg.Success, &cfg.SuccessWindow},
+	} {
+		m, ok := f.Metrics[name]
+		if !ok || m.Target == nil {
+			return sloConfig{}, fmt.Errorf("slo file: metrics.%s.target is required", name)
+		}
+		w, err := parseSLOWindow(m.Window)
+		if err != nil {
+			return sloConfig{}, fmt.Errorf("slo file: metrics.%s: %w", name, err)
+		}
+		*dst.target, *dst.window = *m.Target, w
+	}
+	if cfg.Success <= 0 || cfg.Success >= 1 {
+		return sloConfig{}, fmt.Errorf("slo file: success target must be in (0,1), got %v", cfg.Success)
+	}
+	// Burn rates over a short, a medium and the full SLO window.
+	long := cfg.SuccessWindow
+	if cfg.LatencyWindow > long {
+		long = cfg.LatencyWindow
+	}
+	for _, w := range []time.Duration{long / 15, long / 3, long} {
+		if w < cfg.bucketDuration {
+			w = cfg.bucketDuration
+		}
+		cfg.BurnWindows = append(cfg.BurnWindows, w.Round(cfg.bucketDuration))
+	}
+	return cfg, nil
+}
+
+// sloFilePaths is SLO_FILE, or the repo layout seen from the container
+// (/app/ops/...) and from services/api-go during local runs.
+func sloFilePaths() []string {
+	if p := strings.TrimSpace(os.Getenv("SLO_FILE")); p != "" {
+		return []string{p}
+	}
+	return []string{"ops/lachesis/slo.yaml", "../../ops/lachesis/slo.yaml"}
+}
+
+// defaultSLOFile is a copy of ops/lachesis/slo.yaml, used when the image was
+// built without the repo's ops directory and SLO_FILE is unset.
+//
+//go:embed slo.default.yaml
+var defaultSLOFile []byte
+
+func loadSLOConfig() (sloConfig, string, error) {
+	var lastErr error
+	for _, p := range sloFilePaths() {
+		raw, err := os.ReadFile(p)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		cfg, err := parseSLOFile(raw)
+		return cfg, p, err
+	}
+	if strings.TrimSpace(os.Getenv("SLO_FILE")) != "" {
+		return sloConfig{}, "", lastErr
+	}
+	cfg, err := parseSLOFile(defaultSLOFile)
+	return cfg, "embedded slo.default.yaml", err
+}
+
+// sloLatencyBounds are histogram bucket upper bounds in ms, growing by 10%
+// from 1ms to about 60s, fine enough to estimate p95 within a few percent.
+var sloLatencyBounds = func() []float64 {
+	var b []float64
+	for v := 1.0; v < 60000; v *= 1.1 {
+		b = append(b, v)
+	}
+	return append(b, math.Inf(1))
+}()
+
+type sloBucket struct {
+	start     time.Time
+	total     int64
+	failures  int64
+	latencies []int64
+}
+
+// sloTracker keeps per-route time buckets covering the longest window. It
+// collects over 15m even before an SLO file is loaded so the ops dashboard
+// always has rolling stats; objectives are only evaluated once loaded.
+type sloTracker struct {
+	mu     sync.Mutex
+	cfg    sloConfig
+	loaded bool
+	source string
+	routes map[string][]*sloBucket
+	now    func() time.Time
+}
+
+var sloStats = newSLOTracker(time.Now)
+
+func newSLOTracker(now func() time.Time) *sloTracker {
+	cfg := sloConfig{LatencyWindow: 15 * time.Minute, SuccessWindow: 15 * time.Minute, bucketDuration: 10 * time.Second}
+	return &sloTracker{cfg: cfg, routes: map[string][]*sloBucket{}, now: now}
+}
+
+func (t *sloTracker) configure(cfg sloConfig, source string) {
+	t.mu.Lock()
+	t.cfg, t.source, t.loaded = cfg, source, true
+	t.routes = map[string][]*sloBucket{}
+	t.mu.Unlock()
+}
+
+func (t *sloTracker) horizon() time.Duration {
+	h := t.cfg.SuccessWindow
+	if t.cfg.LatencyWindow > h {
+		h = t.cfg.LatencyWindow
+	}
+	return h
+}
+
+// sloUnmatchedRoute is the route recorded for requests no handler matched.
+const sloUnmatchedRoute = "unmatched"
+
+// observe records one request. 5xx responses count against the success
+// objective; client errors do not. Unmatched paths are tracked on their own
+// but kept out of the "*" aggregate.
+func (t *sloTracker) observe(route string, status int, latency time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	start := now.Truncate(t.cfg.bucketDuration)
+	ms := float64(latency) / float64(time.Millisecond)
+	idx := sort.SearchFloat64s(sloLatencyBounds, ms)
+	routes := []string{route, "*"}
+	if route == sloUnmatchedRoute {
+		routes = routes[:1]
+	}
+	for _, r := range routes {
+		buckets := t.routes[r]
+		if n := len(buckets); n == 0 || !buckets[n-1].start.Equal(start) {
+			buckets = append(buckets, &sloBucket{start: start, latencies: make([]int64, len(sloLatencyBounds))})
+		}
+		cutoff := now.Add(-t.horizon() - t.cfg.bucketDuration)
+		for len(buckets) > 0 && buckets[0].start.Before(cutoff) {
+			buckets = buckets[1:]
+		}
+		b := buckets[len(buckets)-1]
+		b.total++
+		if status >= 500 {
+			b.failures++
+		}
+		b.latencies[idx]++
+		t.routes[r] = buckets
+	}
+}
+
+type windowStats struct {
+	total, failures int64
+	latencies       []int64
+}
+
+func (t *sloTracker) window(route string, w time.Duration) windowStats {
+	ws := windowStats{latencies: make([]int64, len(sloLatencyBounds))}
+	from := t.now().Add(-w)
+	for _, b := range t.routes[route] {
+		if b.start.Add(t.cfg.bucketDuration).After(from) {
+			ws.total += b.total
+			ws.failures += b.failures
+			for i, n := range b.latencies {
+				ws.latencies[i] += n
+			}
+		}
+	}
+	return ws
+}
+
+// quantileMs returns the upper bound of the bucket holding quantile q.
+func (ws windowStats) quantileMs(q float64) float64 {
+	if ws.total == 0 {
+		return 0
+	}
+	rank := int64(math.Ceil(q * float64(ws.total)))
+	var seen int64
+	for i, n := range ws.latencies {
+		if seen += n; seen >= rank {
+			if math.IsInf(sloLatencyBounds[i], 1) {
+				return sloLatencyBounds[i-1]
+			}
+			return math.Round(sloLatencyBounds[i]*100) / 100
+		}
+	}
+	return 0
+}
+
+// slowerThan counts requests in buckets entirely above ms.
+func (ws windowStats) slowerThan(ms float64) int64 {
+	var n int64
+	for i, c := range ws.latencies {
+		if i > 0 && sloLatencyBounds[i-1] >= ms {
+			n += c
+		}
+	}
+	return n
+}
+
+type burnRate struct {
+	Window      string   `json:"window"`
+	Requests    int64    `json:"requests"`
+	SuccessBurn *float64 `json:"success_burn_rate"`
+	LatencyBurn *float64 `json:"latency_burn_rate"`
+}
+
+type routeSLO struct {
+	Route                  string     `json:"route"`
+	Requests               int64      `json:"requests"`
+	Success                *float64   `json:"success"`
+	P95Ms                  *float64   `json:"p95_ms"`
+	SuccessCompliant       bool       `json:"success_compliant"`
+	LatencyCompliant       bool       `json:"latency_compliant"`
+	SuccessBudgetRemaining *float64   `json:"success_error_budget_remaining"`
+	LatencyBudgetRemaining *float64   `json:"latency_error_budget_remaining"`
+	BurnRates              []burnRate `json:"burn_rates"`
+}
+
+func ratio(num, den int64) *float64 {
+	if den == 0 {
+		return nil
+	}
+	v := round4(float64(num) / float64(den))
+	return &v
+}
+
+func round4(v float64) float64 { return math.Round(v*1e4) / 1e4 }
+
+// evaluate computes SLIs for route. The latency budget allows 5% of requests
+// above the p95 target; burn rate 1 spends a budget exactly over the window.
+func (t *sloTracker) evaluate(route string) routeSLO {
+	allowedErrors := 1 - t.cfg.Success
+	const allowedSlow = 0.05
+
+	succ := t.window(route, t.cfg.SuccessWindow)
+	lat := t.window(route, t.cfg.LatencyWindow)
+	out := routeSLO{Route: route, Requests: succ.total, SuccessCompliant: true, LatencyCompliant: true}
+	if succ.total > 0 {
+		s := round4(1 - float64(succ.failures)/float64(succ.total))
+		remaining := round4(1 - (float64(succ.failures)/float64(succ.total))/allowedErrors)
+		out.Success, out.SuccessBudgetRemaining = &s, &remaining
+		out.SuccessCompliant = s >= t.cfg.Success
+	}
+	if lat.total > 0 {
+		p95 := lat.quantileMs(0.95)
+		remaining := round4(1 - (float64(lat.slowerThan(t.cfg.P95Ms))/float64(lat.total))/allowedSlow)
+		out.P95Ms, out.LatencyBudgetRemaining = &p95, &remaining
+		out.LatencyCompliant = p95 <= t.cfg.P95Ms
+	}
+	for _, w := range t.cfg.BurnWindows {
+		ws := t.window(route, w)
+		br := burnRate{Window: w.String(), Requests: ws.total}
+		if ws.total > 0 {
+			sb := round4(float64(ws.failures) / float64(ws.total) / allowedErrors)
+			lb := round4(float64(ws.slowerThan(t.cfg.P95Ms)) / float64(ws.total) / allowedSlow)
+			br.SuccessBurn, br.LatencyBurn = &sb, &lb
+		}
+		out.BurnRates = append(out.BurnRates, br)
+	}
+	return out
+}
+
+type rollingStat struct {
+	Route    string   `json:"route"`
+	Window   string   `json:"window"`
+	Requests int64    `json:"requests"`
+	Success  *float64 `json:"success"`
+	P95Ms    *float64 `json:"p95_ms"`
+}
+
+// rolling returns per-route request count, success ratio and p95 over the
+// latency window, busiest route first, with the "*" aggregate last.
+func (t *sloTracker) rolling() []rollingStat {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := make([]rollingStat, 0, len(t.routes))
+	var overall rollingStat
+	for r := range t.routes {
+		ws := t.window(r, t.cfg.LatencyWindow)
+		st := rollingStat{Route: r, Window: t.cfg.LatencyWindow.String(), Requests: ws.total}
+		if ws.total > 0 {
+			p95 := ws.quantileMs(0.95)
+			st.Success, st.P95Ms = ratio(ws.total-ws.failures, ws.total), &p95
+		}
+		if r == "*" {
+			overall = st
+			continue
+		}
+		out = append(out, st)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Requests != out[j].Requests {
+			return out[i].Requests > out[j].Requests
+		}
+		return out[i].Route < out[j].Route
+	})
+	if overall.Route != "" {
+		out = append(out, overall)
+	}
+	return out
+}
+
+func (t *sloTracker) report() map[string]any {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	routes := make([]string, 0, len(t.routes))
+	for r := range t.routes {
+		if r != "*" {
+			routes = append(routes, r)
+		}
+	}
+	sort.Strings(routes)
+	perRoute := make([]routeSLO, 0, len(routes))
+	for _, r := range routes {
+		perRoute = append(perRoute, t.evaluate(r))
+	}
+	return map[string]any{
+		"service": t.cfg.Service,
+		"run_id":  runID,
+		"source":  t.source,
+		"objectives": map[string]any{
+			"p95_ms":         t.cfg.P95Ms,
+			"latency_window": t.cfg.LatencyWindow.String(),
+			"success":        t.cfg.Success,
+			"success_window": t.cfg.SuccessWindow.String(),
+		},
+		"overall": t.evaluate("*"),
+		"routes":  perRoute,
+	}
+}
+
+// startSLOEvaluator loads the SLO file; if it cannot be read or parsed,
+// /ops/slo reports 503.
+func startSLOEvaluator() {
+	cfg, path, err := loadSLOConfig()
+	if err != nil {
+		log.Printf("slo: evaluator disabled: %v", err)
+		return
+	}
+	sloStats.configure(cfg, path)
+	log.Printf("slo: loaded %s (p95 %.0fms / %s, success %.3f / %s)", path, cfg.P95Ms, cfg.LatencyWindow, cfg.Success, cfg.SuccessWindow)
+}
+
+// sloHandler serves GET /ops/slo.
+func sloHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !opsAuthorized(r) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		sloStats.mu.Lock()
+		loaded := sloStats.loaded
+		sloStats.mu.Unlock()
+		if !loaded {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "slo file not loaded", "reason_code": "SLO_NOT_CONFIGURED"})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(sloStats.report())
+	})
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestParseSLOFile_RepoDeclaration(t *testing.T) {
+	raw, err := os.ReadFile("../../ops/lachesis/slo.yaml")
+	if err != nil {
+		t.Fatalf("read slo.yaml: %v", err)
+	}
+	cfg, err := parseSLOFile(raw)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if cfg.P95Ms != 500 || cfg.Success != 0.99 || cfg.LatencyWindow != 15*time.Minute || cfg.SuccessWindow != 15*time.Minute {
+		t.Fatalf("unexpected config %+v", cfg)
+	}
+	if len(cfg.BurnWindows) != 3 || cfg.BurnWindows[0] != time.Minute || cfg.BurnWindows[2] != 15*time.Minute {
+		t.Fatalf("unexpected burn windows %v", cfg.BurnWindows)
+	}
+}
+
+func TestLoadSLOConfig_EmbeddedDefault(t *testing.T) {
+	raw, err := os.ReadFile("../../ops/lachesis/slo.yaml")
+	if err != nil {
+		t.Fatalf("read slo.yaml: %v", err)
+	}
+	if !bytes.Equal(raw, defaultSLOFile) {
+		t.Fatalf("slo.default.yaml is out of date; copy ops/lachesis/slo.yaml over it")
+	}
+
+	t.Setenv("SLO_FILE", "")
+	t.Chdir(t.TempDir())
+	cfg, source, err := loadSLOConfig()
+	if err != nil || source != "embedded slo.default.yaml" || cfg.P95Ms != 500 {
+		t.Fatalf("want embedded default, got %+v %q %v", cfg, source, err)
+	}
+
+	t.Setenv("SLO_FILE", "missing.yaml")
+	if _, _, err := loadSLOConfig(); err == nil {
+		t.Fatalf("an explicit SLO_FILE that cannot be read must not fall back")
+	}
+}
+
+func TestSLOStats_SkipPreflightsAndUnmatchedInAggregate(t *testing.T) {
+	setupExplainTest(t)
+	prev := sloStats
+	sloStats = newSLOTracker(time.Now)
+	t.Cleanup(func() { sloStats = prev })
+
+	r := newRouter()
+	for _, req := range []*http.Request{
+		httptest.NewRequest(http.MethodOptions, "/api/v1/score", nil),
+		httptest.NewRequest(http.MethodGet, "/no/such/path", nil),
+	} {
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	if got := sloStats.evaluate("*"); got.Requests != 0 {
+		t.Fatalf("aggregate counted %d preflight/unmatched requests", got.Requests)
+	}
+	if got := sloStats.evaluate(sloUnmatchedRoute); got.Requests != 1 {
+		t.Fatalf("unmatched requests should still be tracked, got %d", got.Requests)
+	}
+}
+
+func TestSLOTracker_EvaluatesRollingWindow(t *testing.T) {
+	now := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	tr := newSLOTracker(func() time.Time { return now })
+	cfg, err := parseSLOFile([]byte(`
+service: picca
+metrics:
+  p95_ms: {target: 500, window: "rolling 15m"}
+  success: {target: 0.99, window: "rolling 15m"}
+`))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	tr.configure(cfg, "inline")
+
+	// Ten minutes ago: 100 fast, healthy requests.
+	now = now.Add(-10 * time.Minute)
+	for i := 0; i < 100; i++ {
+		tr.observe("/api/v1/score", http.StatusOK, 40*time.Millisecond)
+	}
+	// Just now: 2 server errors and 8 slow requests on the same route.
+	now = now.Add(10 * time.Minute)
+	for i := 0; i < 2; i++ {
+		tr.observe("/api/v1/score", http.StatusBadGateway, 40*time.Millisecond)
+	}
+	for i := 0; i < 8; i++ {
+		tr.observe("/api/v1/score", http.StatusOK, 900*time.Millisecond)
+	}
+	tr.observe("/api/v1/explain", http.StatusBadRequest, 10*time.Millisecond)
+
+	got := tr.evaluate("/api/v1/score")
+	if got.Requests != 110 || *got.Success != 0.9818 || got.SuccessCompliant {
+		t.Fatalf("success SLI: %+v", got)
+	}
+	if *got.SuccessBudgetRemaining != -0.8182 {
+		t.Fatalf("success budget: %v", *got.SuccessBudgetRemaining)
+	}
+	if *got.P95Ms < 900 || got.LatencyCompliant {
+		t.Fatalf("latency SLI: p95=%v compliant=%v", *got.P95Ms, got.LatencyCompliant)
+	}
+	short := got.BurnRates[0]
+	if short.Window != "1m0s" || short.Requests != 10 || *short.SuccessBurn != 20 || *short.LatencyBurn != 16 {
+		t.Fatalf("short burn rate: %+v success=%v latency=%v", short, *short.SuccessBurn, *short.LatencyBurn)
+	}
+	if explain := tr.evaluate("/api/v1/explain"); *explain.Success != 1 {
+		t.Fatalf("client errors must not burn the success budget: %+v", explain)
+	}
+
+	// Once the window has rolled past everything, no SLI is reported.
+	now = now.Add(20 * time.Minute)
+	if got := tr.evaluate("/api/v1/score"); got.Requests != 0 || got.Success != nil {
+		t.Fatalf("old data not expired: %+v", got)
+	}
+}
+
+func TestSLOHandler(t *testing.T) {
+	prev := sloStats
+	sloStats = newSLOTracker(time.Now)
+	t.Cleanup(func() { sloStats = prev })
+	t.Setenv("OPS_TOKEN", "")
+	req := httptest.NewRequest(http.MethodGet, "/ops/slo", nil)
+	req.RemoteAddr = "127.0.0.1:40000"
+
+	w := httptest.NewRecorder()
+	sloHandler().ServeHTTP(w, req)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("want 503 before load, got %d", w.Code)
+	}
+
+	t.Setenv("SLO_FILE", "../../ops/lachesis/slo.yaml")
+	startSLOEvaluator()
+	sloStats.observe("/api/v1/score", http.StatusOK, 20*time.Millisecond)
+	w = httptest.NewRecorder()
+	sloHandler().ServeHTTP(w, req)
+	var body struct {
+		Overall routeSLO   `json:"overall"`
+		Routes  []routeSLO `json:"routes"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("report: %d %s", w.Code, w.Body.String())
+	}
+	if body.Overall.Requests != 1 || len(body.Routes) != 1 || body.Routes[0].Route != "/api/v1/score" {
+		t.Fatalf("unexpected report %s", w.Body.String())
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// tlsSettings is read from TLS_CERT_FILE, TLS_KEY_FILE, TLS_CLIENT_CA_FILE and
+// TLS_CLIENT_AUTH (none, request, verify_if_given, require). Client auth
+// defaults to require when a CA bundle is given. The files are reloaded on
+// SIGHUP and when they change (see tlsPollInterval).
+type tlsSettings struct {
+	certFile, keyFile, caFile string
+	clientAuth                tls.ClientAuthType
+}
+
+func currentTLSSettings() (tlsSettings, bool, error) {
+	s := tlsSettings{
+		certFile: strings.TrimSpace(os.Getenv("TLS_CERT_FILE")),
+		keyFile:  strings.TrimSpace(os.Getenv("TLS_KEY_FILE")),
+		caFile:   strings.TrimSpace(os.Getenv("TLS_CLIENT_CA_FILE")),
+	}
+	if s.certFile == "" && s.keyFile == "" {
+		return s, false, nil
+	}
+	if s.certFile == "" || s.keyFile == "" {
+		return s, false, errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	mode := strings.ToLower(strings.TrimSpace(os.Getenv("TLS_CLIENT_AUTH")))
+	if mode == "" && s.caFile != "" {
+		mode = "require"
+	}
+	switch mode {
+	case "", "none":
+		s.clientAuth = tls.NoClientCert
+	case "request":
+		s.clientAuth = tls.RequestClientCert
+	case "verify_if_given":
+		s.clientAuth = tls.VerifyClientCertIfGiven
+	case "require":
+		s.clientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return s, false, fmt.Errorf("TLS_CLIENT_AUTH: unknown mode %q", mode)
+	}
+	if s.clientAuth >= tls.VerifyClientCertIfGiven && s.caFile == "" {
+		return s, false, fmt.Errorf("TLS_CLIENT_AUTH=%s needs TLS_CLIENT_CA_FILE", mode)
+	}
+	return s, true, nil
+}
+
+// tlsReloader serves the current certificate and client CA pool and swaps
+// them when the files change, so certificates rotate without a restart.
+type tlsReloader struct {
+	settings tlsSettings
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	pool    *x509.CertPool
+	modTime time.Time
+}
+
+func newTLSReloader(s tlsSettings) (*tlsReloader, error) {
+	r := &tlsReloader{settings: s}
+	if err := r.reload("startup"); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// latestModTime is the newest mtime among the watched files.
+func (r *tlsReloader) latestModTime() time.Time {
+	var latest time.Time
+	for _, p := range []string{r.settings.certFile, r.settings.keyFile, r.settings.caFile} {
+		if p == "" {
+			continue
+		}
+		if info, err := os.Stat(p); err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest
+}
+
+func (r *tlsReloader) reload(trigger string) error {
+	modTime := r.latestModTime()
+	cert, err := tls.LoadX509KeyPair(r.settings.certFile, r.settings.keyFile)
+	if err != nil {
+		log.Printf("tls: reload (%s) failed: %v", trigger, err)
+		return err
+	}
+	var pool *x509.CertPool
+	if r.settings.caFile != "" {
+		pem, err := os.ReadFile(r.settings.caFile)
+		if err != nil {
+			log.Printf("tls: reload (%s) failed: %v", trigger, err)
+			return err
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			err := fmt.Errorf("no certificates in %s", r.settings.caFile)
+			log.Printf("tls: reload (%s) failed: %v", trigger, err)
+			return err
+		}
+	}
+	r.mu.Lock()
+	r.cert, r.pool, r.modTime = &cert, pool, modTime
+	r.mu.Unlock()
+	log.Printf("tls: certificates loaded (%s)", trigger)
+	return nil
+}
+
+// poll reloads when any file is newer than the loaded set. A failed reload
+// keeps serving the previous certificates.
+func (r *tlsReloader) poll() {
+	r.mu.RLock()
+	loaded := r.modTime
+	r.mu.RUnlock()
+	if r.latestModTime().After(loaded) {
+		_ = r.reload("file_change")
+	}
+}
+
+// config returns a server config that resolves the certificate and client
+// CAs per handshake. The per-handshake config replaces the one http.Server
+// derives, so it repeats the ALPN protocols the server would offer.
+func (r *tlsReloader) config() *tls.Config {
+	base := &tls.Config{MinVersion: tls.VersionTLS12, NextProtos: []string{"h2", "http/1.1"}}
+	base.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		r.mu.RLock()
+		defer r.mu.RUnlock()
+		cfg := base.Clone()
+		cfg.GetConfigForClient = nil
+		cfg.Certificates = []tls.Certificate{*r.cert}
+		cfg.ClientCAs = r.pool
+		cfg.ClientAuth = r.settings.clientAuth
+		return cfg, nil
+	}
+	return base
+}
+
+// tlsPollInterval is how often the certificate files are checked for
+// changes (TLS_POLL_INTERVAL, default 10s).
+func tlsPollInterval() time.Duration {
+	if v := os.Getenv("TLS_POLL_INTERVAL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			return d
+		}
+	}
+	return 10 * time.Second
+}
+
+func (r *tlsReloader) start(hup <-chan os.Signal, stop <-chan struct{}) {
+	go func() {
+		ticker := time.NewTicker(tlsPollInterval())
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-hup:
+				_ = r.reload("sighup")
+			case <-ticker.C:
+				r.poll()
+			}
+		}
+	}()
+}
+
+// verifiedClientCert returns the leaf of a client certificate that chained to
+// the configured CA bundle. Certificates accepted under TLS_CLIENT_AUTH=request
+// are not verified and never authenticate.
+func verifiedClientCert(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return r.TLS.VerifiedChains[0][0]
+}
+
+// certEntry returns the active or in-grace entry whose client_subject
+// matches the certificate's common name or full distinguished name.
+func (s *keyStore) certEntry(cert *x509.Certificate, now time.Time) (apiKeyEntry, bool) {
+	matches := func(k apiKeyEntry) bool {
+		return k.ClientSubject != "" && (k.ClientSubject == cert.Subject.CommonName || k.ClientSubject == cert.Subject.String())
+	}
+	for _, k := range s.keys {
+		if matches(k) {
+			return k, true
+		}
+	}
+	for _, r := range s.retired {
+		if matches(r.entry) && now.Before(r.until) {
+			return r.entry, true
+		}
+	}
+	return apiKeyEntry{}, false
+}
+
+func authenticateCert(c *gin.Context, cert *x509.Certificate) (principal, bool) {
+	store, err := currentKeyStore()
+	if err != nil {
+		return principal{}, denyAuth(c, http.StatusInternalServerError, "server misconfigured", "MISCONFIGURED_API_KEY")
+	}
+	now := time.Now()
+	key, ok := store.certEntry(cert, now)
+	if !ok {
+		return principal{}, denyAuth(c, http.StatusUnauthorized, "unknown client certificate", "UNKNOWN_CLIENT_CERT")
+	}
+	if err := key.usable(now); err != nil {
+		return principal{}, denyKeyError(c, err)
+	}
+	return key.principal(), true
+}
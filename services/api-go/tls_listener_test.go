@@ -0,0 +1,174 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+func issueCert(t *testing.T, cn string, parent *testCert, isCA bool) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("key: %v", err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: cn, Organization: []string{"picca"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	signer, signerKey := tmpl, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatalf("create cert: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+func (c *testCert) write(t *testing.T, dir, name string) (string, string) {
+	t.Helper()
+	keyDER, _ := x509.MarshalECPrivateKey(c.key)
+	certPath, keyPath := filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certPath, keyPath
+}
+
+func (c *testCert) tlsCert() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.der}, PrivateKey: c.key}
+}
+
+func TestTLSListener_ClientCertIdentityAndReload(t *testing.T) {
+	dir := t.TempDir()
+	ca := issueCert(t, "picca test CA", nil, true)
+	caPath, _ := ca.write(t, dir, "ca")
+	server := issueCert(t, "localhost", ca, false)
+	certPath, keyPath := server.write(t, dir, "server")
+	t.Setenv("TLS_CERT_FILE", certPath)
+	t.Setenv("TLS_KEY_FILE", keyPath)
+	t.Setenv("TLS_CLIENT_CA_FILE", caPath)
+	t.Setenv("TLS_CLIENT_AUTH", "verify_if_given")
+	writeKeyFile(t, `{"keys":[{"name":"onprem-batch","client_subject":"batch.onprem","scopes":["score"]}]}`)
+
+	settings, ok, err := currentTLSSettings()
+	if err != nil || !ok {
+		t.Fatalf("settings: %v %v", ok, err)
+	}
+	certs, err := newTLSReloader(settings)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	srv := httptest.NewUnstartedServer(newRouter())
+	srv.TLS = certs.config()
+	srv.StartTLS()
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	client := func(certs ...tls.Certificate) *http.Client {
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certs}}}
+	}
+	usage := func(cl *http.Client) (int, map[string]any) {
+		t.Helper()
+		resp, err := cl.Get(srv.URL + "/api/v1/usage")
+		if err != nil {
+			t.Fatalf("get: %v", err)
+		}
+		defer resp.Body.Close()
+		var body map[string]any
+		_ = json.NewDecoder(resp.Body).Decode(&body)
+		return resp.StatusCode, body
+	}
+
+	if code, body := usage(client(issueCert(t, "batch.onprem", ca, false).tlsCert())); code != http.StatusOK || body["key_name"] != "onprem-batch" {
+		t.Fatalf("mapped cert: %d %v", code, body)
+	}
+	if code, body := usage(client(issueCert(t, "stranger", ca, false).tlsCert())); code != http.StatusUnauthorized || body["reason_code"] != "UNKNOWN_CLIENT_CERT" {
+		t.Fatalf("unmapped cert: %d %v", code, body)
+	}
+	if code, body := usage(client()); code != http.StatusUnauthorized || body["reason_code"] != "INVALID_API_KEY" {
+		t.Fatalf("no cert: %d %v", code, body)
+	}
+
+	// Rotate the server certificate on disk and pick it up via poll.
+	rotated := issueCert(t, "localhost", ca, false)
+	rotated.write(t, dir, "server")
+	future := time.Now().Add(time.Minute)
+	for _, p := range []string{certPath, keyPath} {
+		if err := os.Chtimes(p, future, future); err != nil {
+			t.Fatal(err)
+		}
+	}
+	certs.poll()
+	conn, err := tls.Dial("tcp", srv.Listener.Addr().String(), &tls.Config{RootCAs: roots, ServerName: "localhost"})
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	if got := conn.ConnectionState().PeerCertificates[0].SerialNumber; got.Cmp(rotated.cert.SerialNumber) != 0 {
+		t.Fatalf("server still presents old certificate")
+	}
+}
+
+func TestTLSListener_OffersHTTP2(t *testing.T) {
+	dir := t.TempDir()
+	ca := issueCert(t, "picca test CA", nil, true)
+	certPath, keyPath := issueCert(t, "localhost", ca, false).write(t, dir, "server")
+	certs, err := newTLSReloader(tlsSettings{certFile: certPath, keyFile: keyPath})
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: http.NotFoundHandler(), TLSConfig: certs.config()}
+	go func() { _ = srv.ServeTLS(ln, "", "") }()
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	conn, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{RootCAs: roots, ServerName: "localhost", NextProtos: []string{"h2", "http/1.1"}})
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	if got := conn.ConnectionState().NegotiatedProtocol; got != "h2" {
+		t.Fatalf("negotiated %q, want h2", got)
+	}
+}
@@ -0,0 +1,485 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// traceContext is the W3C Trace Context carried in traceparent/tracestate.
+type traceContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+	State   string
+}
+
+func (tc traceContext) traceparent() string {
+	flags := "00"
+	if tc.Sampled {
+		flags = "01"
+	}
+	return "00-" + hex.EncodeToString(tc.TraceID[:]) + "-" + hex.EncodeToString(tc.SpanID[:]) + "-" + flags
+}
+
+// parseTraceparent accepts version 00 (and, per the spec, later versions'
+// leading fields). All-zero IDs are invalid.
+func parseTraceparent(h string) (traceContext, bool) {
+	var tc traceContext
+	h = strings.TrimSpace(h)
+	if len(h) < 55 || (len(h) > 55 && h[55] != '-') {
+		return tc, false
+	}
+	version, traceID, spanID, flags := h[0:2], h[3:35], h[36:52], h[53:55]
+	if h[2] != '-' || h[35] != '-' || h[52] != '-' || version == "ff" || (version == "00" && len(h) != 55) {
+		return tc, false
+	}
+	if _, err := hex.Decode(tc.TraceID[:], []byte(traceID)); err != nil || traceID != strings.ToLower(traceID) {
+		return tc, false
+	}
+	if _, err := hex.Decode(tc.SpanID[:], []byte(spanID)); err != nil || spanID != strings.ToLower(spanID) {
+		return tc, false
+	}
+	f, err := hex.DecodeString(flags)
+	if err != nil || tc.TraceID == [16]byte{} || tc.SpanID == [8]byte{} {
+		return tc, false
+	}
+	tc.Sampled = f[0]&1 == 1
+	return tc, true
+}
+
+type spanKind int
+
+// OTLP span kinds.
+const (
+	spanInternal spanKind = 1
+	spanServer   spanKind = 2
+	spanClient   spanKind = 3
+)
+
+// span is one timed operation. Attributes should hold strings, bools, ints or
+// float64s.
+type span struct {
+	name     string
+	kind     spanKind
+	tc       traceContext
+	parentID [8]byte
+	start    time.Time
+
+	mu       sync.Mutex
+	end      time.Time
+	attrs    map[string]any
+	failed   bool
+	errorMsg string
+	ended    bool
+}
+
+type spanKey struct{}
+
+func spanFromContext(ctx context.Context) *span {
+	sp, _ := ctx.Value(spanKey{}).(*span)
+	return sp
+}
+
+// startSpan begins a child of the span in ctx, or a new root trace when there
+// is none.
+func startSpan(ctx context.Context, name string, kind spanKind) (context.Context, *span) {
+	return startSpanFrom(ctx, name, kind, traceContext{})
+}
+
+// startSpanFrom is startSpan with a remote parent taken from an inbound
+// traceparent; a zero remote starts a new trace.
+func startSpanFrom(ctx context.Context, name string, kind spanKind, remote traceContext) (context.Context, *span) {
+	sp := &span{name: name, kind: kind, start: time.Now(), attrs: map[string]any{"run_id": runID}}
+	switch parent := spanFromContext(ctx); {
+	case parent != nil:
+		sp.tc, sp.parentID = parent.tc, parent.tc.SpanID
+	case remote.TraceID != [16]byte{}:
+		sp.tc, sp.parentID = remote, remote.SpanID
+	default:
+		_, _ = rand.Read(sp.tc.TraceID[:])
+		sp.tc.Sampled = true
+	}
+	_, _ = rand.Read(sp.tc.SpanID[:])
+	return context.WithValue(ctx, spanKey{}, sp), sp
+}
+
+func (s *span) setAttr(key string, value any) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.attrs[key] = value
+	s.mu.Unlock()
+}
+
+// fail marks the span as an error with reasonCode, mirroring the response's
+// reason_code.
+func (s *span) fail(reasonCode string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.failed, s.errorMsg = true, reasonCode
+	s.attrs["reason_code"] = reasonCode
+	s.mu.Unlock()
+}
+
+// finish ends the span and hands it to the exporter; later calls are no-ops.
+func (s *span) finish() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended, s.end = true, time.Now()
+	s.mu.Unlock()
+	if s.tc.Sampled {
+		traceExporter().export(s)
+	}
+}
+
+// injectTraceContext writes traceparent/tracestate for the span in ctx onto
+// an outbound request.
+func injectTraceContext(ctx context.Context, h http.Header) {
+	sp := spanFromContext(ctx)
+	if sp == nil {
+		return
+	}
+	h.Set("traceparent", sp.tc.traceparent())
+	if sp.tc.State != "" {
+		h.Set("tracestate", sp.tc.State)
+	}
+}
+
+// spanRecord is the stdout form of a finished span.
+type spanRecord struct {
+	Type       string         `json:"type"`
+	TraceID    string         `json:"trace_id"`
+	SpanID     string         `json:"span_id"`
+	ParentID   string         `json:"parent_span_id,omitempty"`
+	Name       string         `json:"name"`
+	Kind       spanKind       `json:"kind"`
+	Start      string         `json:"start"`
+	DurationMs float64        `json:"duration_ms"`
+	Error      string         `json:"error,omitempty"`
+	Attributes map[string]any `json:"attributes"`
+}
+
+func (s *span) record() spanRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	attrs := make(map[string]any, len(s.attrs))
+	for k, v := range s.attrs {
+		attrs[k] = v
+	}
+	r := spanRecord{
+		Type:       "span",
+		TraceID:    hex.EncodeToString(s.tc.TraceID[:]),
+		SpanID:     hex.EncodeToString(s.tc.SpanID[:]),
+		Name:       s.name,
+		Kind:       s.kind,
+		Start:      s.start.UTC().Format(time.RFC3339Nano),
+		DurationMs: float64(s.end.Sub(s.start).Microseconds()) / 1000,
+		Error:      s.errorMsg,
+		Attributes: attrs,
+	}
+	if s.parentID != [8]byte{} {
+		r.ParentID = hex.EncodeToString(s.parentID[:])
+	}
+	return r
+}
+
+type spanExporter interface {
+	export(*span)
+}
+
+type noopExporter struct{}
+
+func (noopExporter) export(*span) {}
+
+// stderrExporter writes one JSON line per span to stderr, keeping stdout for
+// OTS lines only.
+type stderrExporter struct{ mu sync.Mutex }
+
+func (e *stderrExporter) export(s *span) {
+	line, err := json.Marshal(s.record())
+	if err != nil {
+		return
+	}
+	e.mu.Lock()
+	fmt.Fprintln(os.Stderr, string(line))
+	e.mu.Unlock()
+}
+
+// otlpExporter batches spans and posts them as OTLP/HTTP JSON. Spans are
+// dropped rather than blocking a request when the queue is full.
+type otlpExporter struct {
+	endpoint string
+	client   *http.Client
+	queue    chan *span
+	flushReq chan chan struct{}
+	quit     chan struct{}
+}
+
+const otlpBatchSize = 256
+
+func newOTLPExporter(endpoint string) *otlpExporter {
+	e := &otlpExporter{
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: 5 * time.Second},
+		queue:    make(chan *span, 4096),
+		flushReq: make(chan chan struct{}),
+		quit:     make(chan struct{}),
+	}
+	go e.run()
+	return e
+}
+
+func (e *otlpExporter) export(s *span) {
+	select {
+	case e.queue <- s:
+	default:
+	}
+}
+
+// flush sends everything queued so far and waits for the post to finish.
+func (e *otlpExporter) flush() {
+	done := make(chan struct{})
+	e.flushReq <- done
+	<-done
+}
+
+func (e *otlpExporter) run() {
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+	var batch []*span
+	send := func() {
+		if len(batch) > 0 {
+			e.post(batch)
+			batch = nil
+		}
+	}
+	for {
+		select {
+		case s := <-e.queue:
+			if batch = append(batch, s); len(batch) >= otlpBatchSize {
+				send()
+			}
+		case <-ticker.C:
+			send()
+		case done := <-e.flushReq:
+			for drained := false; !drained; {
+				select {
+				case s := <-e.queue:
+					batch = append(batch, s)
+				default:
+					drained = true
+				}
+			}
+			send()
+			close(done)
+		case <-e.quit:
+			return
+		}
+	}
+}
+
+// close sends what is queued and stops the exporter.
+func (e *otlpExporter) close() {
+	e.flush()
+	close(e.quit)
+}
+
+func otlpAttrs(m map[string]any) []map[string]any {
+	out := make([]map[string]any, 0, len(m))
+	for k, v := range m {
+		var val map[string]any
+		switch x := v.(type) {
+		case bool:
+			val = map[string]any{"boolValue": x}
+		case int:
+			val = map[string]any{"intValue": strconv.Itoa(x)}
+		case int64:
+			val = map[string]any{"intValue": strconv.FormatInt(x, 10)}
+		case float64:
+			val = map[string]any{"doubleValue": x}
+		default:
+			val = map[string]any{"stringValue": fmt.Sprint(x)}
+		}
+		out = append(out, map[string]any{"key": k, "value": val})
+	}
+	return out
+}
+
+func (e *otlpExporter) post(batch []*span) {
+	spans := make([]map[string]any, 0, len(batch))
+	for _, s := range batch {
+		r := s.record()
+		status := map[string]any{"code": 1}
+		if r.Error != "" {
+			status = map[string]any{"code": 2, "message": r.Error}
+		}
+		spans = append(spans, map[string]any{
+			"traceId":           r.TraceID,
+			"spanId":            r.SpanID,
+			"parentSpanId":      r.ParentID,
+			"name":              r.Name,
+			"kind":              int(r.Kind),
+			"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+			"attributes":        otlpAttrs(r.Attributes),
+			"status":            status,
+		})
+	}
+	body, err := json.Marshal(map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{"attributes": otlpAttrs(map[string]any{
+				"service.name": "picca-api-go",
+				"run_id":       runID,
+			})},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]any{"name": "picca/api-go"},
+				"spans": spans,
+			}},
+		}},
+	})
+	if err != nil {
+		return
+	}
+	req, err := http.NewRequest(http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := e.client.Do(req)
+	if err != nil {
+		log.Printf("tracing: otlp export failed: %v", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Printf("tracing: otlp export returned %d", resp.StatusCode)
+	}
+}
+
+var (
+	exporterMu  sync.Mutex
+	exporterKey string
+	exporter    spanExporter = noopExporter{}
+)
+
+// traceExporter follows TRACE_EXPORTER (none, stderr, otlp) and
+// TRACE_OTLP_ENDPOINT, building the exporter once per configuration and
+// closing the one it replaces.
+func traceExporter() spanExporter {
+	mode := strings.ToLower(strings.TrimSpace(os.Getenv("TRACE_EXPORTER")))
+	endpoint := envOr("TRACE_OTLP_ENDPOINT", "http://localhost:4318/v1/traces")
+	key := mode + "|" + endpoint
+	exporterMu.Lock()
+	defer exporterMu.Unlock()
+	if key == exporterKey {
+		return exporter
+	}
+	if prev, ok := exporter.(*otlpExporter); ok {
+		go prev.close()
+	}
+	exporterKey = key
+	switch mode {
+	case "stderr":
+		exporter = &stderrExporter{}
+	case "otlp":
+		exporter = newOTLPExporter(endpoint)
+	case "", "none":
+		exporter = noopExporter{}
+	case "stdout":
+		log.Printf("trace: TRACE_EXPORTER=stdout is not supported because stdout carries OTS lines; use stderr. Tracing is off")
+		exporter = noopExporter{}
+	default:
+		log.Printf("trace: unknown TRACE_EXPORTER %q; tracing is off", mode)
+		exporter = noopExporter{}
+	}
+	return exporter
+}
+
+// flushTraces drains a batching exporter on shutdown.
+func flushTraces() {
+	exporterMu.Lock()
+	e := exporter
+	exporterMu.Unlock()
+	if o, ok := e.(*otlpExporter); ok {
+		o.flush()
+	}
+}
+
+// traceRW records the status and, for JSON errors, the body's reason_code.
+type traceRW struct {
+	http.ResponseWriter
+	status int
+	errBuf bytes.Buffer
+}
+
+func (w *traceRW) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *traceRW) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.status >= 400 && w.errBuf.Len() < 4096 {
+		w.errBuf.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// TraceMiddleware starts the inbound server span, continuing the caller's
+// trace when a valid traceparent is sent, and echoes traceparent on the
+// response.
+func TraceMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remote, ok := parseTraceparent(r.Header.Get("traceparent"))
+		if ok {
+			remote.State = r.Header.Get("tracestate")
+		}
+		ctx, sp := startSpanFrom(r.Context(), r.Method+" "+r.URL.Path, spanServer, remote)
+		sp.setAttr("http.method", r.Method)
+		sp.setAttr("http.target", r.URL.Path)
+		w.Header().Set("traceparent", sp.tc.traceparent())
+
+		tw := &traceRW{ResponseWriter: w}
+		next.ServeHTTP(tw, r.WithContext(ctx))
+
+		if tw.status == 0 {
+			tw.status = http.StatusOK
+		}
+		sp.setAttr("http.status_code", tw.status)
+		if id := w.Header().Get("X-Request-Id"); id != "" {
+			sp.setAttr("req_id", id)
+		}
+		if tw.status >= 400 {
+			reason := reasonCodeFromBody(tw.errBuf.Bytes())
+			if reason == "" {
+				reason = http.StatusText(tw.status)
+			}
+			sp.fail(reason)
+		}
+		sp.finish()
+	})
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	valid := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	tc, ok := parseTraceparent(valid)
+	if !ok || !tc.Sampled || tc.traceparent() != valid {
+		t.Fatalf("parse %q: %+v %v", valid, tc, ok)
+	}
+	if _, ok := parseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-future"); !ok {
+		t.Error("future version with extra fields should parse")
+	}
+	for _, bad := range []string{
+		"",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+	} {
+		if _, ok := parseTraceparent(bad); ok {
+			t.Errorf("parseTraceparent(%q) accepted", bad)
+		}
+	}
+}
+
+func TestTracing_PropagatesToMLAndExportsOTLP(t *testing.T) {
+	setupExplainTest(t)
+
+	var mu sync.Mutex
+	var exported []map[string]any
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			ResourceSpans []struct {
+				ScopeSpans []struct {
+					Spans []map[string]any `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		mu.Lock()
+		for _, rs := range body.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				exported = append(exported, ss.Spans...)
+			}
+		}
+		mu.Unlock()
+	}))
+	defer collector.Close()
+	t.Setenv("TRACE_EXPORTER", "otlp")
+	t.Setenv("TRACE_OTLP_ENDPOINT", collector.URL)
+
+	var upstream http.Header
+	ml := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstream = r.Header.Clone()
+		_, _ = io.Copy(io.Discard, r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"score":70,"symmetry":0.7,"power":0.7,"consistency":0.7}`))
+	}))
+	defer ml.Close()
+	t.Setenv("API_ML_URL", ml.URL)
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	h := TraceMiddleware(OTSMiddleware("run-test", newRouter()))
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/score", bytes.NewBufferString(`{}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-API-Key", "secret")
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	req.Header.Set("tracestate", "vendor=abc")
+	w := httptest.NewRecorder()
+	captureStdout(t, func() { h.ServeHTTP(w, req) })
+	if w.Code != http.StatusOK {
+		t.Fatalf("score: %d %s", w.Code, w.Body.String())
+	}
+
+	tc, ok := parseTraceparent(upstream.Get("traceparent"))
+	if !ok || hex.EncodeToString(tc.TraceID[:]) != traceID || upstream.Get("tracestate") != "vendor=abc" {
+		t.Fatalf("trace context not propagated to ML: %v", upstream)
+	}
+
+	flushTraces()
+	mu.Lock()
+	defer mu.Unlock()
+	byName := map[string]map[string]any{}
+	for _, sp := range exported {
+		if sp["traceId"] != traceID {
+			t.Errorf("span %v has foreign trace id", sp["name"])
+		}
+		byName[sp["name"].(string)] = sp
+	}
+	server, validate, mlSpan := byName["POST /api/v1/score"], byName["score.validate"], byName["ml.predict"]
+	if server == nil || validate == nil || mlSpan == nil {
+		t.Fatalf("missing spans: %v", byName)
+	}
+	if server["parentSpanId"] != "00f067aa0ba902b7" || mlSpan["parentSpanId"] != server["spanId"] || validate["parentSpanId"] != server["spanId"] {
+		t.Fatalf("unexpected span tree: server=%v validate=%v ml=%v", server, validate, mlSpan)
+	}
+	if mlSpan["spanId"] != strings.Split(upstream.Get("traceparent"), "-")[2] {
+		t.Fatalf("ML saw parent %s, want ml.predict span %v", upstream.Get("traceparent"), mlSpan["spanId"])
+	}
+}
+
+func TestTraceExporter_ClosesReplacedExporterAndKeepsStdoutForOTS(t *testing.T) {
+	posted := make(chan struct{}, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		posted <- struct{}{}
+	}))
+	defer collector.Close()
+	t.Setenv("TRACE_EXPORTER", "otlp")
+	t.Setenv("TRACE_OTLP_ENDPOINT", collector.URL)
+
+	_, sp := startSpan(t.Context(), "queued", spanInternal)
+	sp.finish()
+
+	t.Setenv("TRACE_EXPORTER", "stderr")
+	if _, ok := traceExporter().(*stderrExporter); !ok {
+		t.Fatalf("want stderr exporter, got %T", traceExporter())
+	}
+	select {
+	case <-posted:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("replaced otlp exporter did not send its queued span")
+	}
+
+	out := captureStdout(t, func() {
+		_, sp := startSpan(t.Context(), "local", spanInternal)
+		sp.finish()
+	})
+	if out != "" {
+		t.Fatalf("spans must not be written to stdout: %q", out)
+	}
+
+	t.Setenv("TRACE_EXPORTER", "stdout")
+	if _, ok := traceExporter().(noopExporter); !ok {
+		t.Fatalf("stdout must not be redirected to another exporter, got %T", traceExporter())
+	}
+}
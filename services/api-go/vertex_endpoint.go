@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"golang.org/x/oauth2/google"
+)
+
+// vertexEndpoint builds the generateContent URL. VERTEX_BASE_URL overrides the
+// regional Google host (a "{region}" placeholder is substituted), which lets
+// the gateway target private service connect endpoints or a local fake.
+func vertexEndpoint(projectID, region, model string) string {
+	base := strings.TrimRight(strings.TrimSpace(os.Getenv("VERTEX_BASE_URL")), "/")
+	if base == "" {
+		host := fmt.Sprintf("%s-aiplatform.googleapis.com", region)
+		if region == "global" {
+			host = "aiplatform.googleapis.com"
+		}
+		base = "https://" + host
+	} else {
+		base = strings.ReplaceAll(base, "{region}", region)
+	}
+
+	version := strings.Trim(strings.TrimSpace(os.Getenv("VERTEX_API_VERSION")), "/")
+	if version == "" {
+		version = "v1"
+	}
+
+	return fmt.Sprintf("%s/%s/projects/%s/locations/%s/publishers/google/models/%s:generateContent",
+		base, url.PathEscape(version), url.PathEscape(projectID), url.PathEscape(region), url.PathEscape(model))
+}
+
+type bearerTransport struct {
+	token string
+	base  http.RoundTripper
+}
+
+func (t bearerTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.Header.Set("Authorization", "Bearer "+t.token)
+	return t.base.RoundTrip(r)
+}
+
+// defaultVertexClient authenticates according to VERTEX_AUTH: "adc"
+// (Application Default Credentials, the default), "token" (static
+// VERTEX_TOKEN bearer) or "none".
+func defaultVertexClient(ctx context.Context) (*http.Client, error) {
+	switch mode := strings.ToLower(strings.TrimSpace(os.Getenv("VERTEX_AUTH"))); mode {
+	case "", "adc":
+		return google.DefaultClient(ctx, "https://www.googleapis.com/auth/cloud-platform")
+	case "token":
+		token := strings.TrimSpace(os.Getenv("VERTEX_TOKEN"))
+		if token == "" {
+			return nil, errors.New("VERTEX_AUTH=token requires VERTEX_TOKEN")
+		}
+		return &http.Client{Transport: bearerTransport{token: token, base: http.DefaultTransport}}, nil
+	case "none":
+		return &http.Client{Transport: http.DefaultTransport}, nil
+	default:
+		return nil, fmt.Errorf("unknown VERTEX_AUTH mode %q", mode)
+	}
+}
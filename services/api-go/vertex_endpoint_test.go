@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"picca/api-go/internal/fakevertex"
+)
+
+func setupFakeVertex(t *testing.T) *fakevertex.Server {
+	t.Helper()
+	setupExplainTest(t)
+	newVertexClient = defaultVertexClient
+
+	fake := fakevertex.New()
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+	t.Setenv("VERTEX_BASE_URL", srv.URL)
+	t.Setenv("VERTEX_AUTH", "none")
+	return fake
+}
+
+func TestVertexEndpoint(t *testing.T) {
+	cases := []struct {
+		base, version, region, want string
+	}{
+		{"", "", "us-central1", "https://us-central1-aiplatform.googleapis.com/v1/projects/p/locations/us-central1/publishers/google/models/m:generateContent"},
+		{"", "", "global", "https://aiplatform.googleapis.com/v1/projects/p/locations/global/publishers/google/models/m:generateContent"},
+		{"https://vertex.internal.example/", "v1beta1", "asia-northeast1", "https://vertex.internal.example/v1beta1/projects/p/locations/asia-northeast1/publishers/google/models/m:generateContent"},
+		{"https://{region}-psc.example", "", "europe-west4", "https://europe-west4-psc.example/v1/projects/p/locations/europe-west4/publishers/google/models/m:generateContent"},
+	}
+	for _, tc := range cases {
+		t.Setenv("VERTEX_BASE_URL", tc.base)
+		t.Setenv("VERTEX_API_VERSION", tc.version)
+		if got := vertexEndpoint("p", tc.region, "m"); got != tc.want {
+			t.Errorf("vertexEndpoint(%q, %q) = %s, want %s", tc.base, tc.region, got, tc.want)
+		}
+	}
+}
+
+func TestExplainAgainstFakeVertex_StaticToken(t *testing.T) {
+	fake := setupFakeVertex(t)
+	t.Setenv("VERTEX_AUTH", "token")
+	t.Setenv("VERTEX_TOKEN", "local-token")
+
+	code, resp := postExplain(t, explainMetrics)
+	if code != http.StatusOK || resp["summary"] != fakevertex.DefaultText {
+		t.Fatalf("want 200 with fake summary, got %d %v", code, resp)
+	}
+	calls := fake.Calls()
+	if len(calls) != 1 {
+		t.Fatalf("want 1 call, got %d", len(calls))
+	}
+	if calls[0].Authorization != "Bearer local-token" || calls[0].Project != "demo-project" || calls[0].Version != "v1" {
+		t.Fatalf("unexpected call: %+v", calls[0])
+	}
+}
+
+func TestExplainAgainstFakeVertex_StructuredAndFailover(t *testing.T) {
+	fake := setupFakeVertex(t)
+	fake.Script("us-central1", "*", fakevertex.Response{Status: http.StatusServiceUnavailable})
+	t.Setenv("VERTEX_TARGETS", "us-central1/gemini-2.5-flash-lite,asia-northeast1/gemini-2.5-flash-lite")
+
+	code, resp := postExplain(t, `{"score":80,"symmetry":0.9,"power":0.7,"consistency":0.6,"format":"structured"}`)
+	if code != http.StatusOK {
+		t.Fatalf("want 200, got %d %v", code, resp)
+	}
+	if resp["region"] != "asia-northeast1" || resp["fallback"] != false {
+		t.Fatalf("unexpected response: %v", resp)
+	}
+	if usage, _ := resp["usage"].(map[string]any); usage["total_tokens"] == float64(0) {
+		t.Fatalf("usage not reported: %v", resp["usage"])
+	}
+}
+
+func TestExplainAgainstFakeVertex_SlowTargetFailsOver(t *testing.T) {
+	fake := setupFakeVertex(t)
+	fake.Script("us-central1", "*", fakevertex.Response{Delay: 5 * time.Second})
+	t.Setenv("VERTEX_TARGETS", "us-central1/gemini-2.5-flash-lite,asia-northeast1/gemini-2.5-flash-lite")
+	t.Setenv("EXPLAIN_TIMEOUT", "1s")
+
+	start := time.Now()
+	code, resp := postExplain(t, explainMetrics)
+	if code != http.StatusOK || resp["region"] != "asia-northeast1" {
+		t.Fatalf("want 200 from the second target, got %d %v", code, resp)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("slow target held the request for %s", elapsed)
+	}
+	if calls := fake.Calls(); len(calls) != 2 {
+		t.Fatalf("want 2 calls, got %d", len(calls))
+	}
+}
+
+func TestDefaultVertexClient_UnknownAuthMode(t *testing.T) {
+	t.Setenv("VERTEX_AUTH", "kerberos")
+	if _, err := defaultVertexClient(t.Context()); err == nil {
+		t.Fatalf("expected error for unknown auth mode")
+	}
+}
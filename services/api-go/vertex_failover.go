@@ -0,0 +1,172 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type vertexTarget struct {
+	Region string `json:"region"`
+	Model  string `json:"model"`
+}
+
+func (t vertexTarget) String() string { return t.Region + "/" + t.Model }
+
+// vertexRoute is everything needed to reach the configured Vertex targets.
+type vertexRoute struct {
+	client    *http.Client
+	projectID string
+	targets   []vertexTarget
+}
+
+// vertexTargets reads the ordered failover chain from VERTEX_TARGETS
+// ("region/model,region/model"), falling back to VERTEX_REGION/VERTEX_MODEL.
+func vertexTargets() []vertexTarget {
+	var targets []vertexTarget
+	for _, item := range strings.Split(os.Getenv("VERTEX_TARGETS"), ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		region, model, ok := strings.Cut(item, "/")
+		region, model = strings.TrimSpace(region), strings.TrimSpace(model)
+		if !ok || region == "" || model == "" {
+			log.Printf("vertex: ignoring malformed target %q", item)
+			continue
+		}
+		targets = append(targets, vertexTarget{Region: region, Model: model})
+	}
+	if len(targets) > 0 {
+		return targets
+	}
+
+	region := strings.TrimSpace(os.Getenv("VERTEX_REGION"))
+	if region == "" {
+		region = "us-central1"
+	}
+	model := strings.TrimSpace(os.Getenv("VERTEX_MODEL"))
+	if model == "" {
+		model = "gemini-2.5-flash-lite"
+	}
+	return []vertexTarget{{Region: region, Model: model}}
+}
+
+// explainTimeout bounds a whole explain request, failover included
+// (EXPLAIN_TIMEOUT, default 10s).
+func explainTimeout() time.Duration {
+	if v := os.Getenv("EXPLAIN_TIMEOUT"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			return d
+		}
+	}
+	return 10 * time.Second
+}
+
+func targetCooldown() time.Duration {
+	if v := os.Getenv("VERTEX_TARGET_COOLDOWN"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+			return d
+		}
+	}
+	return 30 * time.Second
+}
+
+type targetState struct {
+	Failures      int       `json:"consecutive_failures"`
+	SkipUntil     time.Time `json:"skip_until,omitempty"`
+	LastFailure   time.Time `json:"last_failure,omitempty"`
+	LastSuccess   time.Time `json:"last_success,omitempty"`
+	TotalFailures int64     `json:"total_failures"`
+}
+
+// targetHealth remembers recently failing targets so later requests try
+// healthy ones first.
+type targetHealth struct {
+	mu     sync.Mutex
+	states map[vertexTarget]*targetState
+	now    func() time.Time
+}
+
+var vertexHealth = newTargetHealth()
+
+func newTargetHealth() *targetHealth {
+	return &targetHealth{states: map[vertexTarget]*targetState{}, now: time.Now}
+}
+
+func (h *targetHealth) state(t vertexTarget) *targetState {
+	st, ok := h.states[t]
+	if !ok {
+		st = &targetState{}
+		h.states[t] = st
+	}
+	return st
+}
+
+func (h *targetHealth) markFailure(t vertexTarget) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	st := h.state(t)
+	now := h.now()
+	st.Failures++
+	st.TotalFailures++
+	st.LastFailure = now
+	st.SkipUntil = now.Add(targetCooldown())
+}
+
+func (h *targetHealth) markSuccess(t vertexTarget) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	st := h.state(t)
+	st.Failures = 0
+	st.SkipUntil = time.Time{}
+	st.LastSuccess = h.now()
+}
+
+// order returns the targets not in cooldown, preserving configured order. If
+// every target is cooling down, all of them are returned as a last resort.
+func (h *targetHealth) order(targets []vertexTarget) []vertexTarget {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := h.now()
+	healthy := make([]vertexTarget, 0, len(targets))
+	for _, t := range targets {
+		if st, ok := h.states[t]; ok && now.Before(st.SkipUntil) {
+			continue
+		}
+		healthy = append(healthy, t)
+	}
+	if len(healthy) == 0 {
+		return targets
+	}
+	return healthy
+}
+
+type targetStatus struct {
+	Target      string `json:"target"`
+	Region      string `json:"region"`
+	Model       string `json:"model"`
+	CoolingDown bool   `json:"cooling_down"`
+	targetState
+}
+
+// snapshot reports the health of each configured target for the ops
+// dashboard.
+func (h *targetHealth) snapshot(targets []vertexTarget) []targetStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := h.now()
+	out := make([]targetStatus, 0, len(targets))
+	for _, t := range targets {
+		ts := targetStatus{Target: t.String(), Region: t.Region, Model: t.Model}
+		if st, ok := h.states[t]; ok {
+			ts.targetState = *st
+			ts.CoolingDown = now.Before(st.SkipUntil)
+		}
+		out = append(out, ts)
+	}
+	return out
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// stubVertexByHost routes generateContent calls by regional host so failover
+// between targets can be observed. It returns the hosts called, in order.
+func stubVertexByHost(t *testing.T, statuses map[string]int) *[]string {
+	t.Helper()
+	setupExplainTest(t)
+
+	var hosts []string
+	newVertexClient = func(ctx context.Context) (*http.Client, error) {
+		return &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			_, _ = io.Copy(io.Discard, req.Body)
+			req.Body.Close()
+			hosts = append(hosts, req.URL.Host)
+
+			status := statuses[req.URL.Host]
+			if status == 0 {
+				status = http.StatusOK
+			}
+			respBody := `{"error":{"status":"` + http.StatusText(status) + `"}}`
+			if status == http.StatusOK {
+				respBody = `{"candidates":[{"content":{"parts":[{"text":"Metrics look strong."}]},"finishReason":"STOP"}]}`
+			}
+			r := &http.Response{
+				StatusCode: status,
+				Header:     make(http.Header),
+				Body:       io.NopCloser(strings.NewReader(respBody)),
+			}
+			r.Header.Set("Content-Type", "application/json")
+			return r, nil
+		})}, nil
+	}
+	return &hosts
+}
+
+func TestExplainFailover_NextTargetOn5xx(t *testing.T) {
+	hosts := stubVertexByHost(t, map[string]int{
+		"us-central1-aiplatform.googleapis.com": http.StatusServiceUnavailable,
+	})
+	t.Setenv("VERTEX_TARGETS", "us-central1/gemini-2.5-flash-lite, europe-west4/gemini-2.5-flash")
+	t.Setenv("EXPLAIN_CACHE_SIZE", "0")
+	prev := explainCosts
+	explainCosts = newCostLedger()
+	t.Cleanup(func() { explainCosts = prev })
+
+	code, resp := postExplain(t, explainMetrics)
+	if code != http.StatusOK {
+		t.Fatalf("want 200, got %d %v", code, resp)
+	}
+	if resp["region"] != "europe-west4" || resp["model"] != "gemini-2.5-flash" {
+		t.Fatalf("response must report the target used: %v", resp)
+	}
+	if got := explainCosts.byModel; got["gemini-2.5-flash"] == nil || got["gemini-2.5-flash"].Requests != 1 || got["gemini-2.5-flash-lite"] != nil {
+		t.Fatalf("request must be counted against the serving model: %v", got)
+	}
+	if len(*hosts) != 2 {
+		t.Fatalf("want 2 upstream calls, got %v", *hosts)
+	}
+
+	// The failing region is now cooling down and should be skipped.
+	*hosts = nil
+	if code, _ := postExplain(t, explainMetrics); code != http.StatusOK {
+		t.Fatalf("want 200 on second call, got %d", code)
+	}
+	if len(*hosts) != 1 || (*hosts)[0] != "europe-west4-aiplatform.googleapis.com" {
+		t.Fatalf("unhealthy target not skipped: %v", *hosts)
+	}
+}
+
+func TestExplainFailover_AllTargetsRateLimited(t *testing.T) {
+	hosts := stubVertexByHost(t, map[string]int{
+		"us-central1-aiplatform.googleapis.com":  http.StatusTooManyRequests,
+		"europe-west4-aiplatform.googleapis.com": http.StatusTooManyRequests,
+	})
+	t.Setenv("VERTEX_TARGETS", "us-central1/gemini-2.5-flash-lite,europe-west4/gemini-2.5-flash-lite")
+
+	code, _ := postExplain(t, explainMetrics)
+	if code != http.StatusTooManyRequests {
+		t.Fatalf("want upstream 429 relayed, got %d", code)
+	}
+	if len(*hosts) != 2 {
+		t.Fatalf("want both targets tried, got %v", *hosts)
+	}
+}
+
+func TestExplainFailover_ClientErrorNotRetried(t *testing.T) {
+	hosts := stubVertexByHost(t, map[string]int{
+		"us-central1-aiplatform.googleapis.com": http.StatusBadRequest,
+	})
+	t.Setenv("VERTEX_TARGETS", "us-central1/gemini-2.5-flash-lite,europe-west4/gemini-2.5-flash-lite")
+
+	code, _ := postExplain(t, explainMetrics)
+	if code != http.StatusBadRequest || len(*hosts) != 1 {
+		t.Fatalf("want single 400 call, got %d after %v", code, *hosts)
+	}
+}
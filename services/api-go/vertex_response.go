@@ -0,0 +1,326 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type vertexSafetyRating struct {
+	Category    string `json:"category"`
+	Probability string `json:"probability,omitempty"`
+	Blocked     bool   `json:"blocked,omitempty"`
+}
+
+type vertexResponse struct {
+	Candidates []struct {
+		Content struct {
+			Parts []struct {
+				Text string `json:"text"`
+			} `json:"parts"`
+		} `json:"content"`
+		FinishReason  string               `json:"finishReason"`
+		SafetyRatings []vertexSafetyRating `json:"safetyRatings"`
+	} `json:"candidates"`
+	UsageMetadata  vertexUsageMetadata `json:"usageMetadata"`
+	PromptFeedback struct {
+		BlockReason        string               `json:"blockReason"`
+		BlockReasonMessage string               `json:"blockReasonMessage"`
+		SafetyRatings      []vertexSafetyRating `json:"safetyRatings"`
+	} `json:"promptFeedback"`
+}
+
+// vertexAnswer is the usable part of a generateContent response plus the
+// signals explaining why generation stopped.
+type vertexAnswer struct {
+	Text              string
+	FinishReason      string
+	BlockReason       string
+	SafetyRatings     []vertexSafetyRating
+	TruncationRetries int
+	Usage             vertexUsageMetadata
+	Target            vertexTarget
+}
+
+func (a vertexAnswer) metadata() gin.H {
+	meta := gin.H{"finish_reason": a.FinishReason}
+	if a.BlockReason != "" {
+		meta["block_reason"] = a.BlockReason
+	}
+	if len(a.SafetyRatings) > 0 {
+		meta["safety_ratings"] = a.SafetyRatings
+	}
+	if a.TruncationRetries > 0 {
+		meta["truncation_retries"] = a.TruncationRetries
+	}
+	return meta
+}
+
+// vertexOutcomeError reports a syntactically valid response that carries no
+// usable answer (blocked, truncated or empty).
+type vertexOutcomeError struct {
+	reasonCode string
+	message    string
+}
+
+func (e *vertexOutcomeError) Error() string { return e.message }
+
+var (
+	errVertexBlocked   = &vertexOutcomeError{reasonCode: "VERTEX_BLOCKED", message: "vertex response blocked"}
+	errVertexTruncated = &vertexOutcomeError{reasonCode: "VERTEX_TRUNCATED", message: "vertex response truncated"}
+	errVertexInvalid   = &vertexOutcomeError{reasonCode: "VERTEX_INVALID_RESPONSE", message: "vertex upstream error"}
+)
+
+// interpretVertexResponse extracts the first candidate's text and maps prompt
+// feedback and finish reasons onto distinct errors.
+func interpretVertexResponse(body []byte) (vertexAnswer, error) {
+	var resp vertexResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return vertexAnswer{}, errVertexInvalid
+	}
+
+	answer := vertexAnswer{
+		BlockReason:   resp.PromptFeedback.BlockReason,
+		SafetyRatings: resp.PromptFeedback.SafetyRatings,
+		Usage:         resp.UsageMetadata,
+	}
+	if answer.BlockReason != "" {
+		return answer, errVertexBlocked
+	}
+	if len(resp.Candidates) == 0 {
+		return answer, errVertexInvalid
+	}
+
+	cand := resp.Candidates[0]
+	answer.FinishReason = cand.FinishReason
+	answer.SafetyRatings = append(answer.SafetyRatings, cand.SafetyRatings...)
+	switch cand.FinishReason {
+	case "", "STOP", "FINISH_REASON_UNSPECIFIED":
+	case "MAX_TOKENS":
+		return answer, errVertexTruncated
+	case "SAFETY", "RECITATION", "BLOCKLIST", "PROHIBITED_CONTENT", "SPII", "IMAGE_SAFETY":
+		return answer, errVertexBlocked
+	default:
+		return answer, errVertexInvalid
+	}
+
+	for _, part := range cand.Content.Parts {
+		if strings.TrimSpace(part.Text) != "" {
+			answer.Text = part.Text
+			return answer, nil
+		}
+	}
+	return answer, errVertexInvalid
+}
+
+func truncationRetries() int {
+	if v := os.Getenv("VERTEX_TRUNCATION_RETRIES"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			return n
+		}
+	}
+	return 1
+}
+
+func positiveEnvInt(name string, def int) int {
+	if v := os.Getenv(name); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			return n
+		}
+	}
+	return def
+}
+
+// outputTokenBudget is the output cap for the first call (the payload's own
+// maxOutputTokens, else VERTEX_MAX_OUTPUT_TOKENS, else 0 to leave it to the
+// model) and the model maximum a truncation retry may raise it to
+// (VERTEX_MAX_OUTPUT_TOKENS_LIMIT, default 8192).
+func outputTokenBudget(payload map[string]any) (initial, limit int) {
+	limit = positiveEnvInt("VERTEX_MAX_OUTPUT_TOKENS_LIMIT", 8192)
+	initial = positiveEnvInt("VERTEX_MAX_OUTPUT_TOKENS", 0)
+	if cfg, ok := payload["generationConfig"].(map[string]any); ok {
+		switch n := cfg["maxOutputTokens"].(type) {
+		case int:
+			initial = n
+		case float64:
+			initial = int(n)
+		}
+	}
+	if initial <= 0 {
+		return 0, limit
+	}
+	return min(initial, limit), limit
+}
+
+// nextOutputBudget is the cap for a truncation retry: double the last one,
+// or the model limit when the first call left the cap to the model.
+func nextOutputBudget(tokens, limit int) int {
+	if tokens == 0 {
+		return limit
+	}
+	return min(tokens*2, limit)
+}
+
+// withMaxOutputTokens returns a shallow copy of payload whose generationConfig
+// caps output at tokens.
+func withMaxOutputTokens(payload map[string]any, tokens int) map[string]any {
+	out := make(map[string]any, len(payload)+1)
+	for k, v := range payload {
+		out[k] = v
+	}
+	cfg := map[string]any{}
+	if prev, ok := payload["generationConfig"].(map[string]any); ok {
+		for k, v := range prev {
+			cfg[k] = v
+		}
+	}
+	cfg["maxOutputTokens"] = tokens
+	out["generationConfig"] = cfg
+	return out
+}
+
+// generateVertex runs generateVertexAnswer and writes any failure to c,
+// reporting it as ok=false.
+func generateVertex(ctx context.Context, c *gin.Context, route vertexRoute, payload map[string]any) (vertexAnswer, int64, bool) {
+	answer, duration, cerr := generateVertexAnswer(ctx, c, route, payload)
+	if cerr != nil {
+		cerr.write(c)
+		return answer, duration, false
+	}
+	return answer, duration, true
+}
+
+// generateVertexAnswer runs generateContent for payload against the route's
+// targets, failing over on retryable errors (including a target using up its
+// share of the deadline) and retrying truncated answers
+// with a larger output budget, up to the model limit. The request is counted
+// against the target that answered, or the last one tried.
+func generateVertexAnswer(ctx context.Context, c *gin.Context, route vertexRoute, payload map[string]any) (vertexAnswer, int64, *vertexCallError) {
+	var (
+		duration int64
+		lastErr  *vertexCallError
+	)
+	served := route.targets[0]
+	defer func() { countExplainRequest(c, served.Model) }()
+	targets := vertexHealth.order(route.targets)
+	for i, target := range targets {
+		if ctx.Err() != nil {
+			break
+		}
+		served = target
+		attemptCtx, cancel := attemptContext(ctx, len(targets)-i)
+		answer, elapsed, cerr := generateOnTarget(attemptCtx, c, route, target, payload)
+		cancel()
+		duration += elapsed
+		if cerr == nil {
+			vertexHealth.markSuccess(target)
+			return answer, duration, nil
+		}
+		if !cerr.retryable {
+			cerr.durationMs = duration
+			return answer, duration, cerr
+		}
+		vertexHealth.markFailure(target)
+		log.Printf("vertex: target %s failed (%s %d), trying next", target, cerr.reasonCode, cerr.status)
+		lastErr = cerr
+	}
+	if lastErr == nil {
+		lastErr = &vertexCallError{status: http.StatusGatewayTimeout, reasonCode: "VERTEX_UPSTREAM_TIMEOUT", message: "vertex upstream timeout"}
+	}
+	lastErr.durationMs = duration
+	return vertexAnswer{}, duration, lastErr
+}
+
+// attemptContext gives one target an equal share of the time left on ctx
+// with the remaining targets, so a slow target cannot starve the rest.
+func attemptContext(ctx context.Context, remaining int) (context.Context, context.CancelFunc) {
+	deadline, ok := ctx.Deadline()
+	if !ok || remaining <= 1 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, time.Until(deadline)/time.Duration(remaining))
+}
+
+func observeVertexCall(target vertexTarget, res vertexResult, cerr *vertexCallError) {
+	outcome, ms := "ok", res.durationMs
+	if cerr != nil {
+		ms = cerr.durationMs
+		switch {
+		case cerr.body != nil:
+			outcome = upstreamOutcome(cerr.status)
+		case cerr.status == http.StatusGatewayTimeout:
+			outcome = "timeout"
+		default:
+			outcome = "transport"
+		}
+	}
+	gwMetrics.vertexDuration.observe(float64(ms)/1000, target.Region, target.Model, outcome)
+}
+
+func generateOnTarget(ctx context.Context, c *gin.Context, route vertexRoute, target vertexTarget, payload map[string]any) (vertexAnswer, int64, *vertexCallError) {
+	var duration int64
+	vertexURL := vertexEndpoint(route.projectID, target.Region, target.Model)
+	tokens, limit := outputTokenBudget(payload)
+	if tokens > 0 {
+		payload = withMaxOutputTokens(payload, tokens)
+	}
+	for attempt := 0; ; attempt++ {
+		reqBytes, err := json.Marshal(payload)
+		if err != nil {
+			return vertexAnswer{}, duration, &vertexCallError{status: http.StatusInternalServerError, reasonCode: "VERTEX_REQUEST_MARSHAL_ERROR", message: "internal error"}
+		}
+
+		spanCtx, sp := startSpan(ctx, "vertex.generateContent", spanClient)
+		sp.setAttr("vertex.region", target.Region)
+		sp.setAttr("vertex.model", target.Model)
+		sp.setAttr("vertex.attempt", attempt)
+		res, cerr := callVertex(spanCtx, requestID(c), route.client, vertexURL, reqBytes)
+		observeVertexCall(target, res, cerr)
+		if cerr != nil {
+			sp.setAttr("http.status_code", cerr.status)
+			sp.fail(cerr.reasonCode)
+			sp.finish()
+			return vertexAnswer{Target: target}, duration + cerr.durationMs, cerr
+		}
+		duration += res.durationMs
+
+		answer, err := interpretVertexResponse(res.body)
+		answer.Target = target
+		answer.TruncationRetries = attempt
+		recordExplainUsage(c, target.Model, answer.Usage)
+		sp.setAttr("vertex.finish_reason", answer.FinishReason)
+		sp.setAttr("vertex.total_tokens", answer.Usage.TotalTokenCount)
+		if err == nil {
+			sp.finish()
+			return answer, duration, nil
+		}
+
+		var oerr *vertexOutcomeError
+		if !errors.As(err, &oerr) {
+			oerr = errVertexInvalid
+		}
+		sp.fail(oerr.reasonCode)
+		sp.finish()
+		// A retry only helps with a larger budget than the one just used.
+		if next := nextOutputBudget(tokens, limit); oerr == errVertexTruncated && attempt < truncationRetries() && next > tokens {
+			tokens = next
+			payload = withMaxOutputTokens(payload, tokens)
+			continue
+		}
+
+		return answer, duration, &vertexCallError{
+			status:     http.StatusBadGateway,
+			reasonCode: oerr.reasonCode,
+			message:    oerr.message,
+			extra:      gin.H{"metadata": answer.metadata(), "usage": explainUsage(c)},
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// stubVertexBodies answers successive generateContent calls with the given raw
+// response bodies, repeating the last one. It returns the call count and the
+// request bodies received.
+func stubVertexBodies(t *testing.T, bodies ...string) (*int, *[]string) {
+	t.Helper()
+	setupExplainTest(t)
+
+	calls := 0
+	var requests []string
+	newVertexClient = func(ctx context.Context) (*http.Client, error) {
+		return &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			reqBody, _ := io.ReadAll(req.Body)
+			req.Body.Close()
+			requests = append(requests, string(reqBody))
+			respBody := bodies[len(bodies)-1]
+			if calls < len(bodies) {
+				respBody = bodies[calls]
+			}
+			calls++
+			r := &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     make(http.Header),
+				Body:       io.NopCloser(strings.NewReader(respBody)),
+			}
+			r.Header.Set("Content-Type", "application/json")
+			return r, nil
+		})}, nil
+	}
+	return &calls, &requests
+}
+
+func postExplain(t *testing.T, body string) (int, map[string]any) {
+	t.Helper()
+	r := newRouter()
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/explain", bytes.NewReader([]byte(body)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-API-Key", "secret")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	var resp map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid JSON: %v; body=%s", err, w.Body.String())
+	}
+	return w.Code, resp
+}
+
+const explainMetrics = `{"score":88.5,"symmetry":0.92,"power":0.81,"consistency":0.77}`
+
+func TestExplainHandler_PromptBlocked(t *testing.T) {
+	stubVertexBodies(t, `{"promptFeedback":{"blockReason":"SAFETY","safetyRatings":[{"category":"HARM_CATEGORY_HARASSMENT","probability":"HIGH","blocked":true}]}}`)
+
+	code, resp := postExplain(t, explainMetrics)
+	if code != http.StatusBadGateway || resp["reason_code"] != "VERTEX_BLOCKED" {
+		t.Fatalf("want 502 VERTEX_BLOCKED, got %d %v", code, resp)
+	}
+	meta, _ := resp["metadata"].(map[string]any)
+	if meta["block_reason"] != "SAFETY" {
+		t.Fatalf("missing block reason: %v", meta)
+	}
+}
+
+func TestExplainHandler_CandidateSafetyStop(t *testing.T) {
+	stubVertexBodies(t, `{"candidates":[{"content":{"parts":[{"text":"partial"}]},"finishReason":"SAFETY"}]}`)
+
+	code, resp := postExplain(t, explainMetrics)
+	if code != http.StatusBadGateway || resp["reason_code"] != "VERTEX_BLOCKED" {
+		t.Fatalf("want 502 VERTEX_BLOCKED, got %d %v", code, resp)
+	}
+}
+
+func TestExplainHandler_TruncatedRetried(t *testing.T) {
+	t.Setenv("VERTEX_MAX_OUTPUT_TOKENS", "256")
+	calls, requests := stubVertexBodies(t,
+		`{"candidates":[{"content":{"parts":[{"text":"Metrics look"}]},"finishReason":"MAX_TOKENS"}]}`,
+		`{"candidates":[{"content":{"parts":[{"text":"Metrics look strong."}]},"finishReason":"STOP"}]}`,
+	)
+
+	code, resp := postExplain(t, explainMetrics)
+	if code != http.StatusOK {
+		t.Fatalf("want 200, got %d %v", code, resp)
+	}
+	if *calls != 2 {
+		t.Fatalf("want 2 vertex calls, got %d", *calls)
+	}
+	if !strings.Contains((*requests)[0], `"maxOutputTokens":256`) || !strings.Contains((*requests)[1], `"maxOutputTokens":512`) {
+		t.Fatalf("retry did not raise output budget: %s", (*requests)[1])
+	}
+	meta, _ := resp["metadata"].(map[string]any)
+	if meta["finish_reason"] != "STOP" || meta["truncation_retries"] != float64(1) {
+		t.Fatalf("unexpected metadata: %v", meta)
+	}
+}
+
+func TestExplainHandler_OutputUncappedByDefault(t *testing.T) {
+	calls, requests := stubVertexBodies(t,
+		`{"candidates":[{"content":{"parts":[{"text":"Metrics look"}]},"finishReason":"MAX_TOKENS"}]}`,
+		`{"candidates":[{"content":{"parts":[{"text":"Metrics look strong."}]},"finishReason":"STOP"}]}`,
+	)
+
+	code, resp := postExplain(t, explainMetrics)
+	if code != http.StatusOK || *calls != 2 {
+		t.Fatalf("want 200 after 2 calls, got %d after %d: %v", code, *calls, resp)
+	}
+	if strings.Contains((*requests)[0], "maxOutputTokens") {
+		t.Fatalf("first call should leave the output cap to the model: %s", (*requests)[0])
+	}
+	if !strings.Contains((*requests)[1], `"maxOutputTokens":8192`) {
+		t.Fatalf("retry should start from the configured limit: %s", (*requests)[1])
+	}
+}
+
+func TestExplainHandler_TruncatedAtModelLimitNotRetried(t *testing.T) {
+	t.Setenv("VERTEX_MAX_OUTPUT_TOKENS", "8192")
+	calls, requests := stubVertexBodies(t, `{"candidates":[{"content":{"parts":[{"text":"Metrics"}]},"finishReason":"MAX_TOKENS"}]}`)
+
+	code, resp := postExplain(t, explainMetrics)
+	if code != http.StatusBadGateway || resp["reason_code"] != "VERTEX_TRUNCATED" {
+		t.Fatalf("want 502 VERTEX_TRUNCATED, got %d %v", code, resp)
+	}
+	if *calls != 1 || !strings.Contains((*requests)[0], `"maxOutputTokens":8192`) {
+		t.Fatalf("want a single call at the model limit, got %d: %v", *calls, *requests)
+	}
+}
+
+func TestExplainHandler_TruncatedWithoutRetry(t *testing.T) {
+	t.Setenv("VERTEX_TRUNCATION_RETRIES", "0")
+	calls, _ := stubVertexBodies(t, `{"candidates":[{"content":{"parts":[{"text":"Metrics"}]},"finishReason":"MAX_TOKENS"}]}`)
+
+	code, resp := postExplain(t, explainMetrics)
+	if code != http.StatusBadGateway || resp["reason_code"] != "VERTEX_TRUNCATED" {
+		t.Fatalf("want 502 VERTEX_TRUNCATED, got %d %v", code, resp)
+	}
+	if *calls != 1 {
+		t.Fatalf("want 1 vertex call, got %d", *calls)
+	}
+}
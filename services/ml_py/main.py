@@ -5,6 +5,7 @@ import os
 import time
 from collections.abc import AsyncIterator
 from contextlib import asynccontextmanager
+from datetime import datetime, timezone
 
 from fastapi import FastAPI, HTTPException, Request, Response
 
@@ -17,6 +18,7 @@ logger = logging.getLogger(__name__)
 
 RUN_ID = f"{os.getenv('PICCA_SCRIPT_SHA', 'dev')}·{os.getenv('MODEL_CKPT_SHA', 'na')}"
 MAX_CAPTURE_BYTES = 1 << 20  # 1 MiB
+OTS_SCHEMA = "ots/1"  # keep in sync with services/api-go/ots
 
 
 @asynccontextmanager
@@ -28,6 +30,14 @@ async def lifespan(_: FastAPI) -> AsyncIterator[None]:
 app = FastAPI(lifespan=lifespan)
 
 
+def trace_id_from(traceparent: str) -> str:
+    """Trace ID from a W3C traceparent header, or blank when malformed."""
+    parts = traceparent.strip().split("-")
+    if len(parts) < 4 or len(parts[1]) != 32 or parts[1] == "0" * 32:
+        return ""
+    return parts[1]
+
+
 def sha16(data: bytes) -> str:
     return hashlib.sha256(data).hexdigest()[:16]
 
@@ -61,7 +71,7 @@ async def ots_middleware(request: Request, call_next):
     print(
         json.dumps(
             {
-                "ts": time.strftime("%Y-%m-%dT%H:%M:%SZ", time.gmtime()),
+                "ts": datetime.now(timezone.utc).isoformat(timespec="microseconds").replace("+00:00", "Z"),
                 "run_id": RUN_ID,
                 "path": request.url.path,
                 "status": getattr(response, "status_code", 0),
@@ -69,6 +79,7 @@ async def ots_middleware(request: Request, call_next):
                 "req_id": req_id,
                 "input_hash": in_hash,
                 "output_hash": out_hash,
+                "schema": OTS_SCHEMA,
             }
         )
     )
@@ -88,6 +99,7 @@ async def predict_endpoint(payload: KeypointsInput, request: Request) -> ScoreOu
             {
                 "service": "ml-py",
                 "request_id": req_id,
+                "trace_id": trace_id_from(request.headers.get("traceparent", "")),
                 "model_uri": model_uri,
                 "input_len": len(pts),
                 "infer_ms": infer_ms,